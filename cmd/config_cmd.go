@@ -0,0 +1,140 @@
+// cmd/config_cmd.go
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var migrateWrite bool
+
+// configCmd groups the configuration file commands
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the configuration file",
+}
+
+// configMigrateCmd converts a legacy environment-style configuration file
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate [file]",
+	Short: "Convert a legacy KEY=\"value\" configuration file to YAML",
+	Long: `Convert a configuration file written as shell variables (KEY="value")
+by earlier releases into the YAML format read with --config.
+
+The converted file is printed to stdout. With --write the file is replaced
+in place and the original is kept next to it with a .legacy suffix.
+
+The file defaults to --config, or ` + ConfigPath + `.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := configFilePath
+		if len(args) > 0 {
+			path = args[0]
+		}
+		if path == "" {
+			path = ConfigPath
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading config file: %w", err)
+		}
+		if !isLegacyConfig(content) {
+			return fmt.Errorf("%s is not a legacy KEY=\"value\" file", path)
+		}
+
+		env, err := readEnvFile(path)
+		if err != nil {
+			return err
+		}
+		values, ignored, err := legacyValues(env, cmd.Flags())
+		if err != nil {
+			return fmt.Errorf("converting %s: %w", path, err)
+		}
+		for _, key := range ignored {
+			fmt.Fprintf(os.Stderr, "Warning: %s is not a setting and was dropped\n", key)
+		}
+
+		out, err := marshalConfig(values)
+		if err != nil {
+			return fmt.Errorf("writing YAML: %w", err)
+		}
+
+		if !migrateWrite {
+			fmt.Print(string(out))
+			return nil
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		backup := path + ".legacy"
+		if err := os.WriteFile(backup, content, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("saving %s: %w", backup, err)
+		}
+		if err := os.WriteFile(path, out, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+		fmt.Printf("Converted %s (original saved as %s)\n", path, backup)
+		return nil
+	},
+}
+
+// configValidateCmd checks the configuration and the systems it refers to
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration and the connection to AdGuard Home",
+	Long: `Check every setting, the lease files, the connection to each sync target
+and the NDP table, and print what has to be fixed.
+
+The settings are resolved like serve does: flags, then environment variables,
+then the file given with --config.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		report := &validationReport{}
+		validateConfig(cmd.Context(), report)
+		if report.failures > 0 {
+			return fmt.Errorf("configuration has %d problem(s)", report.failures)
+		}
+		if report.warnings > 0 {
+			fmt.Printf("Configuration is valid, with %d warning(s)\n", report.warnings)
+			return nil
+		}
+		fmt.Println("Configuration is valid")
+		return nil
+	},
+}
+
+// configShowCmd prints the effective configuration
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration and where each value comes from",
+	Long: `Print the configuration as serve would use it, merged from flags,
+environment variables, the file given with --config and the defaults. Every
+value is annotated with its source; passwords are redacted.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, err := effectiveConfig(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		if configFilePath != "" {
+			fmt.Printf("# Configuration file: %s\n", configFilePath)
+		}
+		fmt.Print(string(out))
+		return nil
+	},
+}
+
+func init() {
+	configMigrateCmd.Flags().BoolVar(&migrateWrite, "write", false, "Replace the file instead of printing the result")
+	configCmd.AddCommand(configMigrateCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
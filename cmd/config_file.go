@@ -0,0 +1,464 @@
+// cmd/config_file.go
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+	"opnsense-lease-sync/pkg"
+)
+
+// configFile is a loaded configuration file
+type configFile struct {
+	path   string
+	values map[string]interface{}
+	legacy bool // Shell-style KEY="value" file, converted on load
+}
+
+var legacyLinePattern = regexp.MustCompile(`^(export\s+)?[A-Za-z_][A-Za-z0-9_]*=`)
+
+// loadConfigFile reads a YAML configuration file. A legacy shell-style file
+// of environment variables is converted on the fly, typing its values like
+// the matching flags.
+func loadConfigFile(path string, flags *pflag.FlagSet) (*configFile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	if isLegacyConfig(content) {
+		env, err := readEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		values, _, err := legacyValues(env, flags)
+		if err != nil {
+			return nil, fmt.Errorf("converting %s: %w", path, err)
+		}
+		return &configFile{path: path, values: values, legacy: true}, nil
+	}
+
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	if err := checkConfigKeys(values, ""); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return &configFile{path: path, values: values}, nil
+}
+
+// isLegacyConfig reports whether content consists of KEY=value lines only
+func isLegacyConfig(content []byte) bool {
+	assignments := 0
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !legacyLinePattern.MatchString(line) {
+			return false
+		}
+		assignments++
+	}
+	return assignments > 0
+}
+
+// lookup returns the value at a dotted key
+func (c *configFile) lookup(key string) (interface{}, bool) {
+	var current interface{} = c.values
+	for _, part := range strings.Split(key, ".") {
+		section, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = section[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// checkConfigKeys rejects keys that are not settings or sections of them
+func checkConfigKeys(values map[string]interface{}, prefix string) error {
+	for key, value := range values {
+		full := prefix + key
+		if settingByKey(full) != nil {
+			continue
+		}
+		if !isConfigSection(full) {
+			return fmt.Errorf("unknown setting %s", full)
+		}
+		if value == nil {
+			continue // Section with every setting commented out
+		}
+		section, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected a section", full)
+		}
+		if err := checkConfigKeys(section, full+"."); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settingByKey returns the setting stored at a configuration file key
+func settingByKey(key string) *setting {
+	for i := range settings {
+		if settings[i].key == key {
+			return &settings[i]
+		}
+	}
+	return nil
+}
+
+// isConfigSection reports whether key is a section holding settings
+func isConfigSection(key string) bool {
+	for _, s := range settings {
+		if strings.HasPrefix(s.key, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// scalarString formats a single configuration file value
+func scalarString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("expected a single value, got %v", value)
+}
+
+// definitionsFromFile converts the entries of a definition list. Entries are
+// maps holding the name under nameKey, or name:key=value,... specs. List
+// values repeat the key.
+func definitionsFromFile(kind, nameKey string, items []interface{}) ([]pkg.Definition, error) {
+	var defs []pkg.Definition
+	for i, item := range items {
+		if spec, ok := item.(string); ok {
+			def, err := pkg.ParseDefinition(kind, spec)
+			if err != nil {
+				return nil, err
+			}
+			defs = append(defs, def)
+			continue
+		}
+
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s %d: expected a map", kind, i+1)
+		}
+		name, err := scalarString(entry[nameKey])
+		if err != nil || name == "" {
+			return nil, fmt.Errorf("%s %d: %s is required", kind, i+1, nameKey)
+		}
+
+		def := pkg.Definition{Name: name}
+		keys := make([]string, 0, len(entry))
+		for key := range entry {
+			if key != nameKey {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			values, isList := entry[key].([]interface{})
+			if !isList {
+				values = []interface{}{entry[key]}
+			}
+			for _, value := range values {
+				str, err := scalarString(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s %s: %s: %w", kind, name, key, err)
+				}
+				def.Settings = append(def.Settings, pkg.Setting{Key: strings.ToLower(key), Value: str})
+			}
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+// legacyValues converts the variables of a legacy environment file into
+// configuration file values. Variables that are not settings are returned
+// separately.
+func legacyValues(env map[string]string, flags *pflag.FlagSet) (map[string]interface{}, []string, error) {
+	values := make(map[string]interface{})
+	known := make(map[string]bool)
+
+	for _, s := range settings {
+		raw, ok := env[s.env]
+		if !ok {
+			continue
+		}
+		known[s.env] = true
+
+		var value interface{}
+		switch {
+		case s.defs != nil:
+			defs, err := pkg.ParseDefinitions(s.kind, splitList(s, raw))
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+			entries := make([]interface{}, 0, len(defs))
+			for _, def := range defs {
+				entries = append(entries, definitionEntry(def, s.nameKey))
+			}
+			value = entries
+		case s.list:
+			var items []interface{}
+			for _, item := range splitList(s, raw) {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			value = items
+		default:
+			value = typedValue(flags.Lookup(s.flag), raw)
+		}
+		setConfigValue(values, s.key, value)
+	}
+
+	var ignored []string
+	for key := range env {
+		if !known[key] {
+			ignored = append(ignored, key)
+		}
+	}
+	sort.Strings(ignored)
+
+	return values, ignored, nil
+}
+
+// definitionEntry converts a definition into a configuration file entry;
+// repeated keys become lists
+func definitionEntry(def pkg.Definition, nameKey string) map[string]interface{} {
+	entry := map[string]interface{}{nameKey: def.Name}
+	for _, setting := range def.Settings {
+		switch existing := entry[setting.Key].(type) {
+		case nil:
+			entry[setting.Key] = setting.Value
+		case []interface{}:
+			entry[setting.Key] = append(existing, setting.Value)
+		default:
+			entry[setting.Key] = []interface{}{existing, setting.Value}
+		}
+	}
+	return entry
+}
+
+// typedValue converts an environment value to the type of the flag, so the
+// written configuration file uses YAML booleans and numbers
+func typedValue(f *pflag.Flag, raw string) interface{} {
+	if f == nil {
+		return raw
+	}
+	switch f.Value.Type() {
+	case "bool":
+		if enabled, err := strconv.ParseBool(raw); err == nil {
+			return enabled
+		}
+	case "int":
+		if i, err := strconv.Atoi(raw); err == nil {
+			return i
+		}
+	case "float64":
+		if v, err := strconv.ParseFloat(raw, 64); err == nil {
+			return v
+		}
+	}
+	return raw
+}
+
+// setConfigValue stores value at a dotted key, creating sections as needed
+func setConfigValue(values map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	section := values
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := section[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			section[part] = next
+		}
+		section = next
+	}
+	section[parts[len(parts)-1]] = value
+}
+
+// marshalConfig writes configuration values as YAML, ordering the sections
+// and settings like the settings table
+func marshalConfig(values map[string]interface{}) ([]byte, error) {
+	return marshalValue(values, "")
+}
+
+// marshalValue writes the value stored at the dotted key prefix as YAML
+func marshalValue(value interface{}, prefix string) ([]byte, error) {
+	return encodeNode(configNode(value, prefix))
+}
+
+// encodeNode writes a YAML node
+func encodeNode(node *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// configNode builds the YAML node for a value at the dotted key prefix
+func configNode(value interface{}, prefix string) *yaml.Node {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, key := range orderedKeys(v, prefix) {
+			keyNode := &yaml.Node{Kind: yaml.ScalarNode, Value: key}
+			node.Content = append(node.Content, keyNode, configNode(v[key], prefix+key+"."))
+		}
+		return node
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range v {
+			node.Content = append(node.Content, configNode(item, prefix))
+		}
+		return node
+	}
+
+	node := &yaml.Node{}
+	node.Encode(value)
+	return node
+}
+
+// orderedKeys sorts map keys by their first position in the settings table;
+// keys of list entries put the name first and the rest alphabetically
+func orderedKeys(values map[string]interface{}, prefix string) []string {
+	rank := func(key string) int {
+		full := prefix + key
+		for i, s := range settings {
+			if s.key == full || strings.HasPrefix(s.key, full+".") {
+				return i
+			}
+			if strings.HasPrefix(full, s.key+".") && key == s.nameKey {
+				return -1
+			}
+		}
+		return len(settings)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ri, rj := rank(keys[i]), rank(keys[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// redacted replaces passwords in the effective configuration
+const redacted = "********"
+
+// effectiveConfig writes the resolved value of every setting as YAML, with
+// the source of each value as a comment
+func effectiveConfig(flags *pflag.FlagSet) ([]byte, error) {
+	values := make(map[string]interface{})
+	comments := make(map[string]string)
+	for _, s := range settings {
+		f := flags.Lookup(s.flag)
+		if f == nil {
+			continue
+		}
+
+		var value interface{}
+		switch {
+		case s.defs != nil:
+			entries := make([]interface{}, 0, len(*s.defs))
+			for _, def := range *s.defs {
+				entry := definitionEntry(def, s.nameKey)
+				if _, ok := entry["password"]; ok {
+					entry["password"] = redacted
+				}
+				entries = append(entries, entry)
+			}
+			value = entries
+		case s.list:
+			items, _ := flags.GetStringArray(s.flag)
+			list := make([]interface{}, 0, len(items))
+			for _, item := range items {
+				list = append(list, item)
+			}
+			value = list
+		case s.flag == "password" && f.Value.String() != "":
+			value = redacted
+		default:
+			value = typedValue(f, f.Value.String())
+		}
+		setConfigValue(values, s.key, value)
+
+		switch source := settingSources[s.flag]; source {
+		case sourceFlag:
+			comments[s.key] = "flag --" + s.flag
+		case sourceEnv:
+			comments[s.key] = "env " + s.env
+		case "":
+			comments[s.key] = sourceDefault
+		default:
+			comments[s.key] = source
+		}
+	}
+
+	node := configNode(values, "")
+	annotateNode(node, "", comments)
+	return encodeNode(node)
+}
+
+// annotateNode adds the comments to the mapping keys they belong to
+func annotateNode(node *yaml.Node, prefix string, comments map[string]string) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		full := prefix + key.Value
+		if comment, ok := comments[full]; ok {
+			if value.Kind == yaml.SequenceNode && len(value.Content) == 0 {
+				value.Style = yaml.FlowStyle
+			}
+			if value.Kind == yaml.ScalarNode || value.Style == yaml.FlowStyle {
+				value.LineComment = comment
+			} else {
+				key.LineComment = comment
+			}
+			continue
+		}
+		annotateNode(value, full+".", comments)
+	}
+}
@@ -0,0 +1,219 @@
+// cmd/config_validate.go
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"opnsense-lease-sync/pkg"
+)
+
+// validationReport prints the outcome of each check and counts failures
+type validationReport struct {
+	failures int
+	warnings int
+}
+
+func (r *validationReport) ok(format string, args ...interface{}) {
+	fmt.Printf("OK    %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *validationReport) skip(format string, args ...interface{}) {
+	fmt.Printf("SKIP  %s\n", fmt.Sprintf(format, args...))
+}
+
+// warn reports a problem that doesn't stop the service from running
+func (r *validationReport) warn(message, hint string) {
+	r.warnings++
+	r.print("WARN", message, hint)
+}
+
+// fail reports a problem that has to be fixed
+func (r *validationReport) fail(message, hint string) {
+	r.failures++
+	r.print("FAIL", message, hint)
+}
+
+func (r *validationReport) print(status, message, hint string) {
+	fmt.Printf("%-5s %s\n", status, message)
+	if hint != "" {
+		fmt.Printf("      %s\n", hint)
+	}
+}
+
+// validateConfig checks the resolved settings and everything they refer to
+func validateConfig(ctx context.Context, r *validationReport) {
+	settingsOK := validateSettings(r)
+	validateLeaseFiles(ctx, r)
+	if settingsOK {
+		validateTargets(ctx, r)
+	} else {
+		r.skip("sync target connections, fix the settings first")
+	}
+	validateNDP(r)
+	validateOutputs(r)
+}
+
+// validateSettings checks the values and rule syntax of every setting
+func validateSettings(r *validationReport) bool {
+	errs := checkSettings()
+	if err := validateAdGuardFlags(serveCmd); err != nil {
+		errs = append([]error{err}, errs...)
+	}
+	for _, err := range errs {
+		r.fail(err.Error(), "")
+	}
+	if len(errs) > 0 {
+		return false
+	}
+
+	r.ok("settings: %d sync target(s), %d profile(s), %d tag rule(s), %d DNS output(s)",
+		len(serveConfig(nil, newLogConfig()).SyncTargets()), len(profiles), len(tagRuleDefs), len(dnsOutputs))
+	return true
+}
+
+// validateLeaseFiles checks that every lease file is readable and holds
+// leases in the configured format
+func validateLeaseFiles(ctx context.Context, r *validationReport) {
+	sources := leaseSources
+	fromList := len(sources) > 0
+	if !fromList {
+		sources = []pkg.LeaseSource{{Format: pkg.LeaseFormat(leaseFormat), Path: leasePath}}
+	}
+
+	for _, source := range sources {
+		path := source.Path
+		if _, err := os.Stat(path); err != nil {
+			r.fail(fmt.Sprintf("lease file %s: %v", path, err),
+				"Set leases.path (--lease-path) or leases.sources to the lease file of your DHCP server: /var/db/dnsmasq.leases for dnsmasq, /var/dhcpd/var/db/dhcpd.leases for ISC DHCP")
+			continue
+		}
+
+		detected, err := pkg.DetectLeaseFormat(path)
+		if err != nil {
+			hint := "Check that the file is a DHCP lease file"
+			if os.IsPermission(err) {
+				hint = "Make the file readable by the user running the service"
+			}
+			r.fail(fmt.Sprintf("lease file %s: %v", path, err), hint)
+			continue
+		}
+		if detected == "" {
+			r.warn(fmt.Sprintf("lease file %s holds no leases yet", path), "Leases appear once the DHCP server hands one out")
+			continue
+		}
+		if detected != source.Format {
+			hint := fmt.Sprintf("Set leases.format (--lease-format) to %s", detected)
+			if fromList {
+				hint = fmt.Sprintf("List it as %s:%s in leases.sources", detected, path)
+			}
+			r.fail(fmt.Sprintf("lease file %s holds %s leases but is read as %s", path, detected, source.Format), hint)
+			continue
+		}
+
+		var reader pkg.LeaseReader = pkg.NewDHCP(path)
+		if detected == pkg.DNSMasqFormat {
+			reader = pkg.NewDNSMasq(path)
+		}
+		leases, err := reader.GetLeases(ctx)
+		if err != nil {
+			r.fail(fmt.Sprintf("lease file %s: %v", path, err), "")
+			continue
+		}
+		r.ok("lease file %s: %s format, %d lease(s)", path, detected, len(leases))
+	}
+}
+
+// validateTargets connects to every sync target
+func validateTargets(ctx context.Context, r *validationReport) {
+	for _, target := range serveConfig(nil, newLogConfig()).SyncTargets() {
+		address := targetAddress(target)
+
+		timeout := time.Duration(target.Timeout) * time.Second
+		if timeout <= 0 {
+			timeout = 10 * time.Second
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, timeout+5*time.Second)
+		status, err := pkg.CheckTarget(checkCtx, target)
+		cancel()
+
+		if err != nil {
+			r.fail(fmt.Sprintf("sync target %s (%s): %v", target.Name, address, err), targetHint(target, address, err))
+			continue
+		}
+		if status.Version != "" {
+			r.ok("sync target %s (%s): version %s, %d client(s)", target.Name, address, status.Version, len(status.Clients))
+		} else {
+			r.ok("sync target %s (%s): %d client(s)", target.Name, address, len(status.Clients))
+		}
+	}
+}
+
+// targetAddress returns the URL of a target including its scheme
+func targetAddress(target pkg.TargetConfig) string {
+	if target.Scheme != "" {
+		return target.Scheme + "://" + target.URL
+	}
+	return target.URL
+}
+
+// targetHint suggests how to fix a failed connection to a target
+func targetHint(target pkg.TargetConfig, address string, err error) string {
+	switch pkg.ErrorKindOf(err) {
+	case pkg.ErrorAuth:
+		if len(targets) == 0 {
+			return "Check adguard.username and adguard.password (--username, --password)"
+		}
+		return fmt.Sprintf("Check the username and password of target %s", target.Name)
+	case pkg.ErrorTransient:
+		product := "AdGuard Home"
+		if target.Type == pkg.PiHoleTarget {
+			product = "Pi-hole"
+		}
+		return fmt.Sprintf("Check that %s is running and reachable at %s, including the scheme and port", product, address)
+	}
+	return ""
+}
+
+// validateNDP checks that the NDP table, the source of IPv6 addresses, can
+// be read
+func validateNDP(r *validationReport) {
+	if _, err := exec.LookPath("ndp"); err != nil {
+		r.fail("ndp command not found", "IPv6 addresses are read with ndp(8), which is part of FreeBSD; run the service on OPNsense")
+		return
+	}
+
+	watcher, err := pkg.NewNDPTableWatcher(pkg.NDPTableWatcherConfig{})
+	if err != nil {
+		r.fail(fmt.Sprintf("NDP table: %v", err), "Run the service as root so ndp -an can read the neighbor table")
+		return
+	}
+	r.ok("NDP table: %d MAC address(es)", len(watcher.GetTable()))
+}
+
+// validateOutputs checks the files written or read besides the lease files
+func validateOutputs(r *validationReport) {
+	if syncStaticLeases {
+		mappings, err := pkg.ReadStaticMappings(opnsenseConfigPath)
+		if err != nil {
+			r.fail(fmt.Sprintf("OPNsense configuration %s: %v", opnsenseConfigPath, err),
+				"Set static_leases.opnsense_config (--opnsense-config) to the OPNsense config.xml")
+		} else {
+			r.ok("OPNsense configuration %s: %d static mapping(s)", opnsenseConfigPath, len(mappings))
+		}
+	}
+
+	for _, output := range dnsOutputs {
+		dir := filepath.Dir(output.Path)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			r.fail(fmt.Sprintf("DNS output %s: directory %s does not exist", output.Name, dir),
+				"Create the directory or change the output's path")
+			continue
+		}
+		r.ok("DNS output %s: %s", output.Name, output.Path)
+	}
+}
@@ -0,0 +1,307 @@
+// cmd/doctor.go
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"opnsense-lease-sync/pkg"
+)
+
+var (
+	doctorBundle   string
+	doctorLogLines int
+)
+
+// doctorLogTail is how much of the end of the log file is searched
+const doctorLogTail = 1 << 20
+
+// doctorCmd collects everything needed to debug missing clients
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose why devices don't show up in AdGuard Home",
+	Long: `Check the sync targets, lease files, NDP table, service state and recent
+log errors, and print a report.
+
+With --bundle the report, the effective configuration and the end of the log
+file are also written to a .tar.gz file for attaching to an issue. Passwords
+are redacted everywhere.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var report bytes.Buffer
+		runDoctor(cmd.Context(), io.MultiWriter(os.Stdout, &report))
+
+		if doctorBundle == "" {
+			return nil
+		}
+		if err := writeDoctorBundle(cmd, doctorBundle, report.String()); err != nil {
+			return fmt.Errorf("writing bundle: %w", err)
+		}
+		fmt.Printf("\nReport bundle written to %s\n", doctorBundle)
+		return nil
+	},
+}
+
+// runDoctor writes the diagnostic report
+func runDoctor(ctx context.Context, w io.Writer) {
+	out := func(format string, args ...interface{}) {
+		fmt.Fprintln(w, redactSecrets(fmt.Sprintf(format, args...)))
+	}
+
+	out("== dhcp-adguard-sync ==")
+	out("Version: %s (commit %s, built %s)", version, commit, date)
+	out("Runtime: %s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	out("Generated: %s", time.Now().Format(time.RFC3339))
+	if configFilePath != "" {
+		out("Config file: %s", configFilePath)
+	} else {
+		out("Config file: none, run with --config to check the service's settings")
+	}
+	for _, err := range checkSettings() {
+		out("Setting problem: %v", err)
+	}
+
+	// Leases first, so the targets can report which leases have a client
+	out("")
+	out("== Lease files ==")
+	active := make(map[string]bool)
+	for _, source := range configuredLeaseSources() {
+		var reader pkg.LeaseReader = pkg.NewDHCP(source.Path)
+		if source.Format == pkg.DNSMasqFormat {
+			reader = pkg.NewDNSMasq(source.Path)
+		}
+		leases, err := reader.GetLeases(ctx)
+		if err != nil {
+			out("%s (%s): %v", source.Path, source.Format, err)
+			continue
+		}
+
+		var activeCount, nameless int
+		for mac, lease := range leases {
+			if lease.IsActive {
+				activeCount++
+				active[strings.ToLower(mac)] = true
+			}
+			if lease.Hostname == "" {
+				nameless++
+			}
+		}
+		out("%s (%s): %d lease(s), %d active, %d inactive, %d without hostname",
+			source.Path, source.Format, len(leases), activeCount, len(leases)-activeCount, nameless)
+		if detected, err := pkg.DetectLeaseFormat(source.Path); err == nil && detected != "" && detected != source.Format {
+			out("  The file holds %s leases, check the lease format", detected)
+		}
+	}
+	if adguardDHCPLeases {
+		out("AdGuard Home DHCP leases are read from the first AdGuard target")
+	}
+
+	out("")
+	out("== Sync targets ==")
+	for _, target := range serveConfig(nil, newLogConfig()).SyncTargets() {
+		address := targetAddress(target)
+		checkCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+		status, err := pkg.CheckTarget(checkCtx, target)
+		cancel()
+		if err != nil {
+			out("%s (%s): %v", target.Name, address, err)
+			if hint := targetHint(target, address, err); hint != "" {
+				out("  %s", hint)
+			}
+			continue
+		}
+
+		synced := 0
+		for _, client := range status.Clients {
+			if active[strings.ToLower(client.MAC)] {
+				synced++
+			}
+		}
+		version := status.Version
+		if version == "" {
+			version = "unknown version"
+		}
+		out("%s (%s): %s, %d client(s), %d of %d active lease(s) have a client",
+			target.Name, address, version, len(status.Clients), synced, len(active))
+	}
+
+	out("")
+	out("== NDP table ==")
+	if watcher, err := pkg.NewNDPTableWatcher(pkg.NDPTableWatcherConfig{}); err != nil {
+		out("Reading the NDP table failed: %v", err)
+	} else {
+		table := watcher.GetTable()
+		withIPv6 := 0
+		for mac := range table {
+			if active[strings.ToLower(mac)] {
+				withIPv6++
+			}
+		}
+		out("%d MAC address(es), %d of %d active lease(s) have IPv6 addresses", len(table), withIPv6, len(active))
+	}
+
+	out("")
+	out("== Service ==")
+	if running, pid := isServiceRunning(); running {
+		out("Running as pid %d", pid)
+	} else {
+		out("Not running")
+	}
+	if info, err := os.Stat(stateFile); err == nil {
+		out("State file %s, last written %s", stateFile, info.ModTime().Format(time.RFC3339))
+	}
+
+	out("")
+	out("== Recent log errors ==")
+	if logFile == "" {
+		out("No log file configured, the service logs to syslog")
+		return
+	}
+	lines, err := tailLog(logFile, doctorLogLines, func(line string) bool {
+		return strings.Contains(line, "[ERROR]") || strings.Contains(line, "[WARN]") ||
+			strings.Contains(line, `"level":"ERROR"`) || strings.Contains(line, `"level":"WARN"`)
+	})
+	if err != nil {
+		out("Reading %s failed: %v", logFile, err)
+		return
+	}
+	if len(lines) == 0 {
+		out("No errors or warnings in %s", logFile)
+	}
+	for _, line := range lines {
+		out("%s", line)
+	}
+}
+
+// configuredLeaseSources returns the lease files the service reads
+func configuredLeaseSources() []pkg.LeaseSource {
+	if len(leaseSources) > 0 {
+		return leaseSources
+	}
+	format := pkg.LeaseFormat(leaseFormat)
+	if format != pkg.DNSMasqFormat {
+		format = pkg.ISCDHCPFormat
+	}
+	return []pkg.LeaseSource{{Format: format, Path: leasePath}}
+}
+
+// tailLog returns the last n lines of the end of a log file accepted by keep
+func tailLog(path string, n int, keep func(string) bool) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - doctorLogTail
+	if offset < 0 {
+		offset = 0
+	}
+	content := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(content, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if offset > 0 && len(lines) > 0 {
+		lines = lines[1:] // Most likely cut off
+	}
+	var kept []string
+	for _, line := range lines {
+		if line != "" && (keep == nil || keep(line)) {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) > n {
+		kept = kept[len(kept)-n:]
+	}
+	return kept, nil
+}
+
+// minRedactLength is the shortest password replaced in the report; shorter
+// ones would mangle unrelated text
+const minRedactLength = 4
+
+// redactSecrets replaces every configured password in s
+func redactSecrets(s string) string {
+	secrets := []string{password}
+	for _, target := range targets {
+		secrets = append(secrets, target.Password)
+	}
+	for _, secret := range secrets {
+		if len(secret) >= minRedactLength {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+	}
+	return s
+}
+
+// bundleFile is a file of the report bundle
+type bundleFile struct {
+	name    string
+	content string
+}
+
+// writeDoctorBundle writes the report, the effective configuration and the
+// end of the log file to a .tar.gz file
+func writeDoctorBundle(cmd *cobra.Command, path, report string) error {
+	config, err := effectiveConfig(cmd.Flags())
+	if err != nil {
+		return err
+	}
+	files := []bundleFile{
+		{"report.txt", report},
+		{"config.yaml", string(config)},
+	}
+	if logFile != "" {
+		if lines, err := tailLog(logFile, 500, nil); err == nil {
+			files = append(files, bundleFile{"log.txt", redactSecrets(strings.Join(lines, "\n") + "\n")})
+		}
+	}
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	archive := tar.NewWriter(gz)
+	now := time.Now()
+	for _, file := range files {
+		header := &tar.Header{Name: file.name, Mode: 0600, Size: int64(len(file.content)), ModTime: now}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := archive.Write([]byte(file.content)); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func init() {
+	doctorCmd.Flags().StringVar(&doctorBundle, "bundle", "", "Also write a redacted report bundle (.tar.gz) for attaching to an issue")
+	doctorCmd.Flags().IntVar(&doctorLogLines, "log-lines", 20, "Number of recent log errors and warnings to show")
+	rootCmd.AddCommand(doctorCmd)
+}
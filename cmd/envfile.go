@@ -0,0 +1,125 @@
+// cmd/envfile.go
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envFileKeys are the variables set from the environment file by the
+// previous load, so keys deleted from the file can be unset on reload
+var envFileKeys = map[string]bool{}
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// applyEnvFile sets the variables from the environment file at path,
+// unsetting those a previous load set that are no longer present
+func applyEnvFile(path string) error {
+	values, err := readEnvFile(path)
+	if err != nil {
+		return err
+	}
+
+	for key := range envFileKeys {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+		}
+	}
+	envFileKeys = make(map[string]bool, len(values))
+	for key, value := range values {
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("setting %s: %w", key, err)
+		}
+		envFileKeys[key] = true
+	}
+	return nil
+}
+
+// readEnvFile parses the KEY="value" lines of the shell-style config file
+// sourced by the rc script. Values may be double, single or not quoted and
+// be followed by a # comment.
+func readEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening environment file: %w", err)
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, rest, found := strings.Cut(line, "=")
+		if !found || !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value", path, lineNumber)
+		}
+
+		value, err := parseEnvValue(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading environment file: %w", err)
+	}
+
+	return values, nil
+}
+
+// parseEnvValue unquotes a value and drops a trailing comment
+func parseEnvValue(raw string) (string, error) {
+	var value strings.Builder
+	var rest string
+
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		i := 1
+		for ; i < len(raw); i++ {
+			c := raw[i]
+			if c == '\\' && i+1 < len(raw) && strings.ContainsRune("\"\\$`", rune(raw[i+1])) {
+				value.WriteByte(raw[i+1])
+				i++
+				continue
+			}
+			if c == '"' {
+				break
+			}
+			value.WriteByte(c)
+		}
+		if i >= len(raw) {
+			return "", fmt.Errorf("unterminated double quote")
+		}
+		rest = raw[i+1:]
+	case strings.HasPrefix(raw, "'"):
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quote")
+		}
+		value.WriteString(raw[1 : end+1])
+		rest = raw[end+2:]
+	default:
+		end := strings.IndexAny(raw, " \t")
+		if end < 0 {
+			return raw, nil
+		}
+		value.WriteString(raw[:end])
+		rest = raw[end:]
+	}
+
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected text after value: %s", rest)
+	}
+	return value.String(), nil
+}
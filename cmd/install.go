@@ -8,7 +8,11 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"text/template"
+
+	"opnsense-lease-sync/pkg"
 )
 
 // ConfigTemplate represents the structure for config file template
@@ -29,6 +33,52 @@ type ConfigTemplate struct {
 	MaxBackups           int
 	MaxAge               int
 	NoCompress           bool
+	Targets              string // List settings, rendered as YAML by listYAML
+	LeaseSources         string
+	Profiles             string
+	ReapplyProfiles      bool
+	TagRules             string
+	OUIFile              string
+	RewriteDomain        string
+	AdGuardDHCPLeases    bool
+	SyncStaticLeases     bool
+	DNSOutputs           string
+}
+
+// listYAML renders the items of a list setting as YAML for the config
+// template, indented to sit below the setting's key
+func listYAML(key string, specs []string) (string, error) {
+	s := settingByKey(key)
+	var items []interface{}
+	if s.defs != nil {
+		defs, err := pkg.ParseDefinitions(s.kind, specs)
+		if err != nil {
+			return "", err
+		}
+		for _, def := range defs {
+			items = append(items, definitionEntry(def, s.nameKey))
+		}
+	} else {
+		for _, spec := range specs {
+			if spec = strings.TrimSpace(spec); spec != "" {
+				items = append(items, spec)
+			}
+		}
+	}
+	if len(items) == 0 {
+		return "", nil
+	}
+
+	out, err := marshalValue(items, key+".")
+	if err != nil {
+		return "", err
+	}
+	indent := strings.Repeat("  ", strings.Count(key, ".")+1)
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	for i := range lines {
+		lines[i] = indent + lines[i]
+	}
+	return strings.Join(lines, "\n"), nil
 }
 
 // copyFile copies a file from src to ds
@@ -106,6 +156,27 @@ Use --dry-run to preview what would be written without making any changes.`,
 			MaxBackups:           maxBackups,
 			MaxAge:               maxAge,
 			NoCompress:           noCompress,
+			ReapplyProfiles:      reapplyProfiles,
+			OUIFile:              ouiFile,
+			RewriteDomain:        rewriteDomain,
+			AdGuardDHCPLeases:    adguardDHCPLeases,
+			SyncStaticLeases:     syncStaticLeases,
+		}
+		lists := []struct {
+			field *string
+			key   string
+			specs []string
+		}{
+			{&configTemplate.Targets, "targets", targetSpecs},
+			{&configTemplate.LeaseSources, "leases.sources", leaseSourceSpecs},
+			{&configTemplate.Profiles, "profiles.rules", profileSpecs},
+			{&configTemplate.TagRules, "tags.rules", tagRuleSpecs},
+			{&configTemplate.DNSOutputs, "dns.outputs", dnsOutputSpecs},
+		}
+		for _, list := range lists {
+			if *list.field, err = listYAML(list.key, list.specs); err != nil {
+				return fmt.Errorf("failed to render %s: %w", list.key, err)
+			}
 		}
 
 		// Read template conten
@@ -115,7 +186,7 @@ Use --dry-run to preview what would be written without making any changes.`,
 		}
 
 		// Parse and execute template
-		tmpl, err := template.New("config").Parse(string(templateContent))
+		tmpl, err := template.New("config").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(string(templateContent))
 		if err != nil {
 			return fmt.Errorf("failed to parse config template: %w", err)
 		}
@@ -3,10 +3,13 @@ package cmd
 
 import (
 	"fmt"
+	"net/url"
 	"os"
-	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"opnsense-lease-sync/pkg"
 )
 
 var (
@@ -20,9 +23,55 @@ var (
 	timeout              int
 	preserveDeletedHosts bool
 	debug                bool
+	clientCacheTTL       time.Duration
+	workers              int
+	requestsPerSecond    float64
+	reconcileInterval    time.Duration
+
+	// Client profiles
+	profileSpecs    []string
+	reapplyProfiles bool
+	profiles        pkg.ProfileSet
+
+	// Client tag rules
+	tagRuleSpecs []string
+	ouiFile      string
+	tagMapper    *pkg.TagMapper
+
+	// DNS rewrites
+	rewriteDomain string
+	stateFile     string
+
+	// Sync targets
+	targetSpecs []string
+	targets     []pkg.TargetConfig
+
+	// Local DNS file outputs
+	dnsOutputSpecs []string
+	dnsOutputs     []pkg.DNSOutputConfig
+
+	// AdGuard DHCP integration
+	adguardDHCPLeases  bool
+	leasePollInterval  time.Duration
+	syncStaticLeases   bool
+	opnsenseConfigPath string
+
+	// Configuration file and environment file, both re-read on reload
+	configFilePath string
+	envFile        string
+
+	// Local control socket and HTTP listener of the running service
+	controlSocket  string
+	httpListen     string
+	readyIntervals int
+
+	// Additional lease files
+	leaseSourceSpecs []string
+	leaseSources     []pkg.LeaseSource
 
 	// Logging configuration
 	logLevel   string
+	logFormat  string
 	logFile    string
 	maxLogSize int
 	maxBackups int
@@ -37,25 +86,90 @@ func validateAdGuardFlags(cmd *cobra.Command) error {
 		return nil
 	}
 
-	// Check for environment variables
-	if envUser := os.Getenv("ADGUARD_USERNAME"); envUser != "" && !cmd.Flags().Changed("username") {
-		username = envUser
-	}
-	if envPass := os.Getenv("ADGUARD_PASSWORD"); envPass != "" && !cmd.Flags().Changed("password") {
-		password = envPass
+	// Explicit targets carry their own credentials
+	if len(targetDefs) > 0 {
+		return nil
 	}
 
 	// Validate required flags for AdGuard interaction
 	if username == "" {
-		return fmt.Errorf("username is required for %s command. Set via --username flag or ADGUARD_USERNAME environment variable", cmd.Name())
+		return fmt.Errorf("username is required for %s command. Set via --username flag, ADGUARD_USERNAME environment variable or adguard.username in the config file", cmd.Name())
 	}
 	if password == "" {
-		return fmt.Errorf("password is required for %s command. Set via --password flag or ADGUARD_PASSWORD environment variable", cmd.Name())
+		return fmt.Errorf("password is required for %s command. Set via --password flag, ADGUARD_PASSWORD environment variable or adguard.password in the config file", cmd.Name())
 	}
 
 	return nil
 }
 
+// checkSettings validates the resolved settings and parses the list
+// settings, returning every problem found
+func checkSettings() []error {
+	var errs []error
+	check := func(failed bool, format string, args ...interface{}) {
+		if failed {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(timeout <= 0, "timeout must be greater than 0")
+	check(scheme != "http" && scheme != "https", "scheme must be either 'http' or 'https'")
+	if len(targetDefs) == 0 {
+		check(!validHostPort(adguardURL), "adguard-url must be host:port, e.g. 127.0.0.1:3000, got %q", adguardURL)
+	}
+	check(leaseFormat != string(pkg.ISCDHCPFormat) && leaseFormat != string(pkg.DNSMasqFormat), "lease-format must be either 'isc' or 'dnsmasq'")
+
+	check(logFormat != pkg.LogFormatText && logFormat != pkg.LogFormatJSON, "log-format must be either 'text' or 'json'")
+	check(maxLogSize <= 0, "max-log-size must be greater than 0")
+	check(maxBackups < 0, "max-backups cannot be negative")
+	check(maxAge < 0, "max-age cannot be negative")
+
+	check(workers <= 0, "workers must be greater than 0")
+	check(requestsPerSecond < 0, "requests-per-second cannot be negative")
+	check(clientCacheTTL < 0, "client-cache-ttl cannot be negative")
+	check(reconcileInterval < 0, "reconcile-interval cannot be negative")
+	check(leasePollInterval <= 0, "lease-poll-interval must be greater than 0")
+	check(readyIntervals <= 0, "ready-intervals must be greater than 0")
+
+	var err error
+	if targets, err = pkg.TargetsFromDefinitions(targetDefs); err != nil {
+		errs = append(errs, fmt.Errorf("invalid sync target: %w", err))
+	}
+
+	if leaseSources, err = pkg.ParseLeaseSources(leaseSourceSpecs); err != nil {
+		errs = append(errs, fmt.Errorf("invalid lease source: %w", err))
+	}
+
+	if dnsOutputs, err = pkg.DNSOutputsFromDefinitions(dnsOutputDefs); err != nil {
+		errs = append(errs, fmt.Errorf("invalid DNS output: %w", err))
+	}
+
+	if profiles, err = pkg.ProfilesFromDefinitions(profileDefs); err != nil {
+		errs = append(errs, fmt.Errorf("invalid client profile: %w", err))
+	}
+
+	var ouiDB *pkg.OUIDatabase
+	if ouiFile != "" {
+		if ouiDB, err = pkg.LoadOUIDatabase(ouiFile); err != nil {
+			return append(errs, fmt.Errorf("loading OUI file: %w", err))
+		}
+	}
+	if tagMapper, err = pkg.NewTagMapperFromDefinitions(tagRuleDefs, ouiDB); err != nil {
+		errs = append(errs, fmt.Errorf("invalid tag rule: %w", err))
+	}
+
+	return errs
+}
+
+// validHostPort reports whether an AdGuard Home address is a host with an
+// optional port. A leading http:// or https:// is ignored like the client
+// does.
+func validHostPort(address string) bool {
+	host := strings.TrimPrefix(strings.TrimPrefix(address, "http://"), "https://")
+	u, err := url.Parse("http://" + host)
+	return err == nil && u.Hostname() != "" && (u.Path == "" || u.Path == "/") && u.RawQuery == ""
+}
+
 // rootCmd represents the base command
 var rootCmd = &cobra.Command{
 	Use:   "dhcp-adguard-sync",
@@ -66,55 +180,34 @@ to AdGuard Home, keeping client configurations in sync automatically.
 Can be run either as a one-time sync (CLI mode) or as a persistent service
 that watches for lease file changes.`,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		// Check for other environment variables
-		if envURL := os.Getenv("ADGUARD_URL"); envURL != "" && !cmd.Flags().Changed("adguard-url") {
-			adguardURL = envURL
-		}
-		if envLease := os.Getenv("DHCP_LEASE_PATH"); envLease != "" && !cmd.Flags().Changed("lease-path") {
-			leasePath = envLease
-		}
-		if envLeaseFormat := os.Getenv("LEASE_FORMAT"); envLeaseFormat != "" && !cmd.Flags().Changed("lease-format") {
-			leaseFormat = envLeaseFormat
-		}
-		if envScheme := os.Getenv("ADGUARD_SCHEME"); envScheme != "" && !cmd.Flags().Changed("scheme") {
-			scheme = envScheme
-		}
-		if envTimeout := os.Getenv("ADGUARD_TIMEOUT"); envTimeout != "" && !cmd.Flags().Changed("timeout") {
-			if t, err := strconv.Atoi(envTimeout); err == nil {
-				timeout = t
+		// Settings from the environment file take the place of the
+		// environment variables the rc script exports
+		if envFile != "" {
+			if err := applyEnvFile(envFile); err != nil {
+				return err
 			}
 		}
-		if envDryRun := os.Getenv("DRY_RUN"); envDryRun != "" && !cmd.Flags().Changed("dry-run") {
-			dryRun = envDryRun == "true" || envDryRun == "1"
-		}
-		if envPreserve := os.Getenv("PRESERVE_DELETED_HOSTS"); envPreserve != "" && !cmd.Flags().Changed("preserve-deleted-hosts") {
-			preserveDeletedHosts = envPreserve == "true" || envPreserve == "1"
-		}
 
-		// Check for logging environment variables
-		if envLogLevel := os.Getenv("LOG_LEVEL"); envLogLevel != "" && !cmd.Flags().Changed("log-level") {
-			logLevel = envLogLevel
-		}
-		if envLogFile := os.Getenv("LOG_FILE"); envLogFile != "" && !cmd.Flags().Changed("log-file") {
-			logFile = envLogFile
-		}
-		if envMaxSize := os.Getenv("MAX_LOG_SIZE"); envMaxSize != "" && !cmd.Flags().Changed("max-log-size") {
-			if size, err := strconv.Atoi(envMaxSize); err == nil {
-				maxLogSize = size
+		// Flags win over environment variables, which win over the config
+		// file; anything left keeps its default
+		var file *configFile
+		if configFilePath != "" {
+			var err error
+			if file, err = loadConfigFile(configFilePath, cmd.Flags()); err != nil {
+				return err
 			}
-		}
-		if envMaxBackups := os.Getenv("MAX_BACKUPS"); envMaxBackups != "" && !cmd.Flags().Changed("max-backups") {
-			if backups, err := strconv.Atoi(envMaxBackups); err == nil {
-				maxBackups = backups
+			if file.legacy && cmd.Name() != "migrate" {
+				fmt.Fprintf(os.Stderr, "Warning: %s uses the legacy KEY=\"value\" format; convert it with: dhcp-adguard-sync config migrate --write %s\n", configFilePath, configFilePath)
 			}
 		}
-		if envMaxAge := os.Getenv("MAX_AGE"); envMaxAge != "" && !cmd.Flags().Changed("max-age") {
-			if age, err := strconv.Atoi(envMaxAge); err == nil {
-				maxAge = age
-			}
+		if err := resolveSettings(cmd, file); err != nil {
+			return err
 		}
-		if envNoCompress := os.Getenv("NO_COMPRESS"); envNoCompress != "" && !cmd.Flags().Changed("no-compress") {
-			noCompress = envNoCompress == "true" || envNoCompress == "1"
+
+		// The config and doctor commands report problems themselves, and
+		// status and trigger only need the control socket
+		if cmd.Name() == "doctor" || cmd.Name() == "status" || cmd.Name() == "trigger" || cmd.HasParent() && cmd.Parent().Name() == "config" {
+			return nil
 		}
 
 		// Validate AdGuard flags conditionally
@@ -123,22 +216,8 @@ that watches for lease file changes.`,
 		}
 
 		// Always validate other settings
-		if timeout <= 0 {
-			return fmt.Errorf("timeout must be greater than 0")
-		}
-
-		if scheme != "http" && scheme != "https" {
-			return fmt.Errorf("scheme must be either 'http' or 'https'")
-		}
-
-		if maxLogSize <= 0 {
-			return fmt.Errorf("max-log-size must be greater than 0")
-		}
-		if maxBackups < 0 {
-			return fmt.Errorf("max-backups cannot be negative")
-		}
-		if maxAge < 0 {
-			return fmt.Errorf("max-age cannot be negative")
+		if errs := checkSettings(); len(errs) > 0 {
+			return errs[0]
 		}
 
 		return nil
@@ -159,14 +238,48 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&adguardURL, "adguard-url", "127.0.0.1:3000", "AdGuard Home host:port")
 	rootCmd.PersistentFlags().StringVar(&leasePath, "lease-path", "/var/db/dnsmasq.leases", "Path to DHCP leases file")
 	rootCmd.PersistentFlags().StringVar(&leaseFormat, "lease-format", "dnsmasq", "DHCP lease file format (isc or dnsmasq)")
+	rootCmd.PersistentFlags().StringArrayVar(&leaseSourceSpecs, "lease-source", nil, "Lease file as format:path, e.g. isc:/var/dhcpd/var/db/dhcpd.leases (repeatable; replaces --lease-path and --lease-format)")
 	rootCmd.PersistentFlags().StringVar(&scheme, "scheme", "http", "Connection scheme (http/https)")
 	rootCmd.PersistentFlags().IntVar(&timeout, "timeout", 10, "API timeout in seconds")
 	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Dry run mode (print actions instead of executing)")
 	rootCmd.PersistentFlags().BoolVar(&preserveDeletedHosts, "preserve-deleted-hosts", false, "Don't remove AdGuard clients when their DHCP leases expire")
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Show debug info")
+	rootCmd.PersistentFlags().IntVar(&workers, "workers", pkg.DefaultWorkers, "Number of client changes applied to a target concurrently")
+	rootCmd.PersistentFlags().Float64Var(&requestsPerSecond, "requests-per-second", 0, "Maximum API requests per second per target (0 is unlimited)")
+	rootCmd.PersistentFlags().DurationVar(&reconcileInterval, "reconcile-interval", pkg.DefaultReconcileInterval, "Interval of the periodic full sync, randomized by up to 10% (0 disables it)")
+	rootCmd.PersistentFlags().DurationVar(&clientCacheTTL, "client-cache-ttl", pkg.DefaultClientCacheTTL, "How long a fetched AdGuard client list is reused (0 disables caching)")
+
+	// Add sync target flags
+	rootCmd.PersistentFlags().StringArrayVar(&targetSpecs, "target", nil, "Sync target as name:type=adguard,url=...,username=...,password=... (repeatable; replaces the top-level AdGuard settings)")
+
+	// Add client profile flags
+	rootCmd.PersistentFlags().StringArrayVar(&profileSpecs, "profile", nil, "Client profile as name:key=value,... (repeatable, first match wins)")
+	rootCmd.PersistentFlags().BoolVar(&reapplyProfiles, "reapply-profiles", false, "Re-apply profile settings to existing clients on update")
+
+	// Add client tag flags
+	rootCmd.PersistentFlags().StringArrayVar(&tagRuleSpecs, "tag-rule", nil, "Client tag rule as tag:key=value,... (repeatable)")
+	rootCmd.PersistentFlags().StringVar(&ouiFile, "oui-file", "", "OUI vendor database (IEEE oui.txt, nmap-mac-prefixes or Wireshark manuf) for vendor tag rules")
+
+	// Add DNS rewrite flags
+	rootCmd.PersistentFlags().StringVar(&rewriteDomain, "rewrite-domain", "", "Manage AdGuard DNS rewrites as hostname.<domain> (disabled when empty)")
+	rootCmd.PersistentFlags().StringArrayVar(&dnsOutputSpecs, "dns-output", nil, "Write lease hostnames to a file as name:format=unbound|hosts,path=...,domain=...,reload=... (repeatable)")
+	rootCmd.PersistentFlags().StringVar(&stateFile, "state-file", "/var/db/dhcp-adguard-sync/state.json", "File recording entries created by this service")
+
+	// Add AdGuard DHCP flags
+	rootCmd.PersistentFlags().BoolVar(&adguardDHCPLeases, "adguard-dhcp-leases", false, "Also read leases from AdGuard Home's own DHCP server")
+	rootCmd.PersistentFlags().DurationVar(&leasePollInterval, "lease-poll-interval", 30*time.Second, "Polling interval for lease sources that cannot be watched")
+	rootCmd.PersistentFlags().BoolVar(&syncStaticLeases, "sync-static-leases", false, "Push OPNsense static DHCP mappings into AdGuard Home's static leases")
+	rootCmd.PersistentFlags().StringVar(&opnsenseConfigPath, "opnsense-config", "/conf/config.xml", "OPNsense configuration file containing the static mappings")
+
+	rootCmd.PersistentFlags().StringVar(&configFilePath, "config", "", "YAML configuration file; flags and environment variables override its settings")
+	rootCmd.PersistentFlags().StringVar(&envFile, "env-file", "", "Read settings from a KEY=\"value\" environment file (serve re-reads it on SIGHUP)")
+	rootCmd.PersistentFlags().StringVar(&controlSocket, "control-socket", pkg.DefaultControlSocket, "Unix socket the service answers status requests on (disabled when empty)")
+	rootCmd.PersistentFlags().StringVar(&httpListen, "http-listen", "", "Address serving Prometheus metrics on /metrics and health checks on /healthz and /readyz, e.g. 127.0.0.1:9464 (disabled when empty)")
+	rootCmd.PersistentFlags().IntVar(&readyIntervals, "ready-intervals", pkg.DefaultReadyIntervals, "Reconcile intervals after the last successful sync until /readyz fails")
 
 	// Add logging flags
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level (error, warn, info, debug)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", pkg.LogFormatText, "Log file format (text, json)")
 	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Log file path (default: syslog for service, stdout for CLI)")
 	rootCmd.PersistentFlags().IntVar(&maxLogSize, "max-log-size", 100, "Maximum log file size in megabytes before rotation")
 	rootCmd.PersistentFlags().IntVar(&maxBackups, "max-backups", 3, "Maximum number of old log files to retain")
@@ -9,6 +9,7 @@ import (
 	"syscall"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"opnsense-lease-sync/pkg"
 )
 
@@ -21,14 +22,7 @@ and automatically syncs them to AdGuard Home. This is the recommended
 mode for production use.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Create log configuration
-		logConfig := pkg.LogConfig{
-			Level:      pkg.ParseLogLevel(logLevel),
-			FilePath:   logFile,
-			MaxSize:    maxLogSize,
-			MaxBackups: maxBackups,
-			MaxAge:     maxAge,
-			Compress:   !noCompress,
-		}
+		logConfig := newLogConfig()
 
 		// Initialize logger
 		logger, err := pkg.NewLogger(logConfig)
@@ -37,76 +31,172 @@ mode for production use.`,
 		}
 
 		// Create a context that we'll cancel on shutdown
-		_, cancel := context.WithCancel(context.Background())
+		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
 		// Set up signal handling
 		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 		// Create error channel for service errors
 		errChan := make(chan error, 1)
 
-		// Convert string lease format to LeaseFormat type
-		var leaseFormatType pkg.LeaseFormat
-		if leaseFormat == "dnsmasq" {
-			leaseFormatType = pkg.DNSMasqFormat
-		} else {
-			leaseFormatType = pkg.ISCDHCPFormat
-		}
-
-		syncService, err := pkg.NewSyncService(pkg.Config{
-			AdGuardURL:           adguardURL,
-			LeasePath:            leasePath,
-			LeaseFormat:          leaseFormatType,
-			DryRun:               dryRun,
-			Username:             username,
-			Password:             password,
-			Scheme:               scheme,
-			Timeout:              timeout,
-			Logger:               logger,
-			PreserveDeletedHosts: preserveDeletedHosts,
-			Debug:                debug,
-			LogConfig:            logConfig,
-		})
+		syncService, err := pkg.NewSyncService(serveConfig(logger, logConfig))
 		if err != nil {
 			return fmt.Errorf("failed to create service: %w", err)
 		}
 
-		// Start service in a goroutine
+		// Answer status requests; the service runs without the socket if
+		// it can't be created
+		if controlSocket != "" {
+			control := pkg.NewControlServer(controlSocket, syncService, logger)
+			if err := control.Start(); err != nil {
+				logger.Error("Control socket unavailable", "path", controlSocket, "error", err)
+			} else {
+				defer control.Close()
+			}
+		}
+
+		// Serve the metrics and health checks; an address that can't be used
+		// is a setup error
+		if httpListen != "" {
+			monitor := pkg.NewMonitorServer(httpListen, readyIntervals, syncService, logger)
+			if err := monitor.Start(); err != nil {
+				return fmt.Errorf("failed to start HTTP listener: %w", err)
+			}
+			defer monitor.Close()
+		}
+
+		// Start service in a goroutine; Run returns once the service has
+		// shut down
 		go func() {
 			logger.Info("Starting service...")
-			if err := syncService.Run(); err != nil {
-				logger.Error(fmt.Sprintf("Service error: %v", err))
-				errChan <- err
-			}
+			errChan <- syncService.Run(ctx)
 		}()
 
 		// Wait for either:
-		// - A signal (SIGINT/SIGTERM)
-		// - An error from the service
-		select {
-		case sig := <-sigChan:
-			logger.Info(fmt.Sprintf("Received signal: %v", sig))
-			logger.Info("Initiating graceful shutdown...")
-
-			// Cancel context to notify service to stop
-			cancel()
-
-			// Call Stop() to cleanup
-			if err := syncService.Stop(); err != nil {
-				logger.Error(fmt.Sprintf("Error during shutdown: %v", err))
-				return fmt.Errorf("error stopping service: %w", err)
+		// - A signal (SIGHUP reloads, SIGINT/SIGTERM shut down)
+		// - The service exiting on its own, e.g. after a setup error
+		for {
+			select {
+			case sig := <-sigChan:
+				if sig == syscall.SIGHUP {
+					logger.Info("Received SIGHUP, reloading configuration")
+					if err := reloadService(cmd, args, logger, syncService); err != nil {
+						logger.Error("Configuration reload rejected, keeping the running configuration", "error", err)
+					}
+					continue
+				}
+
+				logger.Info("Received signal", "signal", sig.String())
+				logger.Info("Initiating graceful shutdown...")
+
+				// Stop waits for a running sync to finish
+				if err := syncService.Stop(); err != nil {
+					logger.Error("Error during shutdown", "error", err)
+					return fmt.Errorf("error stopping service: %w", err)
+				}
+				<-errChan
+
+				logger.Info("Service stopped successfully")
+				return nil
+
+			case err := <-errChan:
+				if err != nil {
+					logger.Error("Service failed", "error", err)
+					return fmt.Errorf("service failed: %w", err)
+				}
+				return nil
 			}
+		}
+	},
+}
+
+// newLogConfig builds the log configuration from the resolved flags
+func newLogConfig() pkg.LogConfig {
+	return pkg.LogConfig{
+		Level:      pkg.ParseLogLevel(logLevel),
+		Format:     logFormat,
+		FilePath:   logFile,
+		MaxSize:    maxLogSize,
+		MaxBackups: maxBackups,
+		MaxAge:     maxAge,
+		Compress:   !noCompress,
+	}
+}
 
-			logger.Info("Service stopped successfully")
-			return nil
+// serveConfig builds the service configuration from the resolved flags
+func serveConfig(logger pkg.Logger, logConfig pkg.LogConfig) pkg.Config {
+	// Convert string lease format to LeaseFormat type
+	var leaseFormatType pkg.LeaseFormat
+	if leaseFormat == "dnsmasq" {
+		leaseFormatType = pkg.DNSMasqFormat
+	} else {
+		leaseFormatType = pkg.ISCDHCPFormat
+	}
+
+	return pkg.Config{
+		AdGuardURL:           adguardURL,
+		LeasePath:            leasePath,
+		LeaseFormat:          leaseFormatType,
+		LeaseSources:         leaseSources,
+		DryRun:               dryRun,
+		Username:             username,
+		Password:             password,
+		Scheme:               scheme,
+		Timeout:              timeout,
+		Logger:               logger,
+		PreserveDeletedHosts: preserveDeletedHosts,
+		Debug:                debug,
+		LogConfig:            logConfig,
+		Targets:              targets,
+		ClientCacheTTL:       clientCacheTTL,
+		Workers:              workers,
+		ReconcileInterval:    reconcileInterval,
+		RequestsPerSecond:    requestsPerSecond,
+		Profiles:             profiles,
+		ReapplyProfiles:      reapplyProfiles,
+		Tags:                 tagMapper,
+		RewriteDomain:        rewriteDomain,
+		StateFile:            stateFile,
+		DNSOutputs:           dnsOutputs,
+		AdGuardDHCPLeases:    adguardDHCPLeases,
+		LeasePollInterval:    leasePollInterval,
+		SyncStaticLeases:     syncStaticLeases,
+		OPNsenseConfigPath:   opnsenseConfigPath,
+	}
+}
 
-		case err := <-errChan:
-			logger.Error(fmt.Sprintf("Service failed: %v", err))
-			return fmt.Errorf("service failed: %w", err)
+// reloadService re-reads the configuration and environment files, resolves
+// and validates the settings again and hands them to the running service. Flags given on the
+// command line keep their values.
+func reloadService(cmd *cobra.Command, args []string, logger pkg.Logger, syncService *pkg.SyncService) error {
+	if configFilePath == "" && envFile == "" {
+		logger.Warn("No --config or --env-file given, only environment changes made before the start apply")
+	}
+
+	// Return the settings not given on the command line to their defaults,
+	// so values removed from the files no longer apply
+	var resetErr error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed || resetErr != nil {
+			return
 		}
-	},
+		if list, ok := f.Value.(interface{ Replace([]string) error }); ok {
+			resetErr = list.Replace(nil)
+			return
+		}
+		resetErr = f.Value.Set(f.DefValue)
+	})
+	if resetErr != nil {
+		return fmt.Errorf("resetting flags: %w", resetErr)
+	}
+
+	if err := rootCmd.PersistentPreRunE(cmd, args); err != nil {
+		return err
+	}
+
+	return syncService.Reload(serveConfig(logger, newLogConfig()))
 }
 
 func init() {
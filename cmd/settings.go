@@ -0,0 +1,236 @@
+// cmd/settings.go
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"opnsense-lease-sync/pkg"
+)
+
+// Sources a setting's value can come from, in order of precedence
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+// setting ties a flag to its environment variable and configuration file key
+type setting struct {
+	flag string
+	env  string
+	key  string // Dotted path in the configuration file
+
+	// Repeatable flags take their items from the environment variable
+	// separated by ";" and from a list in the configuration file
+	list bool
+
+	// kind and nameKey describe definition lists: entries are parsed into
+	// defs, and nameKey is the entry field holding the definition name
+	kind    string
+	nameKey string
+	defs    *[]pkg.Definition
+}
+
+// Definitions of the list settings, resolved from flags, environment or file
+var (
+	targetDefs    []pkg.Definition
+	profileDefs   []pkg.Definition
+	tagRuleDefs   []pkg.Definition
+	dnsOutputDefs []pkg.Definition
+)
+
+// settings lists every configurable flag in the order the configuration file
+// is written
+var settings = []setting{
+	{flag: "adguard-url", env: "ADGUARD_URL", key: "adguard.url"},
+	{flag: "username", env: "ADGUARD_USERNAME", key: "adguard.username"},
+	{flag: "password", env: "ADGUARD_PASSWORD", key: "adguard.password"},
+	{flag: "scheme", env: "ADGUARD_SCHEME", key: "adguard.scheme"},
+	{flag: "timeout", env: "ADGUARD_TIMEOUT", key: "adguard.timeout"},
+	{flag: "client-cache-ttl", env: "CLIENT_CACHE_TTL", key: "adguard.client_cache_ttl"},
+	{flag: "requests-per-second", env: "REQUESTS_PER_SECOND", key: "adguard.requests_per_second"},
+
+	{flag: "target", env: "SYNC_TARGETS", key: "targets", list: true, kind: "target", nameKey: "name", defs: &targetDefs},
+
+	{flag: "lease-path", env: "DHCP_LEASE_PATH", key: "leases.path"},
+	{flag: "lease-format", env: "LEASE_FORMAT", key: "leases.format"},
+	{flag: "lease-source", env: "LEASE_SOURCES", key: "leases.sources", list: true},
+	{flag: "adguard-dhcp-leases", env: "ADGUARD_DHCP_LEASES", key: "leases.adguard_dhcp"},
+	{flag: "lease-poll-interval", env: "LEASE_POLL_INTERVAL", key: "leases.poll_interval"},
+
+	{flag: "sync-static-leases", env: "SYNC_STATIC_LEASES", key: "static_leases.enabled"},
+	{flag: "opnsense-config", env: "OPNSENSE_CONFIG", key: "static_leases.opnsense_config"},
+
+	{flag: "dry-run", env: "DRY_RUN", key: "sync.dry_run"},
+	{flag: "preserve-deleted-hosts", env: "PRESERVE_DELETED_HOSTS", key: "sync.preserve_deleted_hosts"},
+	{flag: "workers", env: "SYNC_WORKERS", key: "sync.workers"},
+	{flag: "reconcile-interval", env: "RECONCILE_INTERVAL", key: "sync.reconcile_interval"},
+	{flag: "state-file", env: "STATE_FILE", key: "sync.state_file"},
+
+	{flag: "profile", env: "CLIENT_PROFILES", key: "profiles.rules", list: true, kind: "profile", nameKey: "name", defs: &profileDefs},
+	{flag: "reapply-profiles", env: "REAPPLY_PROFILES", key: "profiles.reapply"},
+
+	{flag: "tag-rule", env: "TAG_RULES", key: "tags.rules", list: true, kind: "tag rule", nameKey: "tag", defs: &tagRuleDefs},
+	{flag: "oui-file", env: "OUI_FILE", key: "tags.oui_file"},
+
+	{flag: "rewrite-domain", env: "REWRITE_DOMAIN", key: "dns.rewrite_domain"},
+	{flag: "dns-output", env: "DNS_OUTPUTS", key: "dns.outputs", list: true, kind: "DNS output", nameKey: "name", defs: &dnsOutputDefs},
+
+	{flag: "control-socket", env: "CONTROL_SOCKET", key: "service.control_socket"},
+	{flag: "http-listen", env: "HTTP_LISTEN", key: "service.http_listen"},
+	{flag: "ready-intervals", env: "READY_INTERVALS", key: "service.ready_intervals"},
+
+	{flag: "log-level", env: "LOG_LEVEL", key: "logging.level"},
+	{flag: "log-format", env: "LOG_FORMAT", key: "logging.format"},
+	{flag: "log-file", env: "LOG_FILE", key: "logging.file"},
+	{flag: "max-log-size", env: "MAX_LOG_SIZE", key: "logging.max_size"},
+	{flag: "max-backups", env: "MAX_BACKUPS", key: "logging.max_backups"},
+	{flag: "max-age", env: "MAX_AGE", key: "logging.max_age"},
+	{flag: "no-compress", env: "NO_COMPRESS", key: "logging.no_compress"},
+	{flag: "debug", env: "DEBUG", key: "logging.debug"},
+}
+
+// settingSources records where the value of every setting came from
+var settingSources = map[string]string{}
+
+// resolveSettings fills every flag not given on the command line from the
+// environment, then from the configuration file, keeping the default
+// otherwise, and parses the definition lists
+func resolveSettings(cmd *cobra.Command, file *configFile) error {
+	sources := make(map[string]string, len(settings))
+	for _, s := range settings {
+		f := cmd.Flags().Lookup(s.flag)
+		if f == nil {
+			continue
+		}
+
+		var fileValue interface{}
+		inFile := false
+		if file != nil {
+			fileValue, inFile = file.lookup(s.key)
+		}
+		envValue := os.Getenv(s.env)
+
+		switch {
+		case f.Changed:
+			sources[s.flag] = sourceFlag
+		case envValue != "":
+			if err := s.setFromEnv(f, envValue); err != nil {
+				return fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+			sources[s.flag] = sourceEnv
+		case inFile:
+			if err := s.setFromFile(f, fileValue); err != nil {
+				return fmt.Errorf("invalid %s in %s: %w", s.key, file.path, err)
+			}
+			sources[s.flag] = sourceFile
+		default:
+			sources[s.flag] = sourceDefault
+		}
+
+		// Definition lists given as specs are parsed here; file entries
+		// were converted by setFromFile
+		if s.defs != nil && sources[s.flag] != sourceFile {
+			specs, _ := cmd.Flags().GetStringArray(s.flag)
+			defs, err := pkg.ParseDefinitions(s.kind, specs)
+			if err != nil {
+				return err
+			}
+			*s.defs = defs
+		}
+	}
+	settingSources = sources
+	return nil
+}
+
+// setFromEnv applies an environment variable to the flag
+func (s setting) setFromEnv(f *pflag.Flag, value string) error {
+	if s.list {
+		return replaceList(f, splitList(s, value))
+	}
+	if f.Value.Type() == "bool" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected a boolean, got %q", value)
+		}
+		value = strconv.FormatBool(enabled)
+	}
+	return f.Value.Set(value)
+}
+
+// splitList splits the items of a list variable at ";". Definition specs
+// may contain a ";" inside a quoted value.
+func splitList(s setting, value string) []string {
+	if s.defs != nil {
+		return pkg.SplitSpecs(value, ';')
+	}
+	return strings.Split(value, ";")
+}
+
+// setFromFile applies a configuration file value to the flag
+func (s setting) setFromFile(f *pflag.Flag, value interface{}) error {
+	if !s.list {
+		scalar, err := scalarString(value)
+		if err != nil {
+			return err
+		}
+		return f.Value.Set(scalar)
+	}
+
+	items, ok := value.([]interface{})
+	if !ok && value != nil {
+		return fmt.Errorf("expected a list")
+	}
+
+	if s.defs != nil {
+		defs, err := definitionsFromFile(s.kind, s.nameKey, items)
+		if err != nil {
+			return err
+		}
+		*s.defs = defs
+
+		// Keep the specs on the flag so it describes the effective value
+		specs := make([]string, 0, len(defs))
+		for _, def := range defs {
+			specs = append(specs, def.String())
+		}
+		return replaceList(f, specs)
+	}
+
+	// Lease sources are format:path strings or maps with both keys
+	var specs []string
+	for _, item := range items {
+		switch entry := item.(type) {
+		case string:
+			specs = append(specs, entry)
+		case map[string]interface{}:
+			format, err := scalarString(entry["format"])
+			if err != nil {
+				return err
+			}
+			path, err := scalarString(entry["path"])
+			if err != nil {
+				return err
+			}
+			specs = append(specs, format+":"+path)
+		default:
+			return fmt.Errorf("expected format:path or a map with format and path")
+		}
+	}
+	return replaceList(f, specs)
+}
+
+// replaceList sets the items of a repeatable flag
+func replaceList(f *pflag.Flag, items []string) error {
+	list, ok := f.Value.(pflag.SliceValue)
+	if !ok {
+		return fmt.Errorf("flag --%s is not a list", f.Name)
+	}
+	return list.Replace(items)
+}
@@ -0,0 +1,160 @@
+// cmd/status.go
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"opnsense-lease-sync/pkg"
+)
+
+var statusJSON bool
+
+// statusCmd reports what the running service is doing
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show what the running service is doing",
+	Long: `Ask the running service for its state over the control socket: uptime,
+the last sync and its outcome per target, pending changes, the last error and
+the health of the lease file, lease source and NDP watchers.
+
+Exits with an error when the service isn't running or doesn't answer.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithTimeout(cmd.Context(), 5*time.Second)
+		defer cancel()
+
+		var status pkg.ServiceStatus
+		err := fmt.Errorf("the control socket is disabled")
+		if controlSocket != "" {
+			status, err = pkg.NewControlClient(controlSocket).Status(ctx)
+		}
+		if err != nil {
+			return statusUnavailable(err)
+		}
+
+		if statusJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(status)
+		}
+		printStatus(status)
+		return nil
+	},
+}
+
+// statusUnavailable reports a service that can't be asked for its status,
+// falling back to the pidfile
+func statusUnavailable(err error) error {
+	running, pid := isServiceRunning()
+	if statusJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(map[string]interface{}{"running": running, "pid": pid, "error": err.Error()})
+	}
+
+	if running {
+		if !statusJSON {
+			fmt.Printf("dhcp-adguard-sync is running as pid %d\n", pid)
+		}
+		return fmt.Errorf("no status from %s: %w", controlSocket, err)
+	}
+	return fmt.Errorf("dhcp-adguard-sync is not running")
+}
+
+// printStatus prints the status for people
+func printStatus(st pkg.ServiceStatus) {
+	fmt.Printf("dhcp-adguard-sync is running as pid %d, up %s\n", st.PID, (time.Duration(st.UptimeSeconds) * time.Second).String())
+	if st.DryRun {
+		fmt.Println("Dry run: changes are logged, not applied")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if st.LastSync != nil {
+		outcome := "succeeded"
+		if st.LastSync.Error != "" {
+			outcome = "failed: " + st.LastSync.Error
+		}
+		kind := "incremental"
+		if st.LastSync.Full {
+			kind = "full"
+		}
+		fmt.Fprintf(w, "Last sync:\t%s, %s sync triggered by %s, took %s, %s\n",
+			ago(st.LastSync.StartedAt), kind, strings.Join(st.LastSync.Reasons, ", "),
+			st.LastSync.Duration.Round(time.Millisecond), outcome)
+	} else {
+		fmt.Fprintf(w, "Last sync:\tnone yet\n")
+	}
+	if st.LastSuccess != nil {
+		fmt.Fprintf(w, "Last success:\t%s\n", ago(*st.LastSuccess))
+	}
+	if st.LastErrorAt != nil {
+		fmt.Fprintf(w, "Last error:\t%s: %s\n", ago(*st.LastErrorAt), st.LastError)
+	}
+	fmt.Fprintf(w, "Syncs:\t%d, %d failed\n", st.Syncs, st.FailedSyncs)
+	if st.Running != nil {
+		fmt.Fprintf(w, "Running:\tsync triggered by %s, started %s\n", strings.Join(st.Running.Reasons, ", "), ago(st.Running.StartedAt))
+	}
+	switch {
+	case len(st.Pending.Reasons) == 0:
+		fmt.Fprintf(w, "Pending:\tnothing\n")
+	case st.Pending.MACs > 0:
+		fmt.Fprintf(w, "Pending:\t%s, %d changed MAC(s)\n", strings.Join(st.Pending.Reasons, ", "), st.Pending.MACs)
+	default:
+		fmt.Fprintf(w, "Pending:\t%s\n", strings.Join(st.Pending.Reasons, ", "))
+	}
+	fmt.Fprintf(w, "Leases:\t%d, %d active\n", st.Leases.Total, st.Leases.Active)
+	w.Flush()
+
+	if len(st.Targets) > 0 {
+		fmt.Println("\nTargets:")
+		for _, target := range st.Targets {
+			if target.Error != "" {
+				fmt.Fprintf(w, "  %s\tfailed %s: %s\n", target.Name, ago(target.SyncedAt), target.Error)
+				continue
+			}
+			fmt.Fprintf(w, "  %s\t%s, synced %s\n", target.Name, targetSummary(target), ago(target.SyncedAt))
+		}
+		w.Flush()
+	}
+
+	fmt.Println("\nWatchers:")
+	for _, watcher := range st.Watchers {
+		state := "stopped"
+		if watcher.Running {
+			state = "running"
+		}
+		details := []string{state, fmt.Sprintf("%d item(s)", watcher.Entries)}
+		if watcher.LastEvent != nil {
+			details = append(details, "last event "+ago(*watcher.LastEvent))
+		}
+		if watcher.LastErrorAt != nil {
+			details = append(details, fmt.Sprintf("last error %s: %s", ago(*watcher.LastErrorAt), watcher.LastError))
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", watcher.Name, strings.Join(details, ", "))
+	}
+	w.Flush()
+}
+
+// targetSummary formats the client count and changes of a target
+func targetSummary(t pkg.TargetSyncStatus) string {
+	return fmt.Sprintf("%d client(s): %d added, %d updated, %d removed, %d failed (%s)",
+		t.Clients, t.Added, t.Updated, t.Removed, t.Failed, t.Duration.Round(time.Millisecond))
+}
+
+// ago formats how long ago t was
+func ago(t time.Time) string {
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+func init() {
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "Print the status as JSON")
+	rootCmd.AddCommand(statusCmd)
+}
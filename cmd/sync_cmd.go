@@ -2,7 +2,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	"opnsense-lease-sync/pkg"
@@ -18,6 +22,7 @@ and then exits. This is useful for testing or manual synchronization.`,
 		// Create log configuration
 		logConfig := pkg.LogConfig{
 			Level:      pkg.ParseLogLevel(logLevel),
+			Format:     logFormat,
 			FilePath:   logFile,
 			MaxSize:    maxLogSize,
 			MaxBackups: maxBackups,
@@ -40,24 +45,42 @@ and then exits. This is useful for testing or manual synchronization.`,
 		}
 
 		syncService, err := pkg.NewSyncService(pkg.Config{
-			AdGuardURL:  adguardURL,
-			LeasePath:   leasePath,
-			LeaseFormat: leaseFormatType,
-			DryRun:      dryRun,
-			Username:    username,
-			Password:    password,
-			Scheme:      scheme,
-			Timeout:     timeout,
-			Logger:      logger,
-			Debug:       debug,
-			LogConfig:   logConfig,
+			AdGuardURL:         adguardURL,
+			LeasePath:          leasePath,
+			LeaseFormat:        leaseFormatType,
+			LeaseSources:       leaseSources,
+			DryRun:             dryRun,
+			Username:           username,
+			Password:           password,
+			Scheme:             scheme,
+			Timeout:            timeout,
+			Logger:             logger,
+			Debug:              debug,
+			LogConfig:          logConfig,
+			Targets:            targets,
+			ClientCacheTTL:     clientCacheTTL,
+			Workers:            workers,
+			RequestsPerSecond:  requestsPerSecond,
+			Profiles:           profiles,
+			ReapplyProfiles:    reapplyProfiles,
+			Tags:               tagMapper,
+			RewriteDomain:      rewriteDomain,
+			StateFile:          stateFile,
+			DNSOutputs:         dnsOutputs,
+			AdGuardDHCPLeases:  adguardDHCPLeases,
+			LeasePollInterval:  leasePollInterval,
+			SyncStaticLeases:   syncStaticLeases,
+			OPNsenseConfigPath: opnsenseConfigPath,
 		})
 		if err != nil {
 			return fmt.Errorf("failed to create service: %w", err)
 		}
 
-		// Run one sync and exit
-		if err := syncService.Sync(); err != nil {
+		// Run one sync and exit; an interrupt aborts the requests in flight
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if err := syncService.Sync(ctx); err != nil {
 			return fmt.Errorf("sync failed: %w", err)
 		}
 
@@ -0,0 +1,113 @@
+// cmd/trigger.go
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"opnsense-lease-sync/pkg"
+)
+
+var (
+	triggerJSON bool
+	triggerWait time.Duration
+)
+
+// triggerCmd asks the running service for a sync
+var triggerCmd = &cobra.Command{
+	Use:   "trigger",
+	Short: "Make the running service sync now",
+	Long: `Ask the running service for an immediate full sync over the control socket,
+e.g. after changing clients in AdGuard Home by hand, and print the outcome.
+
+The sync runs through the service's scheduler, so it starts once a running
+sync has finished and never overlaps one. With --dry-run the service only
+works out the changes a sync would make and prints them.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if controlSocket == "" {
+			return fmt.Errorf("the control socket is disabled")
+		}
+		// Only an explicit --dry-run asks for a plan; the service's own
+		// dry run setting doesn't apply here
+		plan := cmd.Flags().Changed("dry-run") && dryRun
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), triggerWait)
+		defer cancel()
+		report, err := pkg.NewControlClient(controlSocket).TriggerSync(ctx, plan)
+		if err != nil {
+			return fmt.Errorf("requesting a sync from %s: %w", controlSocket, err)
+		}
+
+		if triggerJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				return err
+			}
+		} else {
+			printSyncReport(report)
+		}
+		if report.Run.Error != "" {
+			return fmt.Errorf("sync failed: %s", report.Run.Error)
+		}
+		return nil
+	},
+}
+
+// printSyncReport prints the outcome of a requested sync for people
+func printSyncReport(report pkg.SyncReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if report.DryRun {
+		if len(report.Planned) == 0 {
+			fmt.Println("No changes needed")
+		} else {
+			fmt.Println("Planned changes, nothing was applied:")
+			for _, change := range report.Planned {
+				line := fmt.Sprintf("  %s\t%s\t%s (%s)", change.Target, change.Action, change.Hostname, change.MAC)
+				if change.Reason != "" {
+					line += ": " + change.Reason
+				}
+				fmt.Fprintln(w, line)
+			}
+			w.Flush()
+		}
+		fmt.Println()
+		for _, target := range report.Targets {
+			if target.Error != "" {
+				fmt.Fprintf(w, "%s\tfailed: %s\n", target.Name, target.Error)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%d client(s): %d to add, %d to update, %d to remove\n",
+				target.Name, target.Clients, target.Added, target.Updated, target.Removed)
+		}
+		w.Flush()
+		return
+	}
+
+	if report.Run.Error == "" {
+		fmt.Printf("Sync finished in %s\n", report.Run.Duration.Round(time.Millisecond))
+	} else {
+		fmt.Printf("Sync failed after %s\n", report.Run.Duration.Round(time.Millisecond))
+	}
+	for _, target := range report.Targets {
+		if target.Error != "" {
+			fmt.Fprintf(w, "  %s\tfailed: %s\n", target.Name, target.Error)
+			continue
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", target.Name, targetSummary(target))
+	}
+	w.Flush()
+}
+
+func init() {
+	triggerCmd.Flags().BoolVar(&triggerJSON, "json", false, "Print the outcome as JSON")
+	triggerCmd.Flags().DurationVar(&triggerWait, "wait", 2*time.Minute, "How long to wait for the sync to finish")
+	rootCmd.AddCommand(triggerCmd)
+}
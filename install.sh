@@ -185,8 +185,8 @@ echo ""
 if [ ! -t 0 ]; then
     echo "4. Edit configuration (required for non-interactive install):"
     echo "   vi /usr/local/etc/dhcp-adguard-sync/config.yaml"
-    echo "   - Set ADGUARD_USERNAME and ADGUARD_PASSWORD"
-    echo "   - Set ADGUARD_URL if not using default"
+    echo "   - Set adguard.username and adguard.password"
+    echo "   - Set adguard.url if not using default"
     echo ""
 fi
 echo "================================================"
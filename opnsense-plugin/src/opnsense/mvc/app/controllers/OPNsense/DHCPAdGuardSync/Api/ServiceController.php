@@ -39,6 +39,14 @@ class ServiceController extends ApiMutableServiceControllerBase
     protected static $internalServiceEnabled = 'general.enabled';
     protected static $internalServiceName = 'dhcpadguardsync';
 
+    /**
+     * Apply settings with a reload, the service re-reads its configuration on SIGHUP
+     */
+    protected function reconfigureForceRestart()
+    {
+        return 0;
+    }
+
     /**
      * Additional custom action for testing configuration
      */
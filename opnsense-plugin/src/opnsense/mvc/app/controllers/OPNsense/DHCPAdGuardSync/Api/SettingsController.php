@@ -94,23 +94,31 @@ class SettingsController extends ApiMutableModelControllerBase
             return (string)$value;
         };
 
+        // JSON strings are valid YAML double-quoted scalars
+        $quote = function($value) {
+            return json_encode($value, JSON_UNESCAPED_SLASHES | JSON_UNESCAPED_UNICODE);
+        };
+
         $configContent = "# DHCP AdGuard Sync Configuration\n";
-        $configContent .= "ADGUARD_USERNAME=\"" . $getString($general['adguard_username']) . "\"\n";
-        $configContent .= "ADGUARD_PASSWORD=\"" . $getString($general['adguard_password']) . "\"\n";
-        $configContent .= "ADGUARD_URL=\"" . $getString($general['adguard_url']) . "\"\n";
-        $configContent .= "ADGUARD_SCHEME=\"http\"\n";
+        $configContent .= "adguard:\n";
+        $configContent .= "  url: " . $quote($getString($general['adguard_url'])) . "\n";
+        $configContent .= "  username: " . $quote($getString($general['adguard_username'])) . "\n";
+        $configContent .= "  password: " . $quote($getString($general['adguard_password'])) . "\n";
+        $configContent .= "  scheme: http\n";
 
+        $configContent .= "leases:\n";
         $dhcpServer = $getString($general['dhcp_server']);
         if ($dhcpServer === 'dnsmasq') {
-            $configContent .= "DHCP_LEASE_PATH=\"/var/db/dnsmasq.leases\"\n";
-            $configContent .= "LEASE_FORMAT=\"dnsmasq\"\n";
+            $configContent .= "  path: /var/db/dnsmasq.leases\n";
+            $configContent .= "  format: dnsmasq\n";
         } else {
-            $configContent .= "DHCP_LEASE_PATH=\"/var/dhcpd/var/db/dhcpd.leases\"\n";
-            $configContent .= "LEASE_FORMAT=\"isc\"\n";
+            $configContent .= "  path: /var/dhcpd/var/db/dhcpd.leases\n";
+            $configContent .= "  format: isc\n";
         }
 
-        $configContent .= "LOG_LEVEL=\"info\"\n";
-        $configContent .= "LOG_FILE=\"/var/log/dhcp-adguard-sync.log\"\n";
+        $configContent .= "logging:\n";
+        $configContent .= "  level: info\n";
+        $configContent .= "  file: /var/log/dhcp-adguard-sync.log\n";
 
         // Write config file
         $configDir = '/usr/local/etc/dhcp-adguard-sync';
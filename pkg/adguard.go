@@ -2,16 +2,32 @@
 package pkg
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gmichels/adguard-client-go"
+	"io"
+	"net/http"
 	"strings"
+	"time"
 )
 
+// AdGuard is a ClientStore backed by an AdGuard Home instance
 type AdGuard struct {
-	client *adguard.ADG
+	name    string
+	client  *adguard.ADG
+	cache   *clientCache
+	retry   RetryPolicy
+	breaker *circuitBreaker
+
+	// onRecover is called when the instance answers again after the
+	// circuit breaker had opened
+	onRecover func()
 }
 
-func NewAdGuard(cfg Config) (*AdGuard, error) {
+func NewAdGuard(cfg TargetConfig) (*AdGuard, error) {
 	// Set defaults if not provided
 	scheme := "http" // Since AdGuard is running locally on OPNsense
 	if cfg.Scheme != "" {
@@ -24,7 +40,7 @@ func NewAdGuard(cfg Config) (*AdGuard, error) {
 	}
 
 	// Extract host from URL (remove scheme if present)
-	host := cfg.AdGuardURL
+	host := cfg.URL
 	host = strings.TrimPrefix(host, "http://")
 	host = strings.TrimPrefix(host, "https://")
 
@@ -39,47 +55,196 @@ func NewAdGuard(cfg Config) (*AdGuard, error) {
 	if err != nil {
 		return nil, fmt.Errorf("creating AdGuard client: %w", err)
 	}
+	instrumentClient(client.HTTPClient, cfg.Name)
+	rateLimitClient(client.HTTPClient, cfg.RequestsPerSecond)
 
 	return &AdGuard{
-		client: client,
+		name:    cfg.Name,
+		client:  client,
+		cache:   newClientCache(cfg.CacheTTL),
+		retry:   DefaultRetryPolicy,
+		breaker: newCircuitBreaker(5, 15*time.Second, 5*time.Minute),
 	}, nil
 
 }
 
+// call runs fn through the circuit breaker, retrying transient errors with
+// exponential backoff. fn receives the attempt number, starting at 0.
+// Cancelling ctx stops the retries; a cancelled call doesn't count against
+// the circuit breaker.
+func (a *AdGuard) call(ctx context.Context, fn func(attempt int) error) error {
+	var err error
+	for attempt := 0; attempt < a.retry.Attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(a.retry.delay(attempt)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if !a.breaker.allow() {
+			return &TargetError{Kind: ErrorTransient, Err: ErrCircuitOpen}
+		}
+
+		err = fn(attempt)
+		if ctx.Err() != nil {
+			a.breaker.release()
+			return ctx.Err()
+		}
+		err = classifyError(err)
+		if err != nil {
+			metrics.apiErrors.inc(a.name, ErrorKindOf(err).String())
+		}
+		if a.breaker.record(err) && a.onRecover != nil {
+			a.onRecover()
+		}
+		if ErrorKindOf(err) != ErrorTransient {
+			return err
+		}
+	}
+	return err
+}
+
+// withContext returns a copy of the library client whose requests are
+// bound to ctx, as the library itself doesn't take a context
+func (a *AdGuard) withContext(ctx context.Context) *adguard.ADG {
+	client := *a.client
+	httpClient := *a.client.HTTPClient
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	httpClient.Transport = &contextTransport{base: base, ctx: ctx}
+	client.HTTPClient = &httpClient
+	return &client
+}
+
+// contextTransport attaches a context to every request
+type contextTransport struct {
+	base http.RoundTripper
+	ctx  context.Context
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// Available reports whether calls are currently let through, and if not,
+// how long until the circuit breaker allows the next attempt
+func (a *AdGuard) Available() (bool, time.Duration) {
+	// Once the cooldown has passed the next call is the probe
+	retryAfter := a.breaker.retryAfter()
+	return retryAfter == 0, retryAfter
+}
+
+// Name returns the target name of this AdGuard Home instance
+func (a *AdGuard) Name() string {
+	return a.name
+}
+
 // GetClients retrieves all clients from AdGuard Home
-func (a *AdGuard) GetClients() ([]adguard.Client, error) {
-	allClients, err := a.client.GetAllClients()
+func (a *AdGuard) GetClients(ctx context.Context) ([]adguard.Client, error) {
+	idx, err := a.clients(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("getting clients: %w", err)
+		return nil, err
 	}
-	return allClients.Clients, nil
+	return idx.clients, nil
 }
 
 // GetClientByMAC finds a client by MAC address from the clients list
-func (a *AdGuard) GetClientByMAC(mac string) (*adguard.Client, error) {
-	allClients, err := a.client.GetAllClients()
+func (a *AdGuard) GetClientByMAC(ctx context.Context, mac string) (*adguard.Client, error) {
+	idx, err := a.clients(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return idx.find(idx.byMAC, strings.ToLower(mac)), nil
+}
+
+// GetClientByIP finds a client by one of its IP address IDs
+func (a *AdGuard) GetClientByIP(ctx context.Context, ip string) (*adguard.Client, error) {
+	idx, err := a.clients(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return idx.find(idx.byIP, ip), nil
+}
+
+// GetClientByName finds a client by name, ignoring case
+func (a *AdGuard) GetClientByName(ctx context.Context, name string) (*adguard.Client, error) {
+	idx, err := a.clients(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return idx.find(idx.byName, strings.ToLower(name)), nil
+}
 
-	for _, client := range allClients.Clients {
+// CacheStats returns the client cache counters
+func (a *AdGuard) CacheStats() CacheStats {
+	return a.cache.stats()
+}
+
+// clients returns the client list from the cache, fetching it from
+// AdGuard Home when the cached copy has expired
+func (a *AdGuard) clients(ctx context.Context) (*clientIndex, error) {
+	if idx, ok := a.cache.get(); ok {
+		return idx, nil
+	}
+	return a.fetchClients(ctx)
+}
+
+// fetchClients fetches the client list from AdGuard Home, bypassing and
+// refreshing the cache
+func (a *AdGuard) fetchClients(ctx context.Context) (*clientIndex, error) {
+	var allClients *adguard.AllClients
+	err := a.call(ctx, func(int) (err error) {
+		allClients, err = a.withContext(ctx).GetAllClients()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting clients: %w", err)
+	}
+	return a.cache.set(allClients.Clients), nil
+}
+
+// ListClients returns the AdGuard Home clients that have a MAC address ID
+func (a *AdGuard) ListClients(ctx context.Context) ([]StoreClient, error) {
+	clients, err := a.GetClients(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	storeClients := make([]StoreClient, 0, len(clients))
+	for _, client := range clients {
+		storeClient := StoreClient{
+			Name:   client.Name,
+			Tags:   client.Tags,
+			native: client,
+		}
 		for _, id := range client.Ids {
-			if id == mac {
-				return &client, nil
+			if storeClient.MAC == "" && IsValidMAC(id) {
+				storeClient.MAC = id
+				continue
 			}
+			storeClient.IDs = append(storeClient.IDs, id)
+		}
+		if storeClient.MAC != "" {
+			storeClients = append(storeClients, storeClient)
 		}
 	}
-	return nil, nil
+	return storeClients, nil
 }
 
-// AddClient creates a new client in AdGuard Home
-func (a *AdGuard) AddClient(name string, mac string, ips []string) error {
+// AddClient creates a new client in AdGuard Home. When the client has a
+// profile its settings and tag are applied on top of the defaults.
+func (a *AdGuard) AddClient(ctx context.Context, c StoreClient) error {
 	// Initialize availableIds with MAC address and all provided IPs
-	availableIds := append([]string{mac}, ips...)
+	availableIds := append([]string{c.MAC}, c.IDs...)
 
 	client := adguard.Client{
-		Name: name,
+		Name: c.Name,
 		Ids:  availableIds,
+		Tags: append([]string(nil), c.Tags...),
 		// Set sensible defaults for AdGuard Home client
 		UseGlobalSettings:        true,
 		UseGlobalBlockedServices: true,
@@ -92,51 +257,268 @@ func (a *AdGuard) AddClient(name string, mac string, ips []string) error {
 		},
 	}
 
-	_, err := a.client.CreateClient(client)
+	if c.Profile != nil {
+		c.Profile.Apply(&client)
+	}
+
+	err := a.call(ctx, func(attempt int) error {
+		// A timed out request may still have been applied. The lookup is
+		// part of this attempt, as a nested call would be turned away by
+		// the circuit breaker while this one is its probe.
+		if attempt > 0 {
+			if allClients, err := a.withContext(ctx).GetAllClients(); err == nil {
+				idx := a.cache.set(allClients.Clients)
+				if idx.find(idx.byMAC, strings.ToLower(c.MAC)) != nil {
+					return nil
+				}
+			}
+		}
+		_, err := a.withContext(ctx).CreateClient(client)
+		return err
+	})
 	if err != nil {
+		a.cache.invalidate()
+		if ErrorKindOf(err) == ErrorConflict {
+			return fmt.Errorf("creating client: %w: %w", ErrNameConflict, err)
+		}
 		return fmt.Errorf("creating client: %w", err)
 	}
+	a.cache.put(client.Name, client)
 	return nil
 }
 
-// UpdateClient updates an existing client in AdGuard Home
-// func (a *AdGuard) UpdateClient(name string, mac string, ips []string) error {
-//
-//		availableIds := append([]string{mac}, ips...)
-//
-//		clientUpdate := adguard.ClientUpdate{
-//			Name: mac, // Use MAC as identifier
-//			Data: adguard.Client{
-//				Name:                     name,
-//				Ids:                      availableIds,
-//				UseGlobalSettings:        true,
-//				UseGlobalBlockedServices: true,
-//				FilteringEnabled:         true,
-//				ParentalEnabled:          false,
-//				SafebrowsingEnabled:      false,
-//				SafeSearch: adguard.SafeSearchConfig{
-//					Enabled: false,
-//				},
-//			},
-//		}
-//
-//		_, err := a.client.UpdateClient(clientUpdate)
-//		if err != nil {
-//			return fmt.Errorf("updating client: %w", err)
-//		}
-//		return nil
-//	}
-//
+// UpdateClient replaces the IDs and tags of an existing AdGuard Home client,
+// preserving its name and all of its other settings. AdGuard updates
+// clients by name, so the current name is resolved by MAC from the cached
+// client list; if the client was renamed or removed since, the update is
+// retried once with a freshly fetched list.
+func (a *AdGuard) UpdateClient(ctx context.Context, current, desired StoreClient) error {
+	err := a.updateClientByMAC(ctx, current.MAC, desired, false)
+	if errors.Is(err, ErrClientNotFound) {
+		err = a.updateClientByMAC(ctx, current.MAC, desired, true)
+	}
+	return err
+}
+
+// updateClientByMAC looks up the client with the MAC, in a fresh client
+// list if set, and updates it
+func (a *AdGuard) updateClientByMAC(ctx context.Context, mac string, desired StoreClient, fresh bool) error {
+	lookup := a.clients
+	if fresh {
+		lookup = a.fetchClients
+	}
+	idx, err := lookup(ctx)
+	if err != nil {
+		return fmt.Errorf("looking up client %s: %w", mac, err)
+	}
+	existing := idx.find(idx.byMAC, strings.ToLower(mac))
+	if existing == nil {
+		return fmt.Errorf("updating client %s: %w", mac, ErrClientNotFound)
+	}
+
+	// The name is only chosen when the client is created, so a client
+	// renamed in AdGuard Home keeps its name
+	updatedClient := *existing
+	// Update actions require the MAC address to be part of the IDs
+	updatedClient.Ids = append(append([]string(nil), desired.IDs...), desired.MAC)
+	updatedClient.Tags = append([]string(nil), desired.Tags...)
+
+	// Re-apply the matching profile's settings if requested
+	if desired.ApplyProfile && desired.Profile != nil {
+		desired.Profile.Apply(&updatedClient)
+	}
+
+	clientUpdate := adguard.ClientUpdate{
+		Name: existing.Name,
+		Data: updatedClient,
+	}
+
+	err = a.call(ctx, func(int) error {
+		_, err := a.withContext(ctx).UpdateClient(clientUpdate)
+		return err
+	})
+	if err != nil {
+		a.cache.invalidate()
+		if isClientNotFound(err) {
+			return fmt.Errorf("updating client %s: %w: %v", existing.Name, ErrClientNotFound, err)
+		}
+		rb, _ := json.Marshal(clientUpdate)
+		return fmt.Errorf("updating client: %w (request body: %s)", err, rb)
+	}
+	a.cache.put(existing.Name, updatedClient)
+	return nil
+}
+
+// ProfileDiffers reports whether applying the profile would change the client
+func (a *AdGuard) ProfileDiffers(client StoreClient, profile *ClientProfile) bool {
+	existing, ok := client.native.(adguard.Client)
+	return ok && profile.Differs(existing)
+}
 
 // RemoveClient removes a client from AdGuard Home
-func (a *AdGuard) RemoveClient(name string) error {
+func (a *AdGuard) RemoveClient(ctx context.Context, c StoreClient) error {
 	clientDelete := adguard.ClientDelete{
-		Name: name,
+		Name: c.Name,
 	}
 
-	err := a.client.DeleteClient(clientDelete)
+	err := a.call(ctx, func(int) error {
+		return a.withContext(ctx).DeleteClient(clientDelete)
+	})
 	if err != nil {
+		a.cache.invalidate()
 		return fmt.Errorf("deleting client: %w", err)
 	}
+	a.cache.delete(c.Name)
+	return nil
+}
+
+// ListRecords retrieves all DNS rewrite entries from AdGuard Home
+func (a *AdGuard) ListRecords(ctx context.Context) ([]DNSRecord, error) {
+	var rewrites *[]adguard.RewriteEntry
+	err := a.call(ctx, func(int) (err error) {
+		rewrites, err = a.withContext(ctx).GetAllRewrites()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting rewrites: %w", err)
+	}
+
+	records := make([]DNSRecord, 0, len(*rewrites))
+	for _, rewrite := range *rewrites {
+		records = append(records, DNSRecord{Domain: rewrite.Domain, Answer: rewrite.Answer})
+	}
+	return records, nil
+}
+
+// AddRecord creates a DNS rewrite entry resolving domain to answer
+func (a *AdGuard) AddRecord(ctx context.Context, domain, answer string) error {
+	entry := adguard.RewriteEntry{Domain: domain, Answer: answer}
+	err := a.call(ctx, func(attempt int) error {
+		// A timed out request may still have been applied
+		if attempt > 0 {
+			if rewrites, err := a.withContext(ctx).GetAllRewrites(); err == nil {
+				for _, rewrite := range *rewrites {
+					if rewrite == entry {
+						return nil
+					}
+				}
+			}
+		}
+		_, err := a.withContext(ctx).CreateRewrite(entry)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("creating rewrite: %w", err)
+	}
+	return nil
+}
+
+// RemoveRecord deletes the DNS rewrite entry matching both domain and
+// answer. The client library's DeleteRewrite only matches on the domain,
+// which could remove a different entry for the same name.
+func (a *AdGuard) RemoveRecord(ctx context.Context, domain, answer string) error {
+	if err := a.post(ctx, "/rewrite/delete", adguard.RewriteEntry{Domain: domain, Answer: answer}); err != nil {
+		return fmt.Errorf("deleting rewrite: %w", err)
+	}
 	return nil
 }
+
+// GetStatus retrieves the version and state of AdGuard Home
+func (a *AdGuard) GetStatus(ctx context.Context) (*AdGuardStatus, error) {
+	var status AdGuardStatus
+	if err := a.get(ctx, "/status", &status); err != nil {
+		return nil, fmt.Errorf("getting status: %w", err)
+	}
+	return &status, nil
+}
+
+// GetDHCPStatus retrieves the DHCP server state, including dynamic and
+// static leases, from AdGuard Home
+func (a *AdGuard) GetDHCPStatus(ctx context.Context) (*AdGuardDHCPStatus, error) {
+	var status AdGuardDHCPStatus
+	if err := a.get(ctx, "/dhcp/status", &status); err != nil {
+		return nil, fmt.Errorf("getting DHCP status: %w", err)
+	}
+	return &status, nil
+}
+
+// AddStaticLease creates a static DHCP lease in AdGuard Home
+func (a *AdGuard) AddStaticLease(ctx context.Context, lease StaticDHCPLease) error {
+	if err := a.post(ctx, "/dhcp/add_static_lease", lease); err != nil {
+		return fmt.Errorf("adding static lease: %w", err)
+	}
+	return nil
+}
+
+// RemoveStaticLease deletes a static DHCP lease from AdGuard Home
+func (a *AdGuard) RemoveStaticLease(ctx context.Context, lease StaticDHCPLease) error {
+	if err := a.post(ctx, "/dhcp/remove_static_lease", lease); err != nil {
+		return fmt.Errorf("removing static lease: %w", err)
+	}
+	return nil
+}
+
+// Host returns the AdGuard Home control URL this client talks to
+func (a *AdGuard) Host() string {
+	return a.client.HostURL
+}
+
+// get retrieves a JSON document from an AdGuard Home control endpoint
+func (a *AdGuard) get(ctx context.Context, path string, out any) error {
+	body, err := a.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, out)
+}
+
+// post sends a JSON payload to an AdGuard Home control endpoint using the
+// client library's connection settings
+func (a *AdGuard) post(ctx context.Context, path string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = a.do(ctx, http.MethodPost, path, body)
+	return err
+}
+
+// do performs an authenticated request and returns the response body,
+// retrying transient errors
+func (a *AdGuard) do(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	var respBody []byte
+	err := a.call(ctx, func(int) (err error) {
+		respBody, err = a.doOnce(ctx, method, path, body)
+		return err
+	})
+	return respBody, err
+}
+
+// doOnce performs a single authenticated request
+func (a *AdGuard) doOnce(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.client.HostURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(a.client.Auth.Username, a.client.Auth.Password)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := a.client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	respBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status: %d, body: %s", res.StatusCode, respBody)
+	}
+	return respBody, nil
+}
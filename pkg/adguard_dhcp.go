@@ -0,0 +1,222 @@
+// pkg/adguard_dhcp.go
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// staticLeaseSection is the ownership store section for AdGuard static leases
+const staticLeaseSection = "static_leases"
+
+// AdGuardDHCPReader reads the leases handed out by AdGuard Home's own DHCP
+// server, for setups where AdGuard serves DHCP on some segments
+type AdGuardDHCPReader struct {
+	adguard  *AdGuard
+	interval time.Duration
+}
+
+// NewAdGuardDHCPReader creates a lease reader polling AdGuard Home's DHCP
+// status at the given interval
+func NewAdGuardDHCPReader(adguard *AdGuard, interval time.Duration) *AdGuardDHCPReader {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &AdGuardDHCPReader{adguard: adguard, interval: interval}
+}
+
+// Path returns the DHCP status endpoint the leases are read from
+func (r *AdGuardDHCPReader) Path() string {
+	return r.adguard.Host() + "/dhcp/status"
+}
+
+// PollInterval returns how often the lease source should be polled
+func (r *AdGuardDHCPReader) PollInterval() time.Duration {
+	return r.interval
+}
+
+// GetLeases returns AdGuard's dynamic and static DHCP leases keyed by MAC
+func (r *AdGuardDHCPReader) GetLeases(ctx context.Context) (map[string]ISCDHCPLease, error) {
+	status, err := r.adguard.GetDHCPStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	leases := make(map[string]ISCDHCPLease)
+	if !status.Enabled {
+		return leases, nil
+	}
+
+	for _, lease := range status.AdGuardDHCPResponse.Leases {
+		leases[lease.MAC] = ISCDHCPLease{
+			IP:       lease.IP,
+			Hostname: lease.Hostname,
+			MAC:      lease.MAC,
+			IsActive: true,
+		}
+	}
+
+	for _, lease := range status.StaticDHCPResponse.Leases {
+		leases[lease.MAC] = ISCDHCPLease{
+			IP:       lease.IP,
+			Hostname: lease.Hostname,
+			MAC:      lease.MAC,
+			IsActive: true,
+		}
+	}
+
+	return leases, nil
+}
+
+// StaticLeaseWriter pushes OPNsense static mappings into AdGuard Home's
+// static DHCP leases. Only leases recorded in the ownership store are ever
+// replaced or removed.
+type StaticLeaseWriter struct {
+	adguard    *AdGuard
+	configPath string
+	owned      *OwnershipStore
+	logger     Logger
+	dryRun     bool
+	debug      bool
+}
+
+// Path returns the OPNsense configuration file the mappings are read from
+func (w *StaticLeaseWriter) Path() string {
+	return w.configPath
+}
+
+// Sync reconciles AdGuard's static leases with the OPNsense static mappings
+func (w *StaticLeaseWriter) Sync(ctx context.Context) error {
+	mappings, err := ReadStaticMappings(w.configPath)
+	if err != nil {
+		return err
+	}
+
+	status, err := w.adguard.GetDHCPStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("getting AdGuard static leases: %w", err)
+	}
+	if !status.Enabled {
+		if w.debug {
+			w.logger.Info("Skipping static leases: AdGuard's DHCP server is disabled")
+		}
+		return nil
+	}
+
+	existing := make(map[string]StaticDHCPLease)
+	for _, lease := range status.StaticDHCPResponse.Leases {
+		existing[strings.ToLower(lease.MAC)] = lease
+	}
+
+	for mac, mapping := range mappings {
+		mapping.Hostname = dnsLabel(mapping.Hostname)
+		current, found := existing[mac]
+
+		if found && current.IP == mapping.IP && current.Hostname == mapping.Hostname {
+			continue
+		}
+		if found && !w.owned.Owns(staticLeaseSection, mac) {
+			if w.debug {
+				w.logger.Info("Skipping static lease: manually created in AdGuard", "mac", mac)
+			}
+			continue
+		}
+		if !status.V4.Contains(mapping.IP) {
+			if w.debug {
+				w.logger.Info("Skipping static lease: outside AdGuard's DHCP range", "mac", mac, "ip", mapping.IP)
+			}
+			continue
+		}
+
+		message := "Adding AdGuard static lease"
+		logger := w.logger.With("mac", mac, "ip", mapping.IP, "hostname", mapping.Hostname, "action", "add")
+		if found {
+			message = "Updating AdGuard static lease"
+			logger = w.logger.With("mac", mac, "ip", mapping.IP, "hostname", mapping.Hostname, "action", "update")
+		}
+		if w.dryRun {
+			logger.Info("DRY-RUN: " + message)
+			continue
+		}
+
+		logger.Info(message)
+		// AdGuard rejects a second lease for the MAC, so an update removes the
+		// current lease first and puts it back if the new one is rejected
+		if found {
+			if err := w.adguard.RemoveStaticLease(ctx, current); err != nil {
+				logger.Error("Error replacing static lease", "error", err)
+				continue
+			}
+		}
+		if err := w.adguard.AddStaticLease(ctx, mapping); err != nil {
+			logger.Error("Error adding static lease", "error", err)
+			if found {
+				if err := w.adguard.AddStaticLease(ctx, current); err != nil {
+					logger.Error("Error restoring static lease", "error", err)
+					w.owned.Remove(staticLeaseSection, mac)
+				}
+			}
+			continue
+		}
+		w.owned.Add(staticLeaseSection, mac)
+	}
+
+	for _, mac := range w.owned.Keys(staticLeaseSection) {
+		if _, wanted := mappings[mac]; wanted {
+			continue
+		}
+
+		current, found := existing[mac]
+		if !found {
+			// Removed by hand; stop tracking it
+			w.owned.Remove(staticLeaseSection, mac)
+			continue
+		}
+
+		logger := w.logger.With("mac", mac, "ip", current.IP, "hostname", current.Hostname, "action", "remove")
+		if w.dryRun {
+			logger.Info("DRY-RUN: Removing AdGuard static lease")
+			continue
+		}
+
+		logger.Info("Removing AdGuard static lease")
+		if err := w.adguard.RemoveStaticLease(ctx, current); err != nil {
+			logger.Error("Error removing static lease", "error", err)
+			continue
+		}
+		w.owned.Remove(staticLeaseSection, mac)
+	}
+
+	if w.dryRun {
+		return nil
+	}
+	return w.owned.Save()
+}
+
+// Contains reports whether ip is an address the DHCP server could hand out:
+// inside its subnet, or its range when no subnet is reported, and not the
+// gateway
+func (r AdGuardDHCPRange) Contains(ip string) bool {
+	addr := net.ParseIP(ip).To4()
+	if addr == nil || ip == r.GatewayIP {
+		return false
+	}
+
+	gateway := net.ParseIP(r.GatewayIP).To4()
+	mask := net.ParseIP(r.SubnetMask).To4()
+	if gateway != nil && mask != nil {
+		subnet := net.IPNet{IP: gateway.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}
+		return subnet.Contains(addr)
+	}
+
+	start := net.ParseIP(r.RangeStart).To4()
+	end := net.ParseIP(r.RangeEnd).To4()
+	if start == nil || end == nil {
+		return false
+	}
+	return bytes.Compare(addr, start) >= 0 && bytes.Compare(addr, end) <= 0
+}
@@ -0,0 +1,243 @@
+// pkg/adguard_errors.go
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrorKind classifies a failed AdGuard Home or Pi-hole call
+type ErrorKind int
+
+const (
+	ErrorUnknown    ErrorKind = iota
+	ErrorTransient            // Network failure or server error; worth retrying
+	ErrorAuth                 // Wrong credentials or missing permissions
+	ErrorConflict             // The change collides with an existing entry
+	ErrorValidation           // The target rejected the request as invalid
+)
+
+// String returns the name of the error kind
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorTransient:
+		return "transient"
+	case ErrorAuth:
+		return "auth"
+	case ErrorConflict:
+		return "conflict"
+	case ErrorValidation:
+		return "validation"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrCircuitOpen is returned without contacting AdGuard Home while the
+// circuit breaker considers the instance down
+var ErrCircuitOpen = errors.New("AdGuard Home unavailable, circuit breaker open")
+
+// TargetError is a classified error from an AdGuard Home or Pi-hole call
+type TargetError struct {
+	Kind   ErrorKind
+	Status int // HTTP status code, zero for network errors
+	Err    error
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("%s error: %v", e.Kind, e.Err)
+}
+
+func (e *TargetError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKindOf returns the kind of a classified error, or ErrorUnknown
+func ErrorKindOf(err error) ErrorKind {
+	var targetErr *TargetError
+	if errors.As(err, &targetErr) {
+		return targetErr.Kind
+	}
+	return ErrorUnknown
+}
+
+// ErrorStatusOf returns the HTTP status code of a classified error, or zero
+func ErrorStatusOf(err error) int {
+	var targetErr *TargetError
+	if errors.As(err, &targetErr) {
+		return targetErr.Status
+	}
+	return 0
+}
+
+// statusPattern extracts the status code from "status: %d, body: %s" errors
+// returned by the client library and the raw helpers
+var statusPattern = regexp.MustCompile(`status: (\d{3})`)
+
+// classifyError wraps err in a TargetError with its kind
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var targetErr *TargetError
+	if errors.As(err, &targetErr) {
+		return err
+	}
+
+	classified := &TargetError{Kind: ErrorUnknown, Err: err}
+
+	if match := statusPattern.FindStringSubmatch(err.Error()); match != nil {
+		classified.Status, _ = strconv.Atoi(match[1])
+	}
+
+	message := strings.ToLower(err.Error())
+	var netErr net.Error
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, ErrNameConflict), strings.Contains(message, "uses the same name"), classified.Status == 409:
+		classified.Kind = ErrorConflict
+	case classified.Status == 401 || classified.Status == 403:
+		classified.Kind = ErrorAuth
+	case classified.Status == 429 || classified.Status >= 500:
+		classified.Kind = ErrorTransient
+	case classified.Status >= 400:
+		classified.Kind = ErrorValidation
+	case errors.As(err, &netErr), errors.As(err, &urlErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		classified.Kind = ErrorTransient
+	}
+	return classified
+}
+
+// isClientNotFound reports whether AdGuard Home rejected a client update
+// because no client has the name. AdGuard answers that with 400 and a
+// "not found" message rather than 404, so the body is checked for it.
+func isClientNotFound(err error) bool {
+	switch ErrorStatusOf(err) {
+	case http.StatusNotFound:
+		return true
+	case http.StatusBadRequest:
+		return strings.Contains(strings.ToLower(err.Error()), "not found")
+	}
+	return false
+}
+
+// RetryPolicy controls the backoff retries of transient errors
+type RetryPolicy struct {
+	Attempts  int           // Total attempts including the first
+	BaseDelay time.Duration // Delay before the first retry, doubled each time
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy retries transient errors twice, after 500ms and 1s
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+// delay returns the wait before the given retry, starting at 1
+func (p RetryPolicy) delay(retry int) time.Duration {
+	delay := p.BaseDelay << (retry - 1)
+	if delay > p.MaxDelay || delay <= 0 {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// circuitBreaker stops calls to an instance after repeated transient
+// failures. Once the cooldown has passed a single probe call is let
+// through; its success closes the breaker again, a failure doubles the
+// cooldown up to maxCooldown.
+type circuitBreaker struct {
+	threshold   int
+	cooldown    time.Duration
+	maxCooldown time.Duration
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	wait     time.Duration
+	probing  bool
+}
+
+// newCircuitBreaker creates a breaker opening after threshold consecutive
+// transient failures
+func newCircuitBreaker(threshold int, cooldown, maxCooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, maxCooldown: maxCooldown}
+}
+
+// allow reports whether a call may be made now
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.wait {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record updates the breaker with the outcome of a call and reports
+// whether it just recovered from the open state
+func (b *circuitBreaker) record(err error) (recovered bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil || ErrorKindOf(err) != ErrorTransient {
+		// Any answer from AdGuard, even an error, means it is reachable
+		recovered = b.open
+		b.failures = 0
+		b.open = false
+		b.probing = false
+		b.wait = 0
+		return recovered
+	}
+
+	b.failures++
+	switch {
+	case b.probing:
+		b.probing = false
+		b.openedAt = time.Now()
+		b.wait *= 2
+		if b.wait > b.maxCooldown {
+			b.wait = b.maxCooldown
+		}
+	case !b.open && b.failures >= b.threshold:
+		b.open = true
+		b.openedAt = time.Now()
+		b.wait = b.cooldown
+	}
+	return false
+}
+
+// release ends a call without an outcome, e.g. because it was cancelled
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// retryAfter returns how long until the breaker lets a probe through
+func (b *circuitBreaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return 0
+	}
+	remaining := b.wait - time.Since(b.openedAt)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
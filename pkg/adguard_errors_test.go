@@ -0,0 +1,120 @@
+// pkg/adguard_errors_test.go
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errTimeout = &TargetError{Kind: ErrorTransient, Err: errors.New("timeout")}
+
+// expire makes the breaker's current cooldown run out
+func expire(b *circuitBreaker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.openedAt = time.Now().Add(-b.wait - time.Second)
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	b := newCircuitBreaker(2, time.Minute, 3*time.Minute)
+
+	// Closed: calls go through until threshold transient failures in a row
+	if !b.allow() {
+		t.Fatal("closed breaker refused a call")
+	}
+	b.record(errTimeout)
+	if !b.allow() {
+		t.Fatal("breaker opened below the threshold")
+	}
+	b.record(errTimeout)
+
+	// Open: calls are refused until the cooldown has passed
+	if b.allow() {
+		t.Fatal("open breaker allowed a call")
+	}
+	if wait := b.retryAfter(); wait <= 0 || wait > time.Minute {
+		t.Errorf("retryAfter = %s, want up to the cooldown", wait)
+	}
+
+	// Half-open: a single probe is let through; its failure doubles the
+	// cooldown
+	expire(b)
+	if !b.allow() {
+		t.Fatal("breaker refused the probe after the cooldown")
+	}
+	if b.allow() {
+		t.Fatal("breaker allowed a second call while probing")
+	}
+	if b.record(errTimeout) {
+		t.Error("failed probe reported a recovery")
+	}
+	if b.allow() {
+		t.Fatal("breaker allowed a call after a failed probe")
+	}
+	if wait := b.retryAfter(); wait <= time.Minute || wait > 2*time.Minute {
+		t.Errorf("retryAfter after a failed probe = %s, want twice the cooldown", wait)
+	}
+
+	// The cooldown doubles up to the maximum
+	expire(b)
+	b.allow()
+	b.record(errTimeout)
+	if wait := b.retryAfter(); wait <= 2*time.Minute || wait > 3*time.Minute {
+		t.Errorf("retryAfter after two failed probes = %s, want the maximum cooldown", wait)
+	}
+
+	// A cancelled probe lets the next call probe again
+	expire(b)
+	if !b.allow() {
+		t.Fatal("breaker refused the probe after the cooldown")
+	}
+	b.release()
+	if !b.allow() {
+		t.Fatal("breaker refused a probe after the last one was released")
+	}
+
+	// Closed: a successful probe resets the breaker
+	if !b.record(nil) {
+		t.Error("successful probe didn't report a recovery")
+	}
+	if !b.allow() || b.retryAfter() != 0 {
+		t.Error("breaker is still open after a successful probe")
+	}
+	if b.record(nil) {
+		t.Error("success of a closed breaker reported a recovery")
+	}
+
+	// The failure count starts over, and so does the cooldown
+	b.record(errTimeout)
+	if !b.allow() {
+		t.Fatal("breaker opened below the threshold after recovering")
+	}
+	b.record(errTimeout)
+	if wait := b.retryAfter(); wait <= 0 || wait > time.Minute {
+		t.Errorf("retryAfter after reopening = %s, want up to the cooldown", wait)
+	}
+}
+
+func TestCircuitBreakerIgnoresNonTransientErrors(t *testing.T) {
+	b := newCircuitBreaker(2, time.Minute, time.Minute)
+	auth := &TargetError{Kind: ErrorAuth, Status: 401, Err: errors.New("unauthorized")}
+
+	// An answer, even an error, shows the target is reachable
+	b.record(errTimeout)
+	b.record(auth)
+	b.record(errTimeout)
+	if !b.allow() {
+		t.Fatal("breaker opened on failures that weren't consecutive")
+	}
+
+	b.record(errTimeout)
+	expire(b)
+	b.allow()
+	if !b.record(auth) {
+		t.Error("probe answered with an error didn't report a recovery")
+	}
+	if !b.allow() {
+		t.Error("breaker is still open after the probe was answered")
+	}
+}
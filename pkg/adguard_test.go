@@ -0,0 +1,317 @@
+// pkg/adguard_test.go
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gmichels/adguard-client-go"
+)
+
+// fakeAdGuard serves the client endpoints of the AdGuard Home API
+type fakeAdGuard struct {
+	mu       sync.Mutex
+	clients  []adguard.Client
+	requests map[string]int // Requests by path
+	total    int
+
+	// failAdds is the number of adds that are applied but answered with a
+	// server error, as if the response was lost
+	failAdds int
+
+	// listGate, when set, holds client list requests until it is closed or
+	// the request is cancelled
+	listGate chan struct{}
+
+	// status, when set, answers every request
+	status int
+}
+
+func newFakeAdGuard(t *testing.T) (*fakeAdGuard, *httptest.Server) {
+	f := &fakeAdGuard{requests: make(map[string]int)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /control/clients", f.handleList)
+	mux.HandleFunc("POST /control/clients/add", f.handleAdd)
+	mux.HandleFunc("POST /control/clients/update", f.handleUpdate)
+	mux.HandleFunc("POST /control/clients/delete", f.handleDelete)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests[r.URL.Path]++
+		f.total++
+		status := f.status
+		f.mu.Unlock()
+		if status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+// newTestAdGuard creates a store talking to the fake server
+func newTestAdGuard(t *testing.T, server *httptest.Server) *AdGuard {
+	t.Helper()
+	a, err := NewAdGuard(TargetConfig{Name: "adguard", URL: server.URL, Username: "admin", Password: "secret", CacheTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("NewAdGuard: %v", err)
+	}
+	return a
+}
+
+// count returns how often the path was requested
+func (f *fakeAdGuard) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[path]
+}
+
+// requestTotal returns the number of requests served
+func (f *fakeAdGuard) requestTotal() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.total
+}
+
+// rename renames a client as a user would in the AdGuard UI
+func (f *fakeAdGuard) rename(from, to string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.clients {
+		if f.clients[i].Name == from {
+			f.clients[i].Name = to
+		}
+	}
+}
+
+// names returns the client names
+func (f *fakeAdGuard) names() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, client := range f.clients {
+		names = append(names, client.Name)
+	}
+	return names
+}
+
+// index returns the position of the client with the name, or -1; the
+// caller holds f.mu
+func (f *fakeAdGuard) index(name string) int {
+	for i, client := range f.clients {
+		if strings.EqualFold(client.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *fakeAdGuard) handleList(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	gate := f.listGate
+	f.mu.Unlock()
+	if gate != nil {
+		select {
+		case <-gate:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	json.NewEncoder(w).Encode(adguard.AllClients{Clients: f.clients})
+}
+
+func (f *fakeAdGuard) handleAdd(w http.ResponseWriter, r *http.Request) {
+	var client adguard.Client
+	if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.index(client.Name) >= 0 {
+		http.Error(w, fmt.Sprintf("another client uses the same name %q", client.Name), http.StatusBadRequest)
+		return
+	}
+	f.clients = append(f.clients, client)
+	if f.failAdds > 0 {
+		f.failAdds--
+		http.Error(w, "timeout", http.StatusGatewayTimeout)
+	}
+}
+
+func (f *fakeAdGuard) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	var update adguard.ClientUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := f.index(update.Name)
+	if i < 0 {
+		// AdGuard Home reports a missing client as a bad request
+		http.Error(w, fmt.Sprintf("client %q is not found", update.Name), http.StatusBadRequest)
+		return
+	}
+	f.clients[i] = update.Data
+}
+
+func (f *fakeAdGuard) handleDelete(w http.ResponseWriter, r *http.Request) {
+	var del adguard.ClientDelete
+	if err := json.NewDecoder(r.Body).Decode(&del); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := f.index(del.Name)
+	if i < 0 {
+		http.Error(w, fmt.Sprintf("client %q is not found", del.Name), http.StatusBadRequest)
+		return
+	}
+	f.clients = append(f.clients[:i], f.clients[i+1:]...)
+}
+
+// checkUpdated checks that the only client has the name and the IDs the
+// update tests set
+func checkUpdated(t *testing.T, f *fakeAdGuard, name string) {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.clients) != 1 || f.clients[0].Name != name || !containsString(f.clients[0].Ids, "192.168.1.20") {
+		t.Errorf("clients = %+v, want %s with 192.168.1.20", f.clients, name)
+	}
+}
+
+// laptop is the client the update tests start out with
+func laptop() adguard.Client {
+	return adguard.Client{Name: "laptop", Ids: []string{"aa:bb:cc:dd:ee:01", "192.168.1.10"}}
+}
+
+func TestAdGuardUpdateUsesCache(t *testing.T) {
+	fake, server := newFakeAdGuard(t)
+	fake.clients = []adguard.Client{laptop()}
+	a := newTestAdGuard(t, server)
+	ctx := context.Background()
+
+	clients, err := a.ListClients(ctx)
+	if err != nil || len(clients) != 1 {
+		t.Fatalf("ListClients = %v, %v", clients, err)
+	}
+
+	desired := StoreClient{Name: "laptop", MAC: "aa:bb:cc:dd:ee:01", IDs: []string{"192.168.1.20"}}
+	if err := a.UpdateClient(ctx, clients[0], desired); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	if got := fake.count("/control/clients/update"); got != 1 {
+		t.Errorf("update requests = %d, want 1", got)
+	}
+	if got := fake.count("/control/clients"); got != 1 {
+		t.Errorf("client list requests = %d, want 1", got)
+	}
+	checkUpdated(t, fake, "laptop")
+}
+
+func TestAdGuardUpdateRetriesRenamedClient(t *testing.T) {
+	fake, server := newFakeAdGuard(t)
+	fake.clients = []adguard.Client{laptop()}
+	a := newTestAdGuard(t, server)
+	ctx := context.Background()
+
+	clients, err := a.ListClients(ctx)
+	if err != nil || len(clients) != 1 {
+		t.Fatalf("ListClients = %v, %v", clients, err)
+	}
+
+	// Renamed by hand after the list was cached, so the update by the
+	// cached name fails and is retried once with the fetched name, which
+	// it keeps
+	fake.rename("laptop", "laptop-renamed")
+	desired := StoreClient{Name: "laptop", MAC: "aa:bb:cc:dd:ee:01", IDs: []string{"192.168.1.20"}}
+	if err := a.UpdateClient(ctx, clients[0], desired); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	if got := fake.count("/control/clients/update"); got != 2 {
+		t.Errorf("update requests = %d, want 2 (one retry)", got)
+	}
+	if got := fake.count("/control/clients"); got != 2 {
+		t.Errorf("client list requests = %d, want 2", got)
+	}
+	checkUpdated(t, fake, "laptop-renamed")
+}
+
+func TestAdGuardUpdateRemovedClient(t *testing.T) {
+	fake, server := newFakeAdGuard(t)
+	fake.clients = []adguard.Client{laptop()}
+	a := newTestAdGuard(t, server)
+	ctx := context.Background()
+
+	clients, err := a.ListClients(ctx)
+	if err != nil || len(clients) != 1 {
+		t.Fatalf("ListClients = %v, %v", clients, err)
+	}
+
+	fake.mu.Lock()
+	fake.clients = nil
+	fake.mu.Unlock()
+
+	err = a.UpdateClient(ctx, clients[0], StoreClient{Name: "laptop", MAC: "aa:bb:cc:dd:ee:01"})
+	if !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("UpdateClient of a removed client = %v, want %v", err, ErrClientNotFound)
+	}
+	// The fresh list shows the client is gone, so it isn't updated again
+	if got := fake.count("/control/clients/update"); got != 1 {
+		t.Errorf("update requests = %d, want 1", got)
+	}
+}
+
+func TestAdGuardAddRetryWhileProbing(t *testing.T) {
+	fake, server := newFakeAdGuard(t)
+	fake.failAdds = 1
+	a := newTestAdGuard(t, server)
+	a.retry = RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	// The lost response opens the breaker, so the retry is its probe
+	a.breaker = newCircuitBreaker(1, 0, 0)
+
+	client := StoreClient{Name: "laptop", MAC: "aa:bb:cc:dd:ee:01", IDs: []string{"192.168.1.10"}}
+	if err := a.AddClient(context.Background(), client); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	if got := fake.count("/control/clients/add"); got != 1 {
+		t.Errorf("add requests = %d, want 1", got)
+	}
+	if got := fake.names(); len(got) != 1 || got[0] != "laptop" {
+		t.Errorf("clients = %v, want [laptop]", got)
+	}
+}
+
+func TestIsClientNotFound(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf(`status: 400, body: client "laptop" is not found`), true},
+		{fmt.Errorf("status: 404, body: "), true},
+		{fmt.Errorf("status: 400, body: invalid ids"), false},
+		{fmt.Errorf("dial tcp: lookup adguard: host not found"), false},
+	}
+	for _, test := range tests {
+		if got := isClientNotFound(classifyError(test.err)); got != test.want {
+			t.Errorf("isClientNotFound(%q) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
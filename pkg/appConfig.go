@@ -25,6 +25,53 @@ type Config struct {
 	Debug                bool
 	NDPUpdateInterval    time.Duration
 
+	// Workers is the number of changes applied to a target concurrently
+	Workers int
+	// RequestsPerSecond limits the API requests per target; zero is unlimited
+	RequestsPerSecond float64
+
+	// ClientCacheTTL is how long a fetched AdGuard client list is reused
+	ClientCacheTTL time.Duration
+
+	// ReconcileInterval is how often a full sync runs without any trigger;
+	// zero disables the periodic sync
+	ReconcileInterval time.Duration
+	// ShutdownTimeout is how long Stop waits for a running sync before
+	// cancelling it; zero uses DefaultShutdownTimeout
+	ShutdownTimeout time.Duration
+
+	// Targets lists the client stores to sync to. When empty, the AdGuard Home
+	// instance described by the top-level connection settings is used.
+	Targets []TargetConfig
+
+	// Profiles select per-subnet AdGuard client settings; the first match wins
+	Profiles ProfileSet
+	// ReapplyProfiles re-applies profile settings to existing clients on update
+	ReapplyProfiles bool
+	// Tags derives AdGuard client tags from lease metadata (optional)
+	Tags *TagMapper
+
+	// RewriteDomain enables AdGuard DNS rewrites as hostname.<domain> when set
+	RewriteDomain string
+	// AdGuardDHCPLeases adds AdGuard's own DHCP leases as a lease source
+	AdGuardDHCPLeases bool
+	// LeasePollInterval is how often remote lease sources are polled
+	LeasePollInterval time.Duration
+	// SyncStaticLeases pushes OPNsense static mappings into AdGuard's DHCP server
+	SyncStaticLeases bool
+	// OPNsenseConfigPath is the config.xml the static mappings are read from
+	OPNsenseConfigPath string
+
+	// LeaseSources lists the lease files to read. When empty, LeasePath in
+	// LeaseFormat is read.
+	LeaseSources []LeaseSource
+
+	// DNSOutputs write lease hostnames to Unbound include or hosts files
+	DNSOutputs []DNSOutputConfig
+
+	// StateFile records which target entries were created by this service
+	StateFile string
+
 	// Logging configuration
 	LogConfig LogConfig
 }
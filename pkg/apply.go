@@ -0,0 +1,259 @@
+// pkg/apply.go
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWorkers is the number of changes applied to a target concurrently
+const DefaultWorkers = 4
+
+// clientChange is a single add, update or removal planned for a target
+type clientChange struct {
+	action   *AdguardUpdateAction
+	existing *StoreClient // nil for adds
+}
+
+// describe says what the change does
+func (c clientChange) describe() string {
+	switch c.action.Type {
+	case Add:
+		return "Adding client"
+	case Update:
+		return "Updating client"
+	case Remove:
+		return "Removing stale client"
+	}
+	return "Leaving client unchanged"
+}
+
+// attrs returns the log attributes of the change
+func (c clientChange) attrs(target string) []any {
+	attrs := []any{"target", target, "action", changeAction(c.action.Type), "mac", c.action.MAC, "hostname", c.action.Hostname}
+	if c.action.Reason != "" {
+		attrs = append(attrs, "reason", c.action.Reason)
+	}
+	return attrs
+}
+
+// names returns the lower-case client names the change touches
+func (c clientChange) names() []string {
+	names := []string{strings.ToLower(c.action.Hostname)}
+	if c.existing != nil && !strings.EqualFold(c.existing.Name, c.action.Hostname) {
+		names = append(names, strings.ToLower(c.existing.Name))
+	}
+	return names
+}
+
+// changeOrder sorts removals before updates before adds, so a name freed
+// by one change is available to the next
+var changeOrder = map[AdguardUpdateType]int{Remove: 0, Update: 1, Add: 2}
+
+// groupChanges splits changes into groups that touch disjoint client names.
+// Groups can be applied concurrently; the changes within a group are
+// ordered and must be applied one after another.
+func groupChanges(changes []clientChange) [][]clientChange {
+	parent := make([]int, len(changes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	// Join changes that share a name
+	owner := make(map[string]int)
+	for i, change := range changes {
+		for _, name := range change.names() {
+			if j, found := owner[name]; found {
+				parent[find(i)] = find(j)
+				continue
+			}
+			owner[name] = i
+		}
+	}
+
+	byRoot := make(map[int][]clientChange)
+	var roots []int
+	for i, change := range changes {
+		root := find(i)
+		if _, found := byRoot[root]; !found {
+			roots = append(roots, root)
+		}
+		byRoot[root] = append(byRoot[root], change)
+	}
+
+	groups := make([][]clientChange, 0, len(roots))
+	for _, root := range roots {
+		group := byRoot[root]
+		sort.SliceStable(group, func(a, b int) bool {
+			return changeOrder[group[a].action.Type] < changeOrder[group[b].action.Type]
+		})
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// applyChanges applies the changes to a target with a bounded number of
+// workers, counting the outcome in result
+func (s *SyncService) applyChanges(ctx context.Context, t *syncTarget, changes []clientChange, result *TargetResult) {
+	groups := groupChanges(changes)
+
+	workers := s.workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if workers > len(groups) {
+		workers = len(groups)
+	}
+
+	queue := make(chan []clientChange)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range queue {
+				for _, change := range group {
+					err := s.applyChange(ctx, t, change)
+					outcome := "success"
+					if err != nil {
+						outcome = "failure"
+					}
+					metrics.clientChanges.inc(t.store.Name(), changeAction(change.action.Type), outcome)
+
+					mu.Lock()
+					switch {
+					case err != nil:
+						s.log(ctx).Error("Error applying change", append(change.attrs(t.store.Name()), "error", err)...)
+						result.Failed++
+						if ErrorKindOf(err) == ErrorTransient {
+							result.Transient++
+						}
+					case change.action.Type == Add:
+						result.Added++
+					case change.action.Type == Update:
+						result.Updated++
+					case change.action.Type == Remove:
+						result.Removed++
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	// Stop handing out changes once the sync is cancelled
+feed:
+	for _, group := range groups {
+		select {
+		case queue <- group:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// applyChange applies a single change to a target
+func (s *SyncService) applyChange(ctx context.Context, t *syncTarget, change clientChange) error {
+	switch change.action.Type {
+	case Add:
+		return s.addClientWithRetry(ctx, t, change.action)
+	case Update:
+		return s.updateClient(ctx, t, change.existing, change.action)
+	case Remove:
+		s.log(ctx).Info(change.describe(), change.attrs(t.store.Name())...)
+		if err := t.store.RemoveClient(ctx, *change.existing); err != nil {
+			return fmt.Errorf("removing stale client %s: %w", change.action.MAC, err)
+		}
+		s.forgetTags(t, change.action.MAC)
+	}
+	return nil
+}
+
+// rateLimiter spaces out events to at most a fixed number per second
+type rateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newRateLimiter creates a limiter for perSecond events per second, or nil
+// if perSecond is not positive
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// Wait blocks until the next event is allowed or ctx is cancelled
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedTransport waits for the limiter before every request
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *rateLimiter
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// rateLimitClient makes the HTTP client send at most perSecond requests per
+// second; a non-positive rate leaves it unlimited
+func rateLimitClient(client *http.Client, perSecond float64) {
+	limiter := newRateLimiter(perSecond)
+	if limiter == nil {
+		return
+	}
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &rateLimitedTransport{base: base, limiter: limiter}
+}
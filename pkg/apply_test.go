@@ -0,0 +1,225 @@
+// pkg/apply_test.go
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// change builds a planned change; existing is the current client name, or
+// empty for an add
+func change(typ AdguardUpdateType, mac, hostname, existing string) clientChange {
+	c := clientChange{action: &AdguardUpdateAction{Type: typ, MAC: mac, Hostname: hostname}}
+	if existing != "" {
+		c.existing = &StoreClient{Name: existing, MAC: mac}
+	}
+	return c
+}
+
+func TestGroupChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []clientChange
+		want    [][]string // MACs per group, in apply order
+	}{
+		{
+			name: "disjoint names",
+			changes: []clientChange{
+				change(Add, "01", "laptop", ""),
+				change(Update, "02", "phone", "phone"),
+				change(Remove, "03", "tv", "tv"),
+			},
+			want: [][]string{{"01"}, {"02"}, {"03"}},
+		},
+		{
+			name: "removal frees a name for an add",
+			changes: []clientChange{
+				change(Add, "01", "laptop", ""),
+				change(Remove, "02", "laptop", "laptop"),
+			},
+			want: [][]string{{"02", "01"}},
+		},
+		{
+			name: "names are compared case-insensitively",
+			changes: []clientChange{
+				change(Add, "01", "Laptop", ""),
+				change(Remove, "02", "laptop", "laptop"),
+			},
+			want: [][]string{{"02", "01"}},
+		},
+		{
+			name: "chained through the current name",
+			changes: []clientChange{
+				change(Add, "01", "tablet", ""),
+				change(Update, "02", "tablet-old", "tablet"),
+				change(Remove, "03", "tablet-old", "tablet-old"),
+				change(Add, "04", "printer", ""),
+			},
+			want: [][]string{{"03", "02", "01"}, {"04"}},
+		},
+		{
+			name: "same type keeps the planned order",
+			changes: []clientChange{
+				change(Add, "01", "laptop", ""),
+				change(Remove, "02", "laptop", "laptop"),
+				change(Add, "03", "laptop", ""),
+				change(Remove, "04", "laptop", "laptop"),
+			},
+			want: [][]string{{"02", "04", "01", "03"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]string
+			for _, group := range groupChanges(tt.changes) {
+				var macs []string
+				for _, c := range group {
+					macs = append(macs, c.action.MAC)
+				}
+				got = append(got, macs)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groups = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// recordingStore is a client store that logs the changes applied to it and
+// tracks how many run at once
+type recordingStore struct {
+	delay time.Duration
+
+	mu      sync.Mutex
+	applied []string
+	running int
+	peak    int
+}
+
+func (r *recordingStore) Name() string { return "recording" }
+
+func (r *recordingStore) ListClients(ctx context.Context) ([]StoreClient, error) { return nil, nil }
+
+func (r *recordingStore) AddClient(ctx context.Context, client StoreClient) error {
+	return r.apply("add " + client.MAC)
+}
+
+func (r *recordingStore) UpdateClient(ctx context.Context, current, desired StoreClient) error {
+	return r.apply("update " + desired.MAC)
+}
+
+func (r *recordingStore) RemoveClient(ctx context.Context, client StoreClient) error {
+	return r.apply("remove " + client.MAC)
+}
+
+func (r *recordingStore) apply(op string) error {
+	r.mu.Lock()
+	r.running++
+	if r.running > r.peak {
+		r.peak = r.running
+	}
+	r.mu.Unlock()
+
+	time.Sleep(r.delay)
+
+	r.mu.Lock()
+	r.running--
+	r.applied = append(r.applied, op)
+	r.mu.Unlock()
+	return nil
+}
+
+// index returns the position of op in the applied log, or -1
+func (r *recordingStore) index(op string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, applied := range r.applied {
+		if applied == op {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestApplyChangesBoundsWorkers(t *testing.T) {
+	store := &recordingStore{delay: 20 * time.Millisecond}
+	target := &syncTarget{store: store}
+	s := &SyncService{logger: newTestLogger(), workers: 2}
+
+	changes := []clientChange{
+		change(Add, "01", "laptop", ""),
+		change(Remove, "02", "laptop", "laptop"),
+	}
+	for i := 3; i <= 8; i++ {
+		changes = append(changes, change(Add, fmt.Sprintf("%02d", i), fmt.Sprintf("host%d", i), ""))
+	}
+
+	var result TargetResult
+	s.applyChanges(context.Background(), target, changes, &result)
+
+	if result.Added != 7 || result.Removed != 1 || result.Failed != 0 {
+		t.Errorf("result = %+v, want 7 added and 1 removed", result)
+	}
+	if store.peak != 2 {
+		t.Errorf("concurrent changes = %d, want 2", store.peak)
+	}
+	if store.index("remove 02") > store.index("add 01") {
+		t.Errorf("applied %v, want the removal of laptop before its add", store.applied)
+	}
+}
+
+func TestRateLimitClient(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	client := &http.Client{}
+	rateLimitClient(client, 50)
+
+	// Requests from several goroutines share the limiter
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(times) != 5 {
+		t.Fatalf("requests = %d, want 5", len(times))
+	}
+	// Five requests at 50 per second take at least four intervals of 20ms;
+	// allow for timer slack
+	if spread := times[4].Sub(times[0]); spread < 70*time.Millisecond {
+		t.Errorf("five requests took %s, want at least 80ms", spread)
+	}
+}
+
+func TestRateLimitClientUnlimited(t *testing.T) {
+	client := &http.Client{}
+	rateLimitClient(client, 0)
+	if client.Transport != nil {
+		t.Errorf("transport = %T, want the default for a zero rate", client.Transport)
+	}
+}
@@ -0,0 +1,87 @@
+// pkg/changeset.go
+package pkg
+
+import (
+	"sort"
+	"strings"
+)
+
+// ChangeSet lists the MAC addresses affected by a lease or NDP table change
+type ChangeSet struct {
+	Added   []string
+	Changed []string
+	Removed []string
+}
+
+// Empty reports whether nothing changed
+func (c ChangeSet) Empty() bool {
+	return len(c.Added) == 0 && len(c.Changed) == 0 && len(c.Removed) == 0
+}
+
+// MACs returns the lower-case set of all affected MAC addresses
+func (c ChangeSet) MACs() map[string]bool {
+	macs := make(map[string]bool, len(c.Added)+len(c.Changed)+len(c.Removed))
+	for _, list := range [][]string{c.Added, c.Changed, c.Removed} {
+		for _, mac := range list {
+			macs[strings.ToLower(mac)] = true
+		}
+	}
+	return macs
+}
+
+// Merge returns the union of both change sets
+func (c ChangeSet) Merge(other ChangeSet) ChangeSet {
+	return ChangeSet{
+		Added:   mergeMACs(c.Added, other.Added),
+		Changed: mergeMACs(c.Changed, other.Changed),
+		Removed: mergeMACs(c.Removed, other.Removed),
+	}
+}
+
+// attrs returns the sizes of the change set as log attributes
+func (c ChangeSet) attrs() []any {
+	return []any{"added", len(c.Added), "changed", len(c.Changed), "removed", len(c.Removed)}
+}
+
+// mergeMACs returns the sorted union of two MAC lists
+func mergeMACs(a, b []string) []string {
+	if len(b) == 0 {
+		return a
+	}
+	seen := make(map[string]bool, len(a)+len(b))
+	var merged []string
+	for _, mac := range append(append([]string{}, a...), b...) {
+		key := strings.ToLower(mac)
+		if !seen[key] {
+			seen[key] = true
+			merged = append(merged, mac)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+// diffLeases returns the MACs whose leases were added, changed or removed
+// between two lease maps
+func diffLeases(previous, current map[string]ISCDHCPLease) ChangeSet {
+	var changes ChangeSet
+	for mac, lease := range current {
+		old, exists := previous[mac]
+		switch {
+		case !exists:
+			changes.Added = append(changes.Added, mac)
+		case old != lease:
+			changes.Changed = append(changes.Changed, mac)
+		}
+	}
+	for mac := range previous {
+		if _, exists := current[mac]; !exists {
+			changes.Removed = append(changes.Removed, mac)
+		}
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Changed)
+	sort.Strings(changes.Removed)
+	return changes
+}
@@ -0,0 +1,36 @@
+// pkg/check.go
+package pkg
+
+import (
+	"context"
+)
+
+// TargetStatus is what CheckTarget found on a sync target
+type TargetStatus struct {
+	Version string // Server version; empty for targets that don't report it
+	Clients []StoreClient
+}
+
+// CheckTarget connects to a sync target and lists its clients once, without
+// retrying, to verify that it is reachable and accepts the credentials
+func CheckTarget(ctx context.Context, t TargetConfig) (TargetStatus, error) {
+	var status TargetStatus
+
+	store, err := newClientStore(t)
+	if err != nil {
+		return status, err
+	}
+	if a, ok := store.(*AdGuard); ok {
+		a.retry = RetryPolicy{Attempts: 1}
+		server, err := a.GetStatus(ctx)
+		if err != nil {
+			return status, err
+		}
+		status.Version = server.Version
+	}
+
+	if status.Clients, err = store.ListClients(ctx); err != nil {
+		return status, err
+	}
+	return status, nil
+}
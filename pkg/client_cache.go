@@ -0,0 +1,174 @@
+// pkg/client_cache.go
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gmichels/adguard-client-go"
+)
+
+// DefaultClientCacheTTL is how long a fetched AdGuard client list is reused
+const DefaultClientCacheTTL = 30 * time.Second
+
+// CacheStats counts client cache lookups
+type CacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Invalidations uint64
+}
+
+// HitRate returns the share of lookups served from the cache, from 0 to 1
+func (c CacheStats) HitRate() float64 {
+	total := c.Hits + c.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(c.Hits) / float64(total)
+}
+
+// String formats the stats for the log
+func (c CacheStats) String() string {
+	return fmt.Sprintf("%d hits, %d misses, %d invalidations (%.0f%% hit rate)",
+		c.Hits, c.Misses, c.Invalidations, c.HitRate()*100)
+}
+
+// clientIndex is a snapshot of the AdGuard client list indexed by MAC, IP
+// and name
+type clientIndex struct {
+	clients []adguard.Client
+	byMAC   map[string]int
+	byIP    map[string]int
+	byName  map[string]int
+}
+
+// newClientIndex indexes a client list
+func newClientIndex(clients []adguard.Client) *clientIndex {
+	idx := &clientIndex{
+		clients: clients,
+		byMAC:   make(map[string]int),
+		byIP:    make(map[string]int),
+		byName:  make(map[string]int),
+	}
+	for i, client := range clients {
+		idx.byName[strings.ToLower(client.Name)] = i
+		for _, id := range client.Ids {
+			switch {
+			case IsValidMAC(id):
+				idx.byMAC[strings.ToLower(id)] = i
+			case net.ParseIP(id) != nil:
+				idx.byIP[id] = i
+			}
+		}
+	}
+	return idx
+}
+
+// find returns a copy of the client stored under key in m, or nil
+func (idx *clientIndex) find(m map[string]int, key string) *adguard.Client {
+	i, found := m[key]
+	if !found {
+		return nil
+	}
+	client := idx.clients[i]
+	return &client
+}
+
+// clientCache holds the last fetched AdGuard client list for a TTL. Our
+// own successful mutations are written through to the snapshot, so a sync
+// doesn't refetch the list after every change; failed mutations, whose
+// effect is unknown, invalidate it.
+type clientCache struct {
+	ttl time.Duration // Zero disables caching
+
+	mu      sync.Mutex
+	index   *clientIndex
+	fetched time.Time
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+// newClientCache creates a client cache with the TTL
+func newClientCache(ttl time.Duration) *clientCache {
+	return &clientCache{ttl: ttl}
+}
+
+// get returns the cached snapshot if it is still fresh
+func (c *clientCache) get() (*clientIndex, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.index == nil || c.ttl <= 0 || time.Since(c.fetched) > c.ttl {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return c.index, true
+}
+
+// set stores a freshly fetched client list
+func (c *clientCache) set(clients []adguard.Client) *clientIndex {
+	idx := newClientIndex(clients)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index = idx
+	c.fetched = time.Now()
+	return idx
+}
+
+// invalidate drops the cached snapshot
+func (c *clientCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index = nil
+	c.invalidations.Add(1)
+}
+
+// put writes an added or updated client through to the snapshot, replacing
+// the client named previous. A new client passes its own name, as a list
+// fetched while retrying the add may already hold it.
+func (c *clientCache) put(previous string, client adguard.Client) {
+	c.replace(previous, &client)
+}
+
+// delete removes a client from the snapshot by name
+func (c *clientCache) delete(name string) {
+	c.replace(name, nil)
+}
+
+// replace drops the client named previous from the snapshot and appends
+// client, if set
+func (c *clientCache) replace(previous string, client *adguard.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.index == nil {
+		return
+	}
+
+	clients := make([]adguard.Client, 0, len(c.index.clients)+1)
+	for _, existing := range c.index.clients {
+		if previous == "" || !strings.EqualFold(existing.Name, previous) {
+			clients = append(clients, existing)
+		}
+	}
+	if client != nil {
+		clients = append(clients, *client)
+	}
+	c.index = newClientIndex(clients)
+}
+
+// stats returns the lookup counters
+func (c *clientCache) stats() CacheStats {
+	return CacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Invalidations: c.invalidations.Load(),
+	}
+}
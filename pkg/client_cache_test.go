@@ -0,0 +1,164 @@
+// pkg/client_cache_test.go
+package pkg
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gmichels/adguard-client-go"
+)
+
+func TestClientCacheTTL(t *testing.T) {
+	c := newClientCache(time.Minute)
+	if _, ok := c.get(); ok {
+		t.Fatal("get before set hit the cache")
+	}
+
+	c.set([]adguard.Client{laptop()})
+	if _, ok := c.get(); !ok {
+		t.Fatal("get after set missed the cache")
+	}
+
+	c.mu.Lock()
+	c.fetched = time.Now().Add(-2 * time.Minute)
+	c.mu.Unlock()
+	if _, ok := c.get(); ok {
+		t.Error("get of an expired snapshot hit the cache")
+	}
+
+	if stats := c.stats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("stats = %+v, want 1 hit and 2 misses", stats)
+	}
+}
+
+func TestClientCacheDisabled(t *testing.T) {
+	c := newClientCache(0)
+	c.set([]adguard.Client{laptop()})
+	if _, ok := c.get(); ok {
+		t.Error("get with a zero TTL hit the cache")
+	}
+}
+
+func TestClientCacheInvalidate(t *testing.T) {
+	c := newClientCache(time.Minute)
+	c.set([]adguard.Client{laptop()})
+	c.invalidate()
+	if _, ok := c.get(); ok {
+		t.Error("get after invalidate hit the cache")
+	}
+
+	// Writes after an invalidation don't bring back a partial snapshot
+	c.put("phone", adguard.Client{Name: "phone", Ids: []string{"aa:bb:cc:dd:ee:02"}})
+	if _, ok := c.get(); ok {
+		t.Error("get after put to an invalidated cache hit the cache")
+	}
+	if got := c.stats().Invalidations; got != 1 {
+		t.Errorf("invalidations = %d, want 1", got)
+	}
+}
+
+func TestClientCachePutReplacesRefetchedClient(t *testing.T) {
+	c := newClientCache(time.Minute)
+	phone := adguard.Client{Name: "phone", Ids: []string{"aa:bb:cc:dd:ee:02"}}
+	// The retry of an add refetched the list, which already holds the client
+	c.set([]adguard.Client{laptop(), phone})
+	c.put(phone.Name, phone)
+
+	idx, _ := c.get()
+	checkIndex(t, idx, []adguard.Client{laptop(), phone})
+}
+
+// checkIndex fails unless every client of the index is found by its name,
+// MAC and IPs, and no other keys are indexed
+func checkIndex(t *testing.T, idx *clientIndex, want []adguard.Client) {
+	t.Helper()
+	var names []string
+	for _, client := range idx.clients {
+		names = append(names, client.Name)
+	}
+	var wantNames []string
+	for _, client := range want {
+		wantNames = append(wantNames, client.Name)
+	}
+	sort.Strings(names)
+	sort.Strings(wantNames)
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Fatalf("clients = %v, want %v", names, wantNames)
+	}
+
+	ids := 0
+	for _, client := range want {
+		found := idx.find(idx.byName, client.Name)
+		if found == nil || !reflect.DeepEqual(found.Ids, client.Ids) {
+			t.Errorf("by name %s = %+v, want %+v", client.Name, found, client)
+		}
+		for _, id := range client.Ids {
+			m := idx.byIP
+			if IsValidMAC(id) {
+				m = idx.byMAC
+			}
+			if found := idx.find(m, id); found == nil || found.Name != client.Name {
+				t.Errorf("by ID %s = %+v, want %s", id, found, client.Name)
+			}
+			ids++
+		}
+	}
+	if len(idx.byName) != len(want) || len(idx.byMAC)+len(idx.byIP) != ids {
+		t.Errorf("index holds %d names and %d IDs, want %d and %d", len(idx.byName), len(idx.byMAC)+len(idx.byIP), len(want), ids)
+	}
+}
+
+func TestClientCacheWriteThrough(t *testing.T) {
+	fake, server := newFakeAdGuard(t)
+	fake.clients = []adguard.Client{
+		laptop(),
+		{Name: "tv", Ids: []string{"aa:bb:cc:dd:ee:03", "192.168.1.30"}},
+	}
+	a := newTestAdGuard(t, server)
+	ctx := context.Background()
+
+	clients, err := a.ListClients(ctx)
+	if err != nil || len(clients) != 2 {
+		t.Fatalf("ListClients = %v, %v", clients, err)
+	}
+
+	phone := StoreClient{Name: "phone", MAC: "aa:bb:cc:dd:ee:02", IDs: []string{"192.168.1.20"}}
+	if err := a.AddClient(ctx, phone); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	var current StoreClient
+	for _, client := range clients {
+		if client.Name == "laptop" {
+			current = client
+		}
+	}
+	desired := StoreClient{Name: "laptop", MAC: "aa:bb:cc:dd:ee:01", IDs: []string{"192.168.1.11"}}
+	if err := a.UpdateClient(ctx, current, desired); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	if err := a.RemoveClient(ctx, StoreClient{Name: "tv", MAC: "aa:bb:cc:dd:ee:03"}); err != nil {
+		t.Fatalf("RemoveClient: %v", err)
+	}
+
+	idx, ok := a.cache.get()
+	if !ok {
+		t.Fatal("changes dropped the cached client list")
+	}
+	checkIndex(t, idx, []adguard.Client{
+		{Name: "laptop", Ids: []string{"192.168.1.11", "aa:bb:cc:dd:ee:01"}},
+		{Name: "phone", Ids: []string{"aa:bb:cc:dd:ee:02", "192.168.1.20"}},
+	})
+	if idx.find(idx.byIP, "192.168.1.10") != nil {
+		t.Error("the replaced IP of laptop is still indexed")
+	}
+
+	if _, err := a.ListClients(ctx); err != nil {
+		t.Fatalf("ListClients: %v", err)
+	}
+	if got := fake.count("/control/clients"); got != 1 {
+		t.Errorf("client list requests = %d, want 1", got)
+	}
+}
@@ -0,0 +1,152 @@
+// pkg/client_store.go
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// ErrNameConflict is returned by a ClientStore when a client name is
+// already used by another client
+var ErrNameConflict = errors.New("client name already in use")
+
+// ErrClientNotFound is returned by a ClientStore when the client to update
+// no longer exists
+var ErrClientNotFound = errors.New("client not found")
+
+// StoreClient is a named client in a sync target, identified by its MAC
+type StoreClient struct {
+	Name string
+	MAC  string
+	IDs  []string // Identifiers other than the MAC, e.g. IPv4/IPv6 addresses
+	Tags []string
+
+	// Profile holds the settings for a new client; when ApplyProfile is set
+	// it is also re-applied on update
+	Profile      *ClientProfile
+	ApplyProfile bool
+
+	// native holds the backend's own representation of the client, so
+	// updates can preserve settings the sync does not manage
+	native any
+}
+
+// ClientStore is a sync target holding named clients, such as AdGuard Home
+type ClientStore interface {
+	// Name identifies the store in logs
+	Name() string
+
+	// ListClients returns all clients that have a MAC address identifier
+	ListClients(ctx context.Context) ([]StoreClient, error)
+
+	// AddClient creates a new client. It returns an error wrapping
+	// ErrNameConflict if the name is already taken.
+	AddClient(ctx context.Context, client StoreClient) error
+
+	// UpdateClient replaces the IDs and tags of an existing client,
+	// identified by its MAC, keeping the name it was given or renamed to.
+	// It returns an error wrapping ErrClientNotFound if the client no
+	// longer exists.
+	UpdateClient(ctx context.Context, current, desired StoreClient) error
+
+	// RemoveClient deletes a client
+	RemoveClient(ctx context.Context, client StoreClient) error
+}
+
+// SessionStore is implemented by client stores that log in to their
+// target. Close ends the session once the store is no longer used.
+type SessionStore interface {
+	Close(ctx context.Context) error
+}
+
+// ProfileStore is implemented by client stores that support client profiles
+type ProfileStore interface {
+	// ProfileDiffers reports whether applying the profile would change the client
+	ProfileDiffers(client StoreClient, profile *ClientProfile) bool
+}
+
+// ClientComparer is implemented by client stores that decide themselves
+// whether a client needs updating, e.g. because they don't keep IP
+// addresses as IDs. It replaces the ID comparison for that store.
+type ClientComparer interface {
+	// Differs reports whether current has to be updated to match desired,
+	// together with a reason for the log
+	Differs(ctx context.Context, current, desired StoreClient) (bool, string)
+}
+
+// TargetFilter limits which leases are synced to a target
+type TargetFilter struct {
+	Include LeaseMatcher // Empty includes every lease
+	Exclude LeaseMatcher
+}
+
+// Allows reports whether the lease should be synced to the target
+func (f TargetFilter) Allows(lease ISCDHCPLease, interfaces Interfaces) bool {
+	if !f.Include.Empty() && !f.Include.Matches(lease, interfaces) {
+		return false
+	}
+	return f.Exclude.Empty() || !f.Exclude.Matches(lease, interfaces)
+}
+
+// NameFormat builds client names for a target from lease data. The format
+// may use the {hostname}, {mac}, {ip} and {profile} placeholders.
+type NameFormat struct {
+	Format    string
+	Lowercase bool
+}
+
+// Name returns the client name for a lease, or an empty string if the
+// lease has no hostname
+func (n NameFormat) Name(lease ISCDHCPLease, profile *ClientProfile) string {
+	if lease.Hostname == "" {
+		return ""
+	}
+
+	format := n.Format
+	if format == "" {
+		format = "{hostname}"
+	}
+
+	profileName := ""
+	if profile != nil {
+		profileName = profile.Name
+	}
+
+	name := strings.NewReplacer(
+		"{hostname}", lease.Hostname,
+		"{mac}", lease.MAC,
+		"{ip}", lease.IP,
+		"{profile}", profileName,
+	).Replace(format)
+
+	if n.Lowercase {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+// syncTarget is a client store together with its per-target settings
+type syncTarget struct {
+	store  ClientStore
+	naming NameFormat
+	filter TargetFilter
+
+	// records publishes lease hostnames as local DNS records; nil if disabled
+	records *DNSRewriter
+
+	// applied indexes the tags applied to the target's clients during a
+	// sync; nil if tags aren't managed
+	applied *appliedTagIndex
+}
+
+// allowedLeases returns the leases the filter lets through
+func (f TargetFilter) allowedLeases(leases map[string]ISCDHCPLease, interfaces Interfaces) map[string]ISCDHCPLease {
+	allowed := make(map[string]ISCDHCPLease, len(leases))
+	for mac, lease := range leases {
+		if f.Allows(lease, interfaces) {
+			allowed[mac] = lease
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,181 @@
+// pkg/control.go
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DefaultControlSocket is where the service listens for local requests
+const DefaultControlSocket = "/var/run/dhcp-adguard-sync.sock"
+
+// ControlServer answers local requests about the running service over an
+// HTTP API on a Unix socket. Only root can connect to the socket.
+type ControlServer struct {
+	path    string
+	service *SyncService
+	logger  Logger
+	server  *http.Server
+}
+
+// NewControlServer creates a control server for service listening on path
+func NewControlServer(path string, service *SyncService, logger Logger) *ControlServer {
+	c := &ControlServer{
+		path:    path,
+		service: service,
+		logger:  logger,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", c.handleStatus)
+	mux.HandleFunc("/sync", c.handleSync)
+	c.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	return c
+}
+
+// Start listens on the socket and serves requests in the background. A
+// socket left behind by an earlier run is replaced.
+func (c *ControlServer) Start() error {
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing old control socket: %w", err)
+	}
+	listener, err := net.Listen("unix", c.path)
+	if err != nil {
+		return fmt.Errorf("listening on control socket: %w", err)
+	}
+	if err := os.Chmod(c.path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("securing control socket: %w", err)
+	}
+
+	go func() {
+		if err := c.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			c.logger.Error("Control socket failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Close stops serving and removes the socket
+func (c *ControlServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := c.server.Shutdown(ctx)
+	if removeErr := os.Remove(c.path); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// handleStatus returns the service status as JSON
+func (c *ControlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, c.service.Status())
+}
+
+// handleSync runs a sync, or with dry_run=true plans one, and returns the
+// outcome as JSON
+func (c *ControlServer) handleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	dryRun, err := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	if err != nil && r.URL.Query().Get("dry_run") != "" {
+		http.Error(w, "invalid dry_run", http.StatusBadRequest)
+		return
+	}
+
+	var report SyncReport
+	if dryRun {
+		c.logger.Info("Sync plan requested over the control socket")
+		report, err = c.service.PlanSync(r.Context())
+	} else {
+		c.logger.Info("Sync requested over the control socket")
+		report, err = c.service.TriggerSync(r.Context())
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, report)
+}
+
+// writeJSON writes value as an indented JSON response
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// ControlClient talks to the control socket of a running service
+type ControlClient struct {
+	client *http.Client
+}
+
+// NewControlClient creates a client for the control socket at path
+func NewControlClient(path string) *ControlClient {
+	return &ControlClient{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}
+}
+
+// Status fetches the status of the running service
+func (c *ControlClient) Status(ctx context.Context) (ServiceStatus, error) {
+	var status ServiceStatus
+	err := c.do(ctx, http.MethodGet, "/status", &status)
+	return status, err
+}
+
+// TriggerSync asks the running service for a full sync, or with dryRun for
+// the changes one would make, and waits for the outcome
+func (c *ControlClient) TriggerSync(ctx context.Context, dryRun bool) (SyncReport, error) {
+	var report SyncReport
+	err := c.do(ctx, http.MethodPost, "/sync?dry_run="+strconv.FormatBool(dryRun), &report)
+	return report, err
+}
+
+// do sends a request to the service and decodes the JSON response into out
+func (c *ControlClient) do(ctx context.Context, method, path string, out interface{}) error {
+	// The host is ignored, every request goes to the socket
+	req, err := http.NewRequestWithContext(ctx, method, "http://localhost"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("service returned %s: %s", resp.Status, body)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("parsing response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// pkg/definition.go
+package pkg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Setting is a single key=value pair of a definition
+type Setting struct {
+	Key   string
+	Value string
+}
+
+// Definition is a named list of settings describing a target, profile, tag
+// rule or DNS output. It is what a name:key=value,... spec or an entry of
+// the configuration file is parsed into. Keys may repeat.
+type Definition struct {
+	Name     string
+	Settings []Setting
+}
+
+// ParseDefinition splits a spec of the form name:key=value,key=value. Kind
+// names the defined item in error messages. A value containing "," or ";"
+// is written in double quotes, with "" for a quote inside them.
+func ParseDefinition(kind, spec string) (Definition, error) {
+	var def Definition
+
+	name, body, found := strings.Cut(strings.TrimSpace(spec), ":")
+	if !found || name == "" {
+		return def, fmt.Errorf("%s %q: expected name:key=value,...", kind, spec)
+	}
+	def.Name = name
+	if strings.Count(body, `"`)%2 != 0 {
+		return def, fmt.Errorf("%s %s: unterminated quote", kind, name)
+	}
+
+	for _, part := range SplitSpecs(body, ',') {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			return def, fmt.Errorf("%s %s: expected key=value, got %q", kind, name, part)
+		}
+		def.Settings = append(def.Settings, Setting{
+			Key:   strings.ToLower(strings.TrimSpace(key)),
+			Value: unquoteValue(strings.TrimSpace(value)),
+		})
+	}
+
+	return def, nil
+}
+
+// ParseDefinitions splits a list of specs, skipping empty ones
+func ParseDefinitions(kind string, specs []string) ([]Definition, error) {
+	var defs []Definition
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		def, err := ParseDefinition(kind, spec)
+		if err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+// String formats the definition as a name:key=value,... spec
+func (d Definition) String() string {
+	parts := make([]string, 0, len(d.Settings))
+	for _, setting := range d.Settings {
+		parts = append(parts, setting.Key+"="+quoteValue(setting.Value))
+	}
+	return d.Name + ":" + strings.Join(parts, ",")
+}
+
+// SplitSpecs splits a list at every sep outside double quotes, leaving the
+// quotes in place for ParseDefinition
+func SplitSpecs(list string, sep rune) []string {
+	var parts []string
+	var part strings.Builder
+	quoted := false
+	for _, r := range list {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == sep && !quoted:
+			parts = append(parts, part.String())
+			part.Reset()
+			continue
+		}
+		part.WriteRune(r)
+	}
+	return append(parts, part.String())
+}
+
+// unquoteValue removes the double quotes around a spec value
+func unquoteValue(value string) string {
+	if len(value) < 2 || !strings.HasPrefix(value, `"`) || !strings.HasSuffix(value, `"`) {
+		return value
+	}
+	return strings.ReplaceAll(value[1:len(value)-1], `""`, `"`)
+}
+
+// quoteValue quotes a value that wouldn't survive ParseDefinition as is
+func quoteValue(value string) string {
+	if !strings.ContainsAny(value, `,;"`) && strings.TrimSpace(value) == value {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+}
@@ -0,0 +1,46 @@
+// pkg/definition_test.go
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDefinitionQuoting(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []Setting
+	}{
+		{"home:url=10.0.0.2,hostname=^tv\\d", []Setting{{"url", "10.0.0.2"}, {"hostname", "^tv\\d"}}},
+		{`home:password="se,cr;et",url=x`, []Setting{{"password", "se,cr;et"}, {"url", "x"}}},
+		{`home:name_format="say ""hi"", {hostname}"`, []Setting{{"name_format", `say "hi", {hostname}`}}},
+	}
+	for _, test := range tests {
+		def, err := ParseDefinition("target", test.spec)
+		if err != nil {
+			t.Errorf("ParseDefinition(%q): %v", test.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(def.Settings, test.want) {
+			t.Errorf("ParseDefinition(%q) = %v, want %v", test.spec, def.Settings, test.want)
+		}
+
+		// String must produce a spec that parses to the same definition
+		again, err := ParseDefinition("target", def.String())
+		if err != nil || !reflect.DeepEqual(again, def) {
+			t.Errorf("ParseDefinition(%q) = %v, %v, want %v", def.String(), again, err, def)
+		}
+	}
+
+	if _, err := ParseDefinition("target", `home:password="secret`); err == nil {
+		t.Error("ParseDefinition accepted an unterminated quote")
+	}
+}
+
+func TestSplitSpecs(t *testing.T) {
+	got := SplitSpecs(`a:x=1;b:y="2;3";c:z=4`, ';')
+	want := []string{"a:x=1", `b:y="2;3"`, "c:z=4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SplitSpecs = %q, want %q", got, want)
+	}
+}
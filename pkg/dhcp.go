@@ -3,6 +3,7 @@ package pkg
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -16,7 +17,7 @@ func (d *DHCP) Path() string {
 	return d.path
 }
 
-func (d *DHCP) GetLeases() (map[string]ISCDHCPLease, error) {
+func (d *DHCP) GetLeases(ctx context.Context) (map[string]ISCDHCPLease, error) {
 	file, err := os.Open(d.path)
 	if err != nil {
 		return nil, fmt.Errorf("opening lease file: %w", err)
@@ -57,6 +58,10 @@ func (d *DHCP) GetLeases() (map[string]ISCDHCPLease, error) {
 				if len(parts) > 1 {
 					currentLease.Hostname = strings.Trim(strings.TrimSuffix(parts[1], ";"), "\"")
 				}
+			} else if strings.HasPrefix(line, "set vendor-class-identifier") {
+				if _, value, found := strings.Cut(line, "="); found {
+					currentLease.VendorClass = strings.Trim(strings.TrimSuffix(strings.TrimSpace(value), ";"), "\"")
+				}
 			}
 		}
 	}
@@ -0,0 +1,221 @@
+// pkg/dns_output.go
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DNSOutputFormat selects the file format written by a DNS output
+type DNSOutputFormat string
+
+const (
+	UnboundOutput DNSOutputFormat = "unbound"
+	HostsOutput   DNSOutputFormat = "hosts"
+)
+
+// DNSOutputConfig describes a local file the lease hostnames are written to
+type DNSOutputConfig struct {
+	Name          string
+	Format        DNSOutputFormat
+	Path          string
+	Domain        string
+	ReloadCommand string // Run through /bin/sh after the file changed
+	Filter        TargetFilter
+}
+
+// ParseDNSOutput parses a DNS output definition of the form
+//
+//	name:format=unbound,path=/usr/local/etc/unbound.opnsense.d/leases.conf,domain=lan,reload=unbound-control reload
+//
+// Keys are format (unbound or hosts), path, domain, reload and the
+// include_/exclude_ filter keys known from sync targets.
+func ParseDNSOutput(spec string) (DNSOutputConfig, error) {
+	def, err := ParseDefinition("DNS output", spec)
+	if err != nil {
+		return DNSOutputConfig{}, err
+	}
+	return DNSOutputFromDefinition(def)
+}
+
+// DNSOutputFromDefinition builds a DNS output from its settings, using the
+// keys described at ParseDNSOutput
+func DNSOutputFromDefinition(def Definition) (DNSOutputConfig, error) {
+	output := DNSOutputConfig{Format: UnboundOutput}
+	name := def.Name
+	output.Name = name
+
+	for _, setting := range def.Settings {
+		key, value := setting.Key, setting.Value
+
+		var err error
+		switch key {
+		case "format":
+			output.Format = DNSOutputFormat(strings.ToLower(value))
+		case "path":
+			output.Path = value
+		case "domain":
+			output.Domain = strings.Trim(strings.ToLower(value), ".")
+		case "reload":
+			output.ReloadCommand = value
+		case "include_cidr", "include_interface", "include_mac":
+			err = output.Filter.Include.add(strings.TrimPrefix(key, "include_"), value)
+		case "exclude_cidr", "exclude_interface", "exclude_mac":
+			err = output.Filter.Exclude.add(strings.TrimPrefix(key, "exclude_"), value)
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return output, fmt.Errorf("DNS output %s: %w", name, err)
+		}
+	}
+
+	if output.Format != UnboundOutput && output.Format != HostsOutput {
+		return output, fmt.Errorf("DNS output %s: format must be either 'unbound' or 'hosts'", name)
+	}
+	if output.Path == "" {
+		return output, fmt.Errorf("DNS output %s: path is required", name)
+	}
+
+	return output, nil
+}
+
+// ParseDNSOutputs parses a list of DNS output definitions, rejecting
+// duplicate names
+func ParseDNSOutputs(specs []string) ([]DNSOutputConfig, error) {
+	defs, err := ParseDefinitions("DNS output", specs)
+	if err != nil {
+		return nil, err
+	}
+	return DNSOutputsFromDefinitions(defs)
+}
+
+// DNSOutputsFromDefinitions builds the DNS outputs, rejecting duplicate names
+func DNSOutputsFromDefinitions(defs []Definition) ([]DNSOutputConfig, error) {
+	var outputs []DNSOutputConfig
+	seen := make(map[string]bool)
+	for _, def := range defs {
+		output, err := DNSOutputFromDefinition(def)
+		if err != nil {
+			return nil, err
+		}
+		if seen[output.Name] {
+			return nil, fmt.Errorf("DNS output %s: defined more than once", output.Name)
+		}
+		seen[output.Name] = true
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// DNSFileWriter writes lease hostnames to an Unbound include file or a
+// hosts file. The file is only replaced, and the reload command only run,
+// when its content changes.
+type DNSFileWriter struct {
+	config DNSOutputConfig
+	logger Logger
+	dryRun bool
+	debug  bool
+}
+
+// Render returns the file content for the records
+func (w *DNSFileWriter) Render(records DNSRecords) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by dhcp-adguard-sync - do not edit\n")
+
+	if w.config.Format == UnboundOutput {
+		buf.WriteString("server:\n")
+	}
+
+	for _, name := range records.Names() {
+		for _, ip := range records[name] {
+			switch w.config.Format {
+			case UnboundOutput:
+				recordType := "A"
+				if net.ParseIP(ip).To4() == nil {
+					recordType = "AAAA"
+				}
+				fmt.Fprintf(&buf, "local-data: \"%s. IN %s %s\"\n", name, recordType, ip)
+				fmt.Fprintf(&buf, "local-data-ptr: \"%s %s\"\n", ip, name)
+			case HostsOutput:
+				fmt.Fprintf(&buf, "%s\t%s\n", ip, name)
+			}
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// Sync writes the records to the output file and runs the reload command
+// if the content changed
+func (w *DNSFileWriter) Sync(ctx context.Context, records DNSRecords) error {
+	content := w.Render(records)
+
+	current, err := os.ReadFile(w.config.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading %s: %w", w.config.Path, err)
+	}
+	if bytes.Equal(current, content) {
+		if w.debug {
+			w.logger.Info("DNS output is up to date", "path", w.config.Path)
+		}
+		return nil
+	}
+
+	logger := w.logger.With("path", w.config.Path, "records", len(records))
+	if w.dryRun {
+		logger.Info("DRY-RUN: Writing DNS records")
+		return nil
+	}
+
+	logger.Info("Writing DNS records")
+	if err := writeFileAtomic(w.config.Path, content); err != nil {
+		return err
+	}
+
+	if w.config.ReloadCommand == "" {
+		return nil
+	}
+
+	if w.debug {
+		w.logger.Info("Running reload command", "command", w.config.ReloadCommand)
+	}
+	output, err := exec.CommandContext(ctx, "/bin/sh", "-c", w.config.ReloadCommand).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running reload command: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with content through a temporary file in
+// the same directory, so readers never see a partial file
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("setting permissions on %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", tmp.Name(), err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing %s: %w", path, err)
+	}
+	return nil
+}
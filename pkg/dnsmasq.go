@@ -3,6 +3,7 @@ package pkg
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -26,7 +27,7 @@ func (d *DNSMasq) Path() string {
 // GetLeases reads the DNSMasq lease file and returns a map of MAC addresses to lease information
 // DNSMasq lease format:
 // <expiry timestamp> <MAC address> <IP address> <hostname> <client identifier>
-func (d *DNSMasq) GetLeases() (map[string]ISCDHCPLease, error) {
+func (d *DNSMasq) GetLeases(ctx context.Context) (map[string]ISCDHCPLease, error) {
 	file, err := os.Open(d.path)
 	if err != nil {
 		return nil, fmt.Errorf("opening DNSMasq lease file: %w", err)
@@ -0,0 +1,65 @@
+// pkg/health.go
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultReadyIntervals is how many reconcile intervals the last successful
+// sync may be old for the service to count as ready
+const DefaultReadyIntervals = 3
+
+// Healthy returns an error naming the watchers that are not running
+func (s *SyncService) Healthy() error {
+	var stopped []string
+	for _, watcher := range s.Status().Watchers {
+		if !watcher.Running {
+			stopped = append(stopped, watcher.Name)
+		}
+	}
+	if len(stopped) > 0 {
+		return fmt.Errorf("watchers not running: %s", strings.Join(stopped, ", "))
+	}
+	return nil
+}
+
+// Ready returns an error unless a sync succeeded within the last intervals
+// reconcile intervals and every target is reachable. Without a periodic
+// sync the default interval is used.
+func (s *SyncService) Ready(intervals int) error {
+	st := s.Status()
+	if st.LastSuccess == nil {
+		return fmt.Errorf("no successful sync yet")
+	}
+
+	s.scheduler.mu.Lock()
+	interval := s.scheduler.interval
+	s.scheduler.mu.Unlock()
+	if interval <= 0 {
+		interval = DefaultReconcileInterval
+	}
+	if intervals <= 0 {
+		intervals = DefaultReadyIntervals
+	}
+	maxAge := time.Duration(intervals) * interval
+	if age := time.Since(*st.LastSuccess); age > maxAge {
+		return fmt.Errorf("last successful sync %s ago, more than %s", age.Round(time.Second), maxAge)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var down []string
+	for _, t := range s.targets {
+		if health, ok := t.store.(interface{ Available() (bool, time.Duration) }); ok {
+			if available, _ := health.Available(); !available {
+				down = append(down, t.store.Name())
+			}
+		}
+	}
+	if len(down) > 0 {
+		return fmt.Errorf("targets unreachable: %s", strings.Join(down, ", "))
+	}
+	return nil
+}
@@ -1,7 +1,15 @@
 // pkg/lease_reader.go
 package pkg
 
-import "strings"
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // LeaseReader defines the interface for reading DHCP lease files
 type LeaseReader interface {
@@ -9,7 +17,97 @@ type LeaseReader interface {
 	Path() string
 
 	// GetLeases reads the lease file and returns a map of MAC addresses to lease information
-	GetLeases() (map[string]ISCDHCPLease, error)
+	GetLeases(ctx context.Context) (map[string]ISCDHCPLease, error)
+}
+
+// RemoteLeaseReader is implemented by lease sources that are not backed by a
+// local file, and therefore have to be polled for changes instead of watched
+type RemoteLeaseReader interface {
+	LeaseReader
+
+	// PollInterval returns how often the source should be checked for changes
+	PollInterval() time.Duration
+}
+
+// LeaseSource is a lease file and its format
+type LeaseSource struct {
+	Format LeaseFormat
+	Path   string
+}
+
+// ParseLeaseSource parses a lease source of the form format:path, e.g.
+//
+//	isc:/var/dhcpd/var/db/dhcpd.leases
+func ParseLeaseSource(spec string) (LeaseSource, error) {
+	format, path, found := strings.Cut(strings.TrimSpace(spec), ":")
+	if !found || path == "" {
+		return LeaseSource{}, fmt.Errorf("lease source %q: expected format:path", spec)
+	}
+	source := LeaseSource{Format: LeaseFormat(strings.ToLower(format)), Path: path}
+	if source.Format != ISCDHCPFormat && source.Format != DNSMasqFormat {
+		return source, fmt.Errorf("lease source %q: format must be either 'isc' or 'dnsmasq'", spec)
+	}
+	return source, nil
+}
+
+// ParseLeaseSources parses a list of lease sources, skipping empty ones
+func ParseLeaseSources(specs []string) ([]LeaseSource, error) {
+	var sources []LeaseSource
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		source, err := ParseLeaseSource(spec)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// DetectLeaseFormat guesses the format of a lease file from its content. The
+// format is empty when the file holds no leases yet.
+func DetectLeaseFormat(path string) (LeaseFormat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// ISC files hold "lease <ip> {" blocks and a few header statements
+		fields := strings.Fields(text)
+		switch fields[0] {
+		case "lease", "server-duid", "authoring-byte-order":
+			return ISCDHCPFormat, nil
+		case "duid":
+			return DNSMasqFormat, nil
+		}
+
+		// dnsmasq lines are "<expiry> <mac> <ip> <hostname> <client-id>"
+		if len(fields) >= 4 && IsValidMAC(fields[1]) {
+			if _, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+				return DNSMasqFormat, nil
+			}
+		}
+		return "", fmt.Errorf("line %d is neither ISC DHCP nor dnsmasq lease data", line)
+	}
+	return "", scanner.Err()
+}
+
+// newLeaseFileReader creates the reader for a lease file of the given format
+func newLeaseFileReader(format LeaseFormat, path string) LeaseReader {
+	if format == DNSMasqFormat {
+		return NewDNSMasq(path)
+	}
+	return NewDHCP(path)
 }
 
 // DetectLeaseFileFormat examines a file path and returns the appropriate lease reader
@@ -1,12 +1,17 @@
 package pkg
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"log/syslog"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 
 	"gopkg.in/natefinch/lumberjack.v2"
 )
@@ -53,16 +58,42 @@ func ParseLogLevel(level string) LogLevel {
 	}
 }
 
-// Logger defines the interface for logging operations
+// Log formats of the log file
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// slogLevel returns the slog level of the log level
+func (l LogLevel) slogLevel() slog.Level {
+	switch l {
+	case LogLevelError:
+		return slog.LevelError
+	case LogLevelWarn:
+		return slog.LevelWarn
+	case LogLevelDebug:
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Logger defines the interface for logging operations. The arguments after
+// the message are slog key/value pairs or attributes, e.g.
+// logger.Info("Adding client", "mac", mac, "hostname", name).
 type Logger interface {
-	Error(msg string)
-	Warn(msg string)
-	Info(msg string)
-	Debug(msg string)
+	Error(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Info(msg string, args ...any)
+	Debug(msg string, args ...any)
+
+	// With returns a logger that adds the attributes to every message
+	With(args ...any) Logger
 }
 
 type LogConfig struct {
 	Level          LogLevel
+	Format         string // LogFormatText or LogFormatJSON, for the log file
 	FilePath       string
 	SyslogFacility string
 	MaxSize        int
@@ -71,16 +102,16 @@ type LogConfig struct {
 	Compress       bool
 }
 
+// DualLogger logs to syslog and to a rotated log file through slog
 type DualLogger struct {
-	fileLogger *log.Logger
-	sysLogger  *syslog.Writer
-	level      LogLevel
-	rotator    *lumberjack.Logger
+	logger  *slog.Logger
+	level   *slog.LevelVar // Shared by the loggers made by With, changed by SetLevel
+	rotator *lumberjack.Logger
 }
 
 func NewLogger(cfg LogConfig) (Logger, error) {
-	var dl DualLogger
-	dl.level = cfg.Level
+	level := new(slog.LevelVar)
+	level.Set(cfg.Level.slogLevel())
 
 	// Default to local3 facility if not specified
 	facility := cfg.SyslogFacility
@@ -94,7 +125,6 @@ func NewLogger(cfg LogConfig) (Logger, error) {
 	if err != nil {
 		return nil, fmt.Errorf("initializing syslog: %w", err)
 	}
-	dl.sysLogger = sysLogger
 
 	// If no file path specified, default to OPNsense location
 	if cfg.FilePath == "" {
@@ -108,7 +138,7 @@ func NewLogger(cfg LogConfig) (Logger, error) {
 	}
 
 	// Setup file logging with rotation
-	dl.rotator = &lumberjack.Logger{
+	rotator := &lumberjack.Logger{
 		Filename:   cfg.FilePath,
 		MaxSize:    cfg.MaxSize,
 		MaxBackups: cfg.MaxBackups,
@@ -116,49 +146,201 @@ func NewLogger(cfg LogConfig) (Logger, error) {
 		Compress:   cfg.Compress,
 	}
 
-	// Create file logger
-	dl.fileLogger = log.New(dl.rotator, "", log.LstdFlags)
+	var fileHandler slog.Handler
+	switch cfg.Format {
+	case LogFormatJSON:
+		fileHandler = slog.NewJSONHandler(rotator, &slog.HandlerOptions{Level: level})
+	case LogFormatText, "":
+		fileHandler = newTextHandler(rotator, level)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	handler := fanoutHandler{fileHandler, newSyslogHandler(sysLogger, level)}
+	return &DualLogger{logger: slog.New(handler), level: level, rotator: rotator}, nil
+}
 
-	return &dl, nil
+// SetLevel changes the most verbose level that is logged
+func (l *DualLogger) SetLevel(level LogLevel) {
+	l.level.Set(level.slogLevel())
 }
 
-func (l *DualLogger) log(level LogLevel, msg string) {
-	if l.level >= level {
-		// Always log to file if we have one
-		if l.fileLogger != nil {
-			l.fileLogger.Output(2, fmt.Sprintf("[%s] %s", level, msg))
+func (l *DualLogger) Error(msg string, args ...any) {
+	l.logger.Error(msg, args...)
+}
+
+func (l *DualLogger) Warn(msg string, args ...any) {
+	l.logger.Warn(msg, args...)
+}
+
+func (l *DualLogger) Info(msg string, args ...any) {
+	l.logger.Info(msg, args...)
+}
+
+func (l *DualLogger) Debug(msg string, args ...any) {
+	l.logger.Debug(msg, args...)
+}
+
+func (l *DualLogger) With(args ...any) Logger {
+	return &DualLogger{logger: l.logger.With(args...), level: l.level, rotator: l.rotator}
+}
+
+// fanoutHandler passes every record to all of its handlers
+type fanoutHandler []slog.Handler
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
 		}
+	}
+	return false
+}
 
-		// Also log to syslog with appropriate level
-		if l.sysLogger != nil {
-			switch level {
-			case LogLevelError:
-				l.sysLogger.Err(msg)
-			case LogLevelWarn:
-				l.sysLogger.Warning(msg)
-			case LogLevelInfo:
-				l.sysLogger.Info(msg)
-			case LogLevelDebug:
-				l.sysLogger.Debug(msg)
-			}
+func (h fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, handler := range h {
+		if handler.Enabled(ctx, r.Level) {
+			errs = append(errs, handler.Handle(ctx, r.Clone()))
 		}
 	}
+	return errors.Join(errs...)
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		next[i] = handler.WithAttrs(attrs)
+	}
+	return next
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	next := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		next[i] = handler.WithGroup(name)
+	}
+	return next
+}
+
+// lineHandler formats a record as its message followed by key=value
+// attributes and hands the line to write
+type lineHandler struct {
+	level  slog.Leveler
+	prefix string // Key prefix of the open groups
+	attrs  string // Attributes added by WithAttrs, already formatted
+	mu     *sync.Mutex
+	write  func(r slog.Record, line string) error
+}
+
+// newTextHandler logs lines like "2006/01/02 15:04:05 [INFO] msg key=value"
+// to w, the format used before the logger moved to slog
+func newTextHandler(w io.Writer, level slog.Leveler) *lineHandler {
+	return &lineHandler{
+		level: level,
+		mu:    new(sync.Mutex),
+		write: func(r slog.Record, line string) error {
+			_, err := fmt.Fprintf(w, "%s [%s] %s\n", r.Time.Format("2006/01/02 15:04:05"), r.Level, line)
+			return err
+		},
+	}
+}
+
+// newSyslogHandler logs to syslog with the priority of the record's level
+func newSyslogHandler(w *syslog.Writer, level slog.Leveler) *lineHandler {
+	return &lineHandler{
+		level: level,
+		mu:    new(sync.Mutex),
+		write: func(r slog.Record, line string) error {
+			switch {
+			case r.Level >= slog.LevelError:
+				return w.Err(line)
+			case r.Level >= slog.LevelWarn:
+				return w.Warning(line)
+			case r.Level >= slog.LevelInfo:
+				return w.Info(line)
+			default:
+				return w.Debug(line)
+			}
+		},
+	}
 }
 
-func (l *DualLogger) Error(msg string) {
-	l.log(LogLevelError, msg)
+func (h *lineHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
 }
 
-func (l *DualLogger) Warn(msg string) {
-	l.log(LogLevelWarn, msg)
+func (h *lineHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.prefix, a)
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.write(r, b.String())
 }
 
-func (l *DualLogger) Info(msg string) {
-	l.log(LogLevelInfo, msg)
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	for _, a := range attrs {
+		appendAttr(&b, h.prefix, a)
+	}
+	next := *h
+	next.attrs += b.String()
+	return &next
 }
 
-func (l *DualLogger) Debug(msg string) {
-	l.log(LogLevelDebug, msg)
+func (h *lineHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	next := *h
+	next.prefix += name + "."
+	return &next
+}
+
+// appendAttr formats an attribute as " key=value", flattening groups into
+// dotted keys
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, attr := range a.Value.Group() {
+			appendAttr(b, prefix, attr)
+		}
+		return
+	}
+
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " =\"\t\n") {
+		value = strconv.Quote(value)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, value)
+}
+
+// syncIDKey is the context key of the sync ID
+type syncIDKey struct{}
+
+// withSyncID returns a context for the sync with the given ID
+func withSyncID(ctx context.Context, id uint64) context.Context {
+	return context.WithValue(ctx, syncIDKey{}, id)
+}
+
+// log returns the service logger, tagged with the sync ID of ctx if any
+func (s *SyncService) log(ctx context.Context) Logger {
+	if id, ok := ctx.Value(syncIDKey{}).(uint64); ok {
+		return s.logger.With("sync_id", id)
+	}
+	return s.logger
 }
 
 // getFacility converts a facility string to syslog.Priority
@@ -0,0 +1,295 @@
+// pkg/metrics.go
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsNamespace prefixes every metric name
+const metricsNamespace = "dhcp_adguard_sync_"
+
+// Metric types of the Prometheus text format
+const (
+	counterMetric   = "counter"
+	gaugeMetric     = "gauge"
+	histogramMetric = "histogram"
+)
+
+// Histogram buckets in seconds
+var (
+	syncDurationBuckets    = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+// metric is a counter, gauge or histogram with a fixed set of labels
+type metric struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64 // Upper bounds of a histogram
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series holds the value of a metric for one combination of label values
+type series struct {
+	labelValues []string
+	value       float64  // Counter or gauge value
+	counts      []uint64 // Observations per histogram bucket
+	sum         float64
+	count       uint64
+}
+
+// get returns the series for the label values, creating it if needed; the
+// caller holds m.mu
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label(s), got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: labelValues}
+		if m.typ == histogramMetric {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// add increases a counter or gauge
+func (m *metric) add(delta float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labelValues).value += delta
+}
+
+// inc increases a counter by one
+func (m *metric) inc(labelValues ...string) {
+	m.add(1, labelValues...)
+}
+
+// set sets a gauge
+func (m *metric) set(value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labelValues).value = value
+}
+
+// observe adds an observation to a histogram
+func (m *metric) observe(value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(labelValues)
+	for i, bound := range m.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// reset drops every series, e.g. once the labels they carry are gone
+func (m *metric) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series = make(map[string]*series)
+}
+
+// write writes the metric in the Prometheus text format
+func (m *metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.typ != histogramMetric {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.labelValues), formatValue(s.value))
+			continue
+		}
+
+		names := append(append([]string{}, m.labels...), "le")
+		for i, bound := range m.buckets {
+			values := append(append([]string{}, s.labelValues...), formatValue(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(names, values), s.counts[i])
+		}
+		values := append(append([]string{}, s.labelValues...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(names, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labelValues), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labelValues), s.count)
+	}
+}
+
+// labelEscaper escapes label values as the text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats a label set, or nothing without labels
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatValue formats a sample value
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metricRegistry holds the metrics exposed by the service
+type metricRegistry struct {
+	metrics []*metric
+}
+
+// newMetric registers a metric
+func (r *metricRegistry) newMetric(typ, name, help string, buckets []float64, labels ...string) *metric {
+	m := &metric{
+		name:    metricsNamespace + name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// serviceMetrics are the metrics of the sync service and its targets
+type serviceMetrics struct {
+	registry metricRegistry
+
+	syncs           *metric
+	syncDuration    *metric
+	lastSuccess     *metric
+	clientChanges   *metric
+	managedClients  *metric
+	requestDuration *metric
+	apiErrors       *metric
+	leases          *metric
+	ndpEntries      *metric
+	ndpFailures     *metric
+}
+
+// newServiceMetrics registers the service metrics
+func newServiceMetrics() *serviceMetrics {
+	m := &serviceMetrics{}
+	r := &m.registry
+	m.syncs = r.newMetric(counterMetric, "syncs_total", "Syncs run, by result.", nil, "result")
+	m.syncDuration = r.newMetric(histogramMetric, "sync_duration_seconds", "Duration of a sync to all targets.", syncDurationBuckets)
+	m.lastSuccess = r.newMetric(gaugeMetric, "last_success_timestamp_seconds", "Unix time of the last successful sync.", nil)
+	m.clientChanges = r.newMetric(counterMetric, "client_changes_total", "Client changes applied to a target, by action and outcome.", nil, "target", "action", "outcome")
+	m.managedClients = r.newMetric(gaugeMetric, "clients", "Clients with a MAC address on a target after the last sync.", nil, "target")
+	m.requestDuration = r.newMetric(histogramMetric, "adguard_request_duration_seconds", "Latency of AdGuard Home API requests, by endpoint.", requestDurationBuckets, "target", "method", "endpoint")
+	m.apiErrors = r.newMetric(counterMetric, "adguard_errors_total", "Failed AdGuard Home API calls, by error class.", nil, "target", "class")
+	m.leases = r.newMetric(gaugeMetric, "leases", "Leases read from a lease source, by state.", nil, "source", "state")
+	m.ndpEntries = r.newMetric(gaugeMetric, "ndp_entries", "MAC addresses in the NDP table.", nil)
+	m.ndpFailures = r.newMetric(counterMetric, "ndp_scrape_failures_total", "Failed reads of the NDP table.", nil)
+
+	// Report the counters from the start rather than after their first event
+	m.syncs.add(0, "success")
+	m.syncs.add(0, "failure")
+	m.ndpFailures.add(0)
+	return m
+}
+
+// metrics collects the service metrics for the whole process
+var metrics = newServiceMetrics()
+
+// WriteMetrics writes every service metric in the Prometheus text format
+func WriteMetrics(w io.Writer) {
+	for _, m := range metrics.registry.metrics {
+		m.write(w)
+	}
+}
+
+// recordSync counts a finished sync
+func (m *serviceMetrics) recordSync(duration time.Duration, err error) {
+	m.syncDuration.observe(duration.Seconds())
+	if err != nil {
+		m.syncs.inc("failure")
+		return
+	}
+	m.syncs.inc("success")
+	m.lastSuccess.set(float64(time.Now().Unix()))
+}
+
+// recordLeases sets the lease counts of a lease source
+func (m *serviceMetrics) recordLeases(source string, leases map[string]ISCDHCPLease) {
+	active := 0
+	for _, lease := range leases {
+		if lease.IsActive {
+			active++
+		}
+	}
+	m.leases.set(float64(active), source, "active")
+	m.leases.set(float64(len(leases)-active), source, "inactive")
+}
+
+// changeAction names a client change for the metrics
+func changeAction(t AdguardUpdateType) string {
+	switch t {
+	case Add:
+		return "add"
+	case Update:
+		return "update"
+	case Remove:
+		return "remove"
+	}
+	return "none"
+}
+
+// instrumentedTransport records the latency of every request to a target
+type instrumentedTransport struct {
+	base   http.RoundTripper
+	target string
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := t.base.RoundTrip(req)
+	metrics.requestDuration.observe(time.Since(start).Seconds(), t.target, req.Method, req.URL.Path)
+	return res, err
+}
+
+// instrumentClient records the request latencies of the HTTP client under
+// the target's name
+func instrumentClient(client *http.Client, target string) {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &instrumentedTransport{base: base, target: target}
+}
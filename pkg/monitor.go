@@ -0,0 +1,93 @@
+// pkg/monitor.go
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// MonitorServer serves the service metrics and health checks over HTTP for
+// monitoring systems and supervisors
+type MonitorServer struct {
+	address        string
+	service        *SyncService
+	logger         Logger
+	readyIntervals int // Reconcile intervals the last successful sync may be old
+	server         *http.Server
+}
+
+// NewMonitorServer creates a monitoring server for service listening on
+// address, e.g. 127.0.0.1:9464
+func NewMonitorServer(address string, readyIntervals int, service *SyncService, logger Logger) *MonitorServer {
+	m := &MonitorServer{
+		address:        address,
+		service:        service,
+		logger:         logger,
+		readyIntervals: readyIntervals,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.handleMetrics)
+	mux.HandleFunc("/healthz", m.handleHealthz)
+	mux.HandleFunc("/readyz", m.handleReadyz)
+	m.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	return m
+}
+
+// Start listens on the address and serves requests in the background
+func (m *MonitorServer) Start() error {
+	listener, err := net.Listen("tcp", m.address)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", m.address, err)
+	}
+
+	go func() {
+		if err := m.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.logger.Error("HTTP listener failed", "address", m.address, "error", err)
+		}
+	}()
+	return nil
+}
+
+// Close stops serving
+func (m *MonitorServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return m.server.Shutdown(ctx)
+}
+
+// handleMetrics writes the metrics in the Prometheus text format
+func (m *MonitorServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteMetrics(w)
+}
+
+// handleHealthz answers whether the process and its watchers are running
+func (m *MonitorServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeCheck(w, m.service.Healthy())
+}
+
+// handleReadyz answers whether syncs succeed and the targets are reachable
+func (m *MonitorServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeCheck(w, m.service.Ready(m.readyIntervals))
+}
+
+// writeCheck answers a probe with 200 and "ok", or 503 and the problem
+func writeCheck(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -2,6 +2,7 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"os"
 )
@@ -22,6 +23,11 @@ func NewMultiLeaseReader(readers []LeaseReader, logger Logger, debug bool) *Mult
 	}
 }
 
+// Readers returns the underlying lease readers
+func (m *MultiLeaseReader) Readers() []LeaseReader {
+	return m.readers
+}
+
 // Path returns a comma-separated list of paths
 func (m *MultiLeaseReader) Path() string {
 	paths := make([]string, 0, len(m.readers))
@@ -32,36 +38,43 @@ func (m *MultiLeaseReader) Path() string {
 }
 
 // GetLeases reads leases from all configured sources and merges them
-func (m *MultiLeaseReader) GetLeases() (map[string]ISCDHCPLease, error) {
+func (m *MultiLeaseReader) GetLeases(ctx context.Context) (map[string]ISCDHCPLease, error) {
 	allLeases := make(map[string]ISCDHCPLease)
 
 	for _, reader := range m.readers {
 		// Skip readers with non-existent files
-		if _, err := os.Stat(reader.Path()); os.IsNotExist(err) {
-			if m.debug {
-				m.logger.Info(fmt.Sprintf("Lease file not found, skipping: %s", reader.Path()))
+		if _, remote := reader.(RemoteLeaseReader); !remote {
+			if _, err := os.Stat(reader.Path()); os.IsNotExist(err) {
+				if m.debug {
+					m.logger.Info("Lease file not found, skipping", "source", reader.Path())
+				}
+				continue
 			}
-			continue
 		}
 
-		leases, err := reader.GetLeases()
+		leases, err := reader.GetLeases(ctx)
 		if err != nil {
-			m.logger.Error(fmt.Sprintf("Error reading leases from %s: %v", reader.Path(), err))
+			// Without the leases of a remote source its clients would look
+			// stale and be removed, so the sync has to fail instead
+			if _, remote := reader.(RemoteLeaseReader); remote {
+				return nil, fmt.Errorf("reading leases from %s: %w", reader.Path(), err)
+			}
+			m.logger.Error("Error reading leases", "source", reader.Path(), "error", err)
 			continue
 		}
+		metrics.recordLeases(reader.Path(), leases)
 
 		// Merge leases, newer leases (from later readers) will overwrite older ones
 		for mac, lease := range leases {
 			if m.debug {
-				m.logger.Info(fmt.Sprintf("Found lease for %s: %s (%s) from %s",
-					mac, lease.IP, lease.Hostname, reader.Path()))
+				m.logger.Info("Found lease", "mac", mac, "ip", lease.IP, "hostname", lease.Hostname, "source", reader.Path())
 			}
 			allLeases[mac] = lease
 		}
 	}
 
 	if m.debug {
-		m.logger.Info(fmt.Sprintf("Combined %d leases from all sources", len(allLeases)))
+		m.logger.Info("Combined leases from all sources", "leases", len(allLeases))
 	}
 
 	return allLeases, nil
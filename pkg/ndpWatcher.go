@@ -3,8 +3,10 @@ package pkg
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"os/exec"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -26,7 +28,13 @@ type NDPTableWatcher struct {
 	interval  time.Duration
 	debug     bool
 	logger    Logger
-	callbacks []func(map[string][]string)
+	callbacks []func(map[string][]string, ChangeSet)
+
+	// Health of the background updates
+	running     bool
+	lastUpdate  time.Time
+	lastError   string
+	lastErrorAt time.Time
 }
 
 // NDPTableWatcherConfig holds configuration for the NDP table watcher
@@ -48,46 +56,90 @@ func NewNDPTableWatcher(cfg NDPTableWatcherConfig) (*NDPTableWatcher, error) {
 		interval:  cfg.UpdateInterval,
 		debug:     cfg.Debug,
 		logger:    cfg.Logger,
-		callbacks: make([]func(map[string][]string), 0),
+		callbacks: make([]func(map[string][]string, ChangeSet), 0),
 	}
 
 	// Perform initial table update
-	if err := watcher.updateTable(); err != nil {
+	if err := watcher.updateTable(context.Background()); err != nil {
 		return nil, fmt.Errorf("initial NDP table update failed: %w", err)
 	}
+	watcher.lastUpdate = time.Now()
 
 	return watcher, nil
 }
 
-// Start begins the background NDP table monitoring
-func (w *NDPTableWatcher) Start() {
+// Start begins the background NDP table monitoring, which runs until Stop
+// is called or ctx is cancelled
+func (w *NDPTableWatcher) Start(ctx context.Context) {
+	w.setRunning(true)
 	go func() {
 		ticker := time.NewTicker(w.interval)
 		defer ticker.Stop()
+		defer w.setRunning(false)
 
 		for {
 			select {
 			case <-ticker.C:
-				if err := w.updateTable(); err != nil && w.debug {
-					w.logger.Error(fmt.Sprintf("NDP table update failed: %v", err))
+				err := w.updateTable(ctx)
+				w.recordUpdate(err)
+				if err != nil && w.debug {
+					w.logger.Error("NDP table update failed", "error", err)
 				}
 			case <-w.done:
 				if w.debug {
 					w.logger.Info("NDP table watcher stopping")
 				}
 				return
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 }
 
+// setRunning records whether the background updates are running
+func (w *NDPTableWatcher) setRunning(running bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running = running
+}
+
+// recordUpdate records the outcome of a background update
+func (w *NDPTableWatcher) recordUpdate(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		metrics.ndpFailures.inc()
+		w.lastError = err.Error()
+		w.lastErrorAt = time.Now()
+		return
+	}
+	w.lastUpdate = time.Now()
+}
+
+// Health reports whether the background updates are running and how the
+// last ones went
+func (w *NDPTableWatcher) Health() WatcherHealth {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return WatcherHealth{
+		Name:        "NDP table",
+		Running:     w.running,
+		LastEvent:   optionalTime(w.lastUpdate),
+		LastError:   w.lastError,
+		LastErrorAt: optionalTime(w.lastErrorAt),
+		Entries:     len(w.table),
+	}
+}
+
 // Stop terminates the background NDP table monitoring
 func (w *NDPTableWatcher) Stop() {
 	close(w.done)
 }
 
-// AddCallback registers a function to be called when the NDP table is updated
-func (w *NDPTableWatcher) AddCallback(cb func(map[string][]string)) {
+// AddCallback registers a function to be called with the new table and the
+// changed MACs when the NDP table is updated
+func (w *NDPTableWatcher) AddCallback(cb func(map[string][]string, ChangeSet)) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	w.callbacks = append(w.callbacks, cb)
@@ -126,12 +178,12 @@ func (w *NDPTableWatcher) GetIP6forMAC(mac string) ([]string, error) {
 }
 
 // updateTable refreshes the NDP table data
-func (w *NDPTableWatcher) updateTable() error {
+func (w *NDPTableWatcher) updateTable(ctx context.Context) error {
 	//if w.debug {
 	//	w.logger.Info("Updating NDP table")
 	//}
 
-	cmd := exec.Command("ndp", "-an")
+	cmd := exec.CommandContext(ctx, "ndp", "-an")
 	var out bytes.Buffer
 	cmd.Stdout = &out
 
@@ -159,12 +211,13 @@ func (w *NDPTableWatcher) updateTable() error {
 	if err := scanner.Err(); err != nil {
 		return fmt.Errorf("scanning ndp output: %w", err)
 	}
+	metrics.ndpEntries.set(float64(len(newTable)))
 
 	// Check for changes before updating
-	if w.hasChanges(newTable) {
+	if changes := w.diff(newTable); !changes.Empty() {
 		w.mu.Lock()
 		w.table = newTable
-		callbacks := make([]func(map[string][]string), len(w.callbacks))
+		callbacks := make([]func(map[string][]string, ChangeSet), len(w.callbacks))
 		copy(callbacks, w.callbacks)
 		w.mu.Unlock()
 
@@ -177,31 +230,26 @@ func (w *NDPTableWatcher) updateTable() error {
 		}
 
 		for _, cb := range callbacks {
-			cb(tableCopy)
+			cb(tableCopy, changes)
 		}
 	}
 
 	return nil
 }
 
-// hasChanges compares the new table with the current one to detect changes
-func (w *NDPTableWatcher) hasChanges(newTable map[string][]string) bool {
+// diff compares the new table with the current one and returns the MACs
+// whose IPv6 addresses appeared, changed or disappeared
+func (w *NDPTableWatcher) diff(newTable map[string][]string) ChangeSet {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
 
-	if len(w.table) != len(newTable) {
-		if w.debug {
-			w.logger.Info(fmt.Sprintf("NDP table size changed: old=%d new=%d", len(w.table), len(newTable)))
-		}
-		return true
-	}
-
-	// Track removed MACs
-	if w.debug {
-		for mac := range w.table {
-			if _, exists := newTable[mac]; !exists {
-				w.logger.Info(fmt.Sprintf("MAC removed from NDP table: %s (had IPs: %v)", mac, w.table[mac]))
+	var changes ChangeSet
+	for mac, currentIPs := range w.table {
+		if _, exists := newTable[mac]; !exists {
+			if w.debug {
+				w.logger.Info("MAC removed from NDP table", "mac", mac, "ip", currentIPs)
 			}
+			changes.Removed = append(changes.Removed, mac)
 		}
 	}
 
@@ -209,48 +257,41 @@ func (w *NDPTableWatcher) hasChanges(newTable map[string][]string) bool {
 		currentIPs, exists := w.table[mac]
 		if !exists {
 			if w.debug {
-				w.logger.Info(fmt.Sprintf("New MAC in NDP table: %s (IPs: %v)", mac, newIPs))
+				w.logger.Info("New MAC in NDP table", "mac", mac, "ip", newIPs)
 			}
-			return true
+			changes.Added = append(changes.Added, mac)
+			continue
 		}
 
-		if len(currentIPs) != len(newIPs) {
+		if !sameAddresses(currentIPs, newIPs) {
 			if w.debug {
-				w.logger.Info(fmt.Sprintf("IP count changed for MAC %s: old=%d new=%d", mac, len(currentIPs), len(newIPs)))
+				w.logger.Info("IPs changed in NDP table", "mac", mac, "old_ip", currentIPs, "ip", newIPs)
 			}
-			return true
-		}
-
-		// Create maps for easier comparison
-		currentIPMap := make(map[string]bool)
-		for _, ip := range currentIPs {
-			currentIPMap[ip] = true
+			changes.Changed = append(changes.Changed, mac)
 		}
+	}
 
-		for _, ip := range newIPs {
-			if !currentIPMap[ip] {
-				if w.debug {
-					w.logger.Info(fmt.Sprintf("New IP for MAC %s: %s", mac, ip))
-				}
-				return true
-			}
-		}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Changed)
+	sort.Strings(changes.Removed)
+	return changes
+}
 
-		// Check for removed IPs
-		if w.debug {
-			newIPMap := make(map[string]bool)
-			for _, ip := range newIPs {
-				newIPMap[ip] = true
-			}
-			for _, ip := range currentIPs {
-				if !newIPMap[ip] {
-					w.logger.Info(fmt.Sprintf("IP removed from MAC %s: %s", mac, ip))
-				}
-			}
+// sameAddresses reports whether both lists hold the same addresses
+func sameAddresses(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	present := make(map[string]bool, len(a))
+	for _, ip := range a {
+		present[ip] = true
+	}
+	for _, ip := range b {
+		if !present[ip] {
+			return false
 		}
 	}
-
-	return false
+	return true
 }
 
 // IsValidMAC checks if a string is a valid MAC address
@@ -0,0 +1,103 @@
+// pkg/oui.go
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// OUIDatabase maps IEEE OUI prefixes (first three MAC octets) to vendor names
+type OUIDatabase struct {
+	vendors map[string]string
+}
+
+// LoadOUIDatabase reads an OUI vendor file. The IEEE oui.txt format
+// ("00-00-0C   (hex)  Cisco Systems, Inc"), the nmap-mac-prefixes format
+// ("00000C Cisco Systems") and the Wireshark manuf format
+// ("00:00:0C  Cisco  Cisco Systems, Inc") are all understood.
+func LoadOUIDatabase(path string) (*OUIDatabase, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening OUI file: %w", err)
+	}
+	defer file.Close()
+
+	db := &OUIDatabase{vendors: make(map[string]string)}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		prefix := normalizeOUI(fields[0])
+		if prefix == "" {
+			continue // Skip headers and longer (/28, /36) assignments
+		}
+
+		vendor := fields[1:]
+		if vendor[0] == "(hex)" {
+			vendor = vendor[1:]
+		}
+		if len(vendor) == 0 {
+			continue
+		}
+
+		// Wireshark lists a short name followed by the full name
+		if name := strings.SplitN(line, "\t", 3); len(name) == 3 {
+			vendor = strings.Fields(name[2])
+		}
+
+		db.vendors[prefix] = strings.Join(vendor, " ")
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanning OUI file: %w", err)
+	}
+
+	return db, nil
+}
+
+// Vendor returns the vendor registered for the MAC address's OUI, or an
+// empty string if it is unknown
+func (db *OUIDatabase) Vendor(mac string) string {
+	if db == nil {
+		return ""
+	}
+	clean := strings.ReplaceAll(strings.ReplaceAll(mac, ":", ""), "-", "")
+	if len(clean) < 6 {
+		return ""
+	}
+	return db.vendors[strings.ToUpper(clean[:6])]
+}
+
+// Len returns the number of known prefixes
+func (db *OUIDatabase) Len() int {
+	if db == nil {
+		return 0
+	}
+	return len(db.vendors)
+}
+
+// normalizeOUI converts a three-octet prefix to upper-case hex without
+// separators, returning an empty string if s is not such a prefix
+func normalizeOUI(s string) string {
+	clean := strings.ToUpper(strings.NewReplacer(":", "", "-", "", ".", "").Replace(s))
+	if len(clean) != 6 {
+		return ""
+	}
+	for _, char := range clean {
+		if !((char >= '0' && char <= '9') || (char >= 'A' && char <= 'F')) {
+			return ""
+		}
+	}
+	return clean
+}
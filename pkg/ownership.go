@@ -0,0 +1,124 @@
+// pkg/ownership.go
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// OwnershipStore persists the set of entries this service created in a
+// target, so entries created by hand are never modified or removed.
+// Entries are grouped in named sections, e.g. "rewrites".
+type OwnershipStore struct {
+	path     string
+	mu       sync.Mutex
+	sections map[string]map[string]bool
+}
+
+// NewOwnershipStore loads the ownership state from path. A missing file
+// results in an empty store.
+func NewOwnershipStore(path string) (*OwnershipStore, error) {
+	store := &OwnershipStore{
+		path:     path,
+		sections: make(map[string]map[string]bool),
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+
+	var saved map[string][]string
+	if err := json.Unmarshal(content, &saved); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+	for section, keys := range saved {
+		entries := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			entries[key] = true
+		}
+		store.sections[section] = entries
+	}
+
+	return store, nil
+}
+
+// Owns reports whether the key in section was created by this service
+func (o *OwnershipStore) Owns(section, key string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.sections[section][key]
+}
+
+// Keys returns the sorted keys owned in section
+func (o *OwnershipStore) Keys(section string) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	keys := make([]string, 0, len(o.sections[section]))
+	for key := range o.sections[section] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Add records key in section as owned
+func (o *OwnershipStore) Add(section, key string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.sections[section] == nil {
+		o.sections[section] = make(map[string]bool)
+	}
+	o.sections[section][key] = true
+}
+
+// Remove forgets key in section
+func (o *OwnershipStore) Remove(section, key string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.sections[section], key)
+}
+
+// Save atomically writes the ownership state to disk
+func (o *OwnershipStore) Save() error {
+	// Hold the lock while writing so concurrent saves don't interleave
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	saved := make(map[string][]string, len(o.sections))
+	for section, entries := range o.sections {
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		saved[section] = keys
+	}
+
+	content, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(o.path), 0755); err != nil {
+		return fmt.Errorf("creating state directory: %w", err)
+	}
+
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		return fmt.Errorf("replacing state file: %w", err)
+	}
+	return nil
+}
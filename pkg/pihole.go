@@ -0,0 +1,477 @@
+// pkg/pihole.go
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PiHole is a ClientStore backed by the Pi-hole v6 REST API. Clients are
+// identified by MAC address and carry the client name as their comment.
+type PiHole struct {
+	name       string
+	baseURL    string
+	password   string
+	httpClient *http.Client
+
+	// groups assigns Pi-hole groups to clients by subnet
+	groups []PiHoleGroupRule
+
+	mu  sync.Mutex
+	sid string
+
+	// groupsMu guards the group cache and serializes group creation between
+	// concurrent client changes
+	groupsMu    sync.Mutex
+	groupsCache map[string]int // Group IDs by name, read once per sync
+}
+
+// PiHoleGroupRule assigns a Pi-hole group to clients in a subnet
+type PiHoleGroupRule struct {
+	Group string
+	Match LeaseMatcher
+}
+
+// piholeClient is a client entry of the /api/clients endpoint
+type piholeClient struct {
+	Client  string `json:"client"`
+	Comment string `json:"comment"`
+	Groups  []int  `json:"groups"`
+}
+
+// piholeGroup is a group entry of the /api/groups endpoint
+type piholeGroup struct {
+	ID      int    `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Comment string `json:"comment,omitempty"`
+	Enabled bool   `json:"enabled"`
+}
+
+// NewPiHole creates a Pi-hole v6 client store for a target
+func NewPiHole(cfg TargetConfig) (*PiHole, error) {
+	scheme := "http"
+	if cfg.Scheme != "" {
+		scheme = cfg.Scheme
+	}
+
+	timeout := 10
+	if cfg.Timeout > 0 {
+		timeout = cfg.Timeout
+	}
+
+	host := strings.TrimPrefix(strings.TrimPrefix(cfg.URL, "http://"), "https://")
+	host = strings.TrimSuffix(host, "/")
+	if host == "" {
+		return nil, fmt.Errorf("creating Pi-hole client: url is required")
+	}
+
+	httpClient := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	rateLimitClient(httpClient, cfg.RequestsPerSecond)
+
+	return &PiHole{
+		name:       cfg.Name,
+		baseURL:    fmt.Sprintf("%s://%s/api", scheme, host),
+		password:   cfg.Password,
+		httpClient: httpClient,
+		groups:     cfg.PiHoleGroups,
+	}, nil
+}
+
+// Name returns the target name of this Pi-hole instance
+func (p *PiHole) Name() string {
+	return p.name
+}
+
+// ListClients returns the Pi-hole clients identified by a MAC address
+func (p *PiHole) ListClients(ctx context.Context) ([]StoreClient, error) {
+	var resp struct {
+		Clients []piholeClient `json:"clients"`
+	}
+	if err := p.request(ctx, http.MethodGet, "/clients", nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting clients: %w", err)
+	}
+
+	// Every sync starts by listing the clients; pick up group changes made
+	// in Pi-hole since the previous one
+	p.groupsMu.Lock()
+	p.groupsCache = nil
+	p.groupsMu.Unlock()
+
+	clients := make([]StoreClient, 0, len(resp.Clients))
+	for _, client := range resp.Clients {
+		if !IsValidMAC(client.Client) {
+			continue
+		}
+		clients = append(clients, StoreClient{
+			Name:   client.Comment,
+			MAC:    strings.ToLower(client.Client),
+			native: client,
+		})
+	}
+	return clients, nil
+}
+
+// AddClient creates a Pi-hole client for the MAC with the name as comment
+func (p *PiHole) AddClient(ctx context.Context, c StoreClient) error {
+	groups, err := p.groupIDs(ctx, c.IDs, nil)
+	if err != nil {
+		return err
+	}
+
+	client := piholeClient{
+		Client:  strings.ToLower(c.MAC),
+		Comment: c.Name,
+		Groups:  groups,
+	}
+	if err := p.request(ctx, http.MethodPost, "/clients", client, nil); err != nil {
+		return fmt.Errorf("creating client: %w", err)
+	}
+	return nil
+}
+
+// UpdateClient updates the groups of a Pi-hole client, keeping its comment
+func (p *PiHole) UpdateClient(ctx context.Context, current, desired StoreClient) error {
+	existing, _ := current.native.(piholeClient)
+	groups, err := p.groupIDs(ctx, desired.IDs, existing.Groups)
+	if err != nil {
+		return err
+	}
+
+	client := piholeClient{
+		Client:  current.MAC,
+		Comment: current.Name,
+		Groups:  groups,
+	}
+	if err := p.request(ctx, http.MethodPut, "/clients/"+url.PathEscape(current.MAC), client, nil); err != nil {
+		if ErrorStatusOf(err) == http.StatusNotFound {
+			return fmt.Errorf("updating client %s: %w: %v", current.MAC, ErrClientNotFound, err)
+		}
+		return fmt.Errorf("updating client: %w", err)
+	}
+	return nil
+}
+
+// RemoveClient deletes a Pi-hole client
+func (p *PiHole) RemoveClient(ctx context.Context, c StoreClient) error {
+	if err := p.request(ctx, http.MethodDelete, "/clients/"+url.PathEscape(c.MAC), nil, nil); err != nil {
+		return fmt.Errorf("deleting client: %w", err)
+	}
+	return nil
+}
+
+// Differs reports whether a Pi-hole client's groups are out of date. Like
+// on every target, the name is only set when the client is created. Pi-hole
+// clients are identified by MAC alone, so IP addresses are only used to
+// pick the groups.
+func (p *PiHole) Differs(ctx context.Context, current, desired StoreClient) (bool, string) {
+	existing, ok := current.native.(piholeClient)
+	if !ok || len(p.groups) == 0 {
+		return false, ""
+	}
+
+	wanted := p.groupNames(desired.IDs, interfacesFrom(ctx))
+	have, err := p.groupNamesByID(ctx, existing.Groups)
+	if err != nil || !sameStrings(wanted, have) {
+		return true, fmt.Sprintf("groups changed: %v", wanted)
+	}
+	return false, ""
+}
+
+// ListRecords returns the Pi-hole local DNS hosts, one record per name
+func (p *PiHole) ListRecords(ctx context.Context) ([]DNSRecord, error) {
+	var resp struct {
+		Config struct {
+			DNS struct {
+				Hosts []string `json:"hosts"`
+			} `json:"dns"`
+		} `json:"config"`
+	}
+	if err := p.request(ctx, http.MethodGet, "/config/dns/hosts", nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting local DNS records: %w", err)
+	}
+
+	var records []DNSRecord
+	for _, entry := range resp.Config.DNS.Hosts {
+		// Entries use the hosts file format: address followed by names
+		fields := strings.Fields(entry)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, name := range fields[1:] {
+			records = append(records, DNSRecord{Domain: name, Answer: fields[0]})
+		}
+	}
+	return records, nil
+}
+
+// AddRecord creates a local DNS host resolving domain to answer
+func (p *PiHole) AddRecord(ctx context.Context, domain, answer string) error {
+	if err := p.request(ctx, http.MethodPut, "/config/dns/hosts/"+url.PathEscape(answer+" "+domain), nil, nil); err != nil {
+		return fmt.Errorf("adding local DNS record: %w", err)
+	}
+	return nil
+}
+
+// RemoveRecord deletes the local DNS host resolving domain to answer
+func (p *PiHole) RemoveRecord(ctx context.Context, domain, answer string) error {
+	if err := p.request(ctx, http.MethodDelete, "/config/dns/hosts/"+url.PathEscape(answer+" "+domain), nil, nil); err != nil {
+		return fmt.Errorf("removing local DNS record: %w", err)
+	}
+	return nil
+}
+
+// groupNames returns the sorted names of the groups matching any of the IDs
+func (p *PiHole) groupNames(ids []string, interfaces Interfaces) []string {
+	var names []string
+	for _, rule := range p.groups {
+		for _, id := range ids {
+			if rule.Match.Matches(ISCDHCPLease{IP: id}, interfaces) && !containsString(names, rule.Group) {
+				names = append(names, rule.Group)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// groupIDs returns the Pi-hole group IDs for a client, creating missing
+// groups. Groups assigned outside the managed rules are kept; new clients
+// start out in the Default group (ID 0).
+func (p *PiHole) groupIDs(ctx context.Context, ids []string, existing []int) ([]int, error) {
+	result := []int{0}
+	if len(existing) > 0 {
+		result = existing
+	}
+	if len(p.groups) == 0 {
+		return result, nil
+	}
+
+	p.groupsMu.Lock()
+	defer p.groupsMu.Unlock()
+
+	groups, err := p.cachedGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop managed groups; they are re-added below if they still match
+	managed := make(map[int]bool)
+	for _, rule := range p.groups {
+		if id, found := groups[rule.Group]; found {
+			managed[id] = true
+		}
+	}
+	var kept []int
+	for _, id := range result {
+		if !managed[id] {
+			kept = append(kept, id)
+		}
+	}
+	result = kept
+
+	for _, name := range p.groupNames(ids, interfacesFrom(ctx)) {
+		id, found := groups[name]
+		if !found {
+			group := piholeGroup{Name: name, Comment: "Managed by dhcp-adguard-sync", Enabled: true}
+			if err := p.request(ctx, http.MethodPost, "/groups", group, nil); err != nil {
+				return nil, fmt.Errorf("creating group %s: %w", name, err)
+			}
+			p.groupsCache = nil
+			if groups, err = p.cachedGroups(ctx); err != nil {
+				return nil, err
+			}
+			if id, found = groups[name]; !found {
+				return nil, fmt.Errorf("group %s missing after creation", name)
+			}
+		}
+		result = append(result, id)
+	}
+	return result, nil
+}
+
+// groupNamesByID returns the sorted names of the managed groups among ids
+func (p *PiHole) groupNamesByID(ctx context.Context, ids []int) ([]string, error) {
+	p.groupsMu.Lock()
+	defer p.groupsMu.Unlock()
+
+	groups, err := p.cachedGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	managed := make(map[string]bool)
+	for _, rule := range p.groups {
+		managed[rule.Group] = true
+	}
+
+	var names []string
+	for name, id := range groups {
+		for _, groupID := range ids {
+			if groupID == id && managed[name] {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// cachedGroups returns the Pi-hole group IDs by name, reading them on first
+// use after the cache was cleared; the caller holds groupsMu
+func (p *PiHole) cachedGroups(ctx context.Context) (map[string]int, error) {
+	if p.groupsCache == nil {
+		groups, err := p.listGroups(ctx)
+		if err != nil {
+			return nil, err
+		}
+		p.groupsCache = groups
+	}
+	return p.groupsCache, nil
+}
+
+// listGroups returns the Pi-hole groups keyed by name
+func (p *PiHole) listGroups(ctx context.Context) (map[string]int, error) {
+	var resp struct {
+		Groups []piholeGroup `json:"groups"`
+	}
+	if err := p.request(ctx, http.MethodGet, "/groups", nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting groups: %w", err)
+	}
+
+	groups := make(map[string]int, len(resp.Groups))
+	for _, group := range resp.Groups {
+		groups[group.Name] = group.ID
+	}
+	return groups, nil
+}
+
+// request performs an authenticated API request, logging in first if there
+// is no session yet and once more if the session has expired. Errors are
+// classified like those of AdGuard Home, so failed syncs are only retried
+// when that can help.
+func (p *PiHole) request(ctx context.Context, method, path string, payload, out any) error {
+	sid, err := p.session(ctx, "")
+	if err != nil {
+		return classifyError(err)
+	}
+
+	status, body, err := p.do(ctx, method, path, sid, payload)
+	if err == nil && status == http.StatusUnauthorized {
+		if sid, err = p.session(ctx, sid); err != nil {
+			return classifyError(err)
+		}
+		status, body, err = p.do(ctx, method, path, sid, payload)
+	}
+	if err != nil {
+		return classifyError(err)
+	}
+
+	if status < 200 || status > 299 {
+		return classifyError(fmt.Errorf("status: %d, body: %s", status, body))
+	}
+	if out != nil && len(body) > 0 {
+		return json.Unmarshal(body, out)
+	}
+	return nil
+}
+
+// session returns the current session ID, logging in if there is none or
+// it is still the rejected one. Concurrent requests rejected with the same
+// session so share a single login.
+func (p *PiHole) session(ctx context.Context, rejected string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.sid != "" && p.sid != rejected {
+		return p.sid, nil
+	}
+
+	var resp struct {
+		Session struct {
+			Valid   bool   `json:"valid"`
+			SID     string `json:"sid"`
+			Message string `json:"message"`
+		} `json:"session"`
+	}
+
+	status, body, err := p.do(ctx, http.MethodPost, "/auth", "", map[string]string{"password": p.password})
+	if err != nil {
+		return "", fmt.Errorf("authenticating: %w", err)
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("authenticating: status: %d, body: %s", status, body)
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("authenticating: %w", err)
+	}
+	if !resp.Session.Valid {
+		return "", &TargetError{Kind: ErrorAuth, Err: fmt.Errorf("authenticating: %s", resp.Session.Message)}
+	}
+
+	p.sid = resp.Session.SID
+	return p.sid, nil
+}
+
+// Close logs out of the session. Pi-hole only allows a few API sessions
+// at a time, so they are released rather than left to expire.
+func (p *PiHole) Close(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.sid == "" {
+		return nil
+	}
+	status, body, err := p.do(ctx, http.MethodDelete, "/auth", p.sid, nil)
+	p.sid = ""
+	if err != nil {
+		return fmt.Errorf("logging out: %w", err)
+	}
+	// An expired session is gone already
+	if status != http.StatusNoContent && status != http.StatusOK && status != http.StatusUnauthorized {
+		return fmt.Errorf("logging out: status: %d, body: %s", status, body)
+	}
+	return nil
+}
+
+// do sends a single request and returns the status code and body
+func (p *PiHole) do(ctx context.Context, method, path, sid string, payload any) (int, []byte, error) {
+	var body io.Reader
+	if payload != nil {
+		rb, err := json.Marshal(payload)
+		if err != nil {
+			return 0, nil, err
+		}
+		body = bytes.NewReader(rb)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sid != "" {
+		req.Header.Set("X-FTL-SID", sid)
+	}
+
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	respBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return res.StatusCode, respBody, nil
+}
@@ -0,0 +1,516 @@
+// pkg/pihole_test.go
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakePiHole serves the parts of the Pi-hole v6 API the store uses
+type fakePiHole struct {
+	password string
+
+	mu       sync.Mutex
+	sid      string
+	sessions int
+	clients  map[string]piholeClient
+	groups   []piholeGroup
+	hosts    []string
+	requests map[string]int // "METHOD /path" without the path value
+}
+
+func newFakePiHole(t *testing.T) (*fakePiHole, *httptest.Server) {
+	f := &fakePiHole{
+		password: "secret",
+		clients:  make(map[string]piholeClient),
+		groups:   []piholeGroup{{ID: 0, Name: "Default", Enabled: true}},
+		requests: make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/auth", f.handleAuth)
+	mux.HandleFunc("DELETE /api/auth", f.authed(f.handleLogout))
+	mux.HandleFunc("GET /api/clients", f.authed(f.handleListClients))
+	mux.HandleFunc("POST /api/clients", f.authed(f.handleAddClient))
+	mux.HandleFunc("PUT /api/clients/{client}", f.authed(f.handleUpdateClient))
+	mux.HandleFunc("DELETE /api/clients/{client}", f.authed(f.handleRemoveClient))
+	mux.HandleFunc("GET /api/groups", f.authed(f.handleListGroups))
+	mux.HandleFunc("POST /api/groups", f.authed(f.handleAddGroup))
+	mux.HandleFunc("GET /api/config/dns/hosts", f.authed(f.handleListHosts))
+	mux.HandleFunc("PUT /api/config/dns/hosts/{host}", f.authed(f.handleAddHost))
+	mux.HandleFunc("DELETE /api/config/dns/hosts/{host}", f.authed(f.handleRemoveHost))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+// newTestPiHole creates a store talking to the fake server
+func newTestPiHole(t *testing.T, server *httptest.Server, groups ...PiHoleGroupRule) *PiHole {
+	t.Helper()
+	p, err := NewPiHole(TargetConfig{Name: "pihole", URL: server.URL, Password: "secret", PiHoleGroups: groups})
+	if err != nil {
+		t.Fatalf("NewPiHole: %v", err)
+	}
+	return p
+}
+
+// groupRule assigns group to clients in cidr
+func groupRule(t *testing.T, group, cidr string) PiHoleGroupRule {
+	t.Helper()
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return PiHoleGroupRule{Group: group, Match: LeaseMatcher{CIDRs: []*net.IPNet{subnet}}}
+}
+
+// expireSession invalidates the current session, as Pi-hole does after its
+// session timeout
+func (f *fakePiHole) expireSession() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sid = ""
+}
+
+// count returns how often the endpoint was requested
+func (f *fakePiHole) count(endpoint string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[endpoint]
+}
+
+// groupName returns the name of the group with id
+func (f *fakePiHole) groupName(id int) string {
+	for _, group := range f.groups {
+		if group.ID == id {
+			return group.Name
+		}
+	}
+	return ""
+}
+
+// clientGroups returns the sorted group names of a client
+func (f *fakePiHole) clientGroups(mac string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, id := range f.clients[mac].Groups {
+		names = append(names, f.groupName(id))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *fakePiHole) handleAuth(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests["POST /api/auth"]++
+	if req.Password != f.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"session":{"valid":false,"message":"password incorrect"}}`)
+		return
+	}
+	f.sessions++
+	f.sid = fmt.Sprintf("sid-%d", f.sessions)
+	fmt.Fprintf(w, `{"session":{"valid":true,"sid":%q}}`, f.sid)
+}
+
+// authed rejects requests without the current session ID and counts the
+// others
+func (f *fakePiHole) authed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		valid := f.sid != "" && r.Header.Get("X-FTL-SID") == f.sid
+		if valid {
+			pattern := strings.SplitN(r.Pattern, "/{", 2)[0]
+			f.requests[pattern]++
+		}
+		f.mu.Unlock()
+
+		if !valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"key":"unauthorized"}}`)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (f *fakePiHole) handleListClients(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	clients := make([]piholeClient, 0, len(f.clients))
+	for _, client := range f.clients {
+		clients = append(clients, client)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"clients": clients})
+}
+
+func (f *fakePiHole) handleAddClient(w http.ResponseWriter, r *http.Request) {
+	var client piholeClient
+	if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clients[client.Client] = client
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (f *fakePiHole) handleUpdateClient(w http.ResponseWriter, r *http.Request) {
+	var client piholeClient
+	if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, found := f.clients[r.PathValue("client")]; !found {
+		http.Error(w, `{"error":{"key":"not_found"}}`, http.StatusNotFound)
+		return
+	}
+	f.clients[r.PathValue("client")] = client
+}
+
+func (f *fakePiHole) handleRemoveClient(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.clients, r.PathValue("client"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakePiHole) handleListGroups(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]any{"groups": f.groups})
+}
+
+func (f *fakePiHole) handleAddGroup(w http.ResponseWriter, r *http.Request) {
+	var group piholeGroup
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group.ID = len(f.groups)
+	f.groups = append(f.groups, group)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (f *fakePiHole) handleListHosts(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(w, `{"config":{"dns":{"hosts":%s}}}`, mustJSON(f.hosts))
+}
+
+func (f *fakePiHole) handleAddHost(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hosts = append(f.hosts, r.PathValue("host"))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (f *fakePiHole) handleRemoveHost(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, host := range f.hosts {
+		if host == r.PathValue("host") {
+			f.hosts = append(f.hosts[:i], f.hosts[i+1:]...)
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func mustJSON(v any) string {
+	content, _ := json.Marshal(v)
+	if string(content) == "null" {
+		return "[]"
+	}
+	return string(content)
+}
+
+func (f *fakePiHole) handleLogout(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sid = ""
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestPiHoleSessionAuth(t *testing.T) {
+	fake, server := newFakePiHole(t)
+	p := newTestPiHole(t, server)
+	ctx := context.Background()
+
+	// The first request logs in, later ones reuse the session
+	for i := 0; i < 3; i++ {
+		if _, err := p.ListClients(ctx); err != nil {
+			t.Fatalf("ListClients: %v", err)
+		}
+	}
+	if got := fake.count("POST /api/auth"); got != 1 {
+		t.Errorf("logins = %d, want 1", got)
+	}
+
+	// An expired session is renewed once and the request repeated
+	fake.expireSession()
+	if _, err := p.ListClients(ctx); err != nil {
+		t.Fatalf("ListClients after expiry: %v", err)
+	}
+	if got := fake.count("POST /api/auth"); got != 2 {
+		t.Errorf("logins after expiry = %d, want 2", got)
+	}
+	if got := fake.count("GET /api/clients"); got != 4 {
+		t.Errorf("client listings = %d, want 4", got)
+	}
+}
+
+func TestPiHoleConcurrentReauth(t *testing.T) {
+	fake, server := newFakePiHole(t)
+	p := newTestPiHole(t, server)
+	ctx := context.Background()
+
+	if _, err := p.ListClients(ctx); err != nil {
+		t.Fatalf("ListClients: %v", err)
+	}
+
+	// Every request is rejected with the expired session, but only the
+	// first one logs in again
+	fake.expireSession()
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.ListClients(ctx)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("ListClients: %v", err)
+		}
+	}
+	if got := fake.count("POST /api/auth"); got != 2 {
+		t.Errorf("logins = %d, want 2", got)
+	}
+}
+
+func TestPiHoleClose(t *testing.T) {
+	fake, server := newFakePiHole(t)
+	p := newTestPiHole(t, server)
+	ctx := context.Background()
+
+	// Without a session there is nothing to log out of
+	if err := p.Close(ctx); err != nil {
+		t.Fatalf("Close without a session: %v", err)
+	}
+	if got := fake.count("DELETE /api/auth"); got != 0 {
+		t.Errorf("logouts without a session = %d, want 0", got)
+	}
+
+	if _, err := p.ListClients(ctx); err != nil {
+		t.Fatalf("ListClients: %v", err)
+	}
+	if err := p.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := fake.count("DELETE /api/auth"); got != 1 {
+		t.Errorf("logouts = %d, want 1", got)
+	}
+	fake.mu.Lock()
+	sid := fake.sid
+	fake.mu.Unlock()
+	if sid != "" {
+		t.Error("session still valid after Close")
+	}
+}
+
+func TestPiHoleWrongPassword(t *testing.T) {
+	_, server := newFakePiHole(t)
+	p := newTestPiHole(t, server)
+	p.password = "wrong"
+
+	_, err := p.ListClients(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "authenticating") {
+		t.Fatalf("ListClients error = %v, want an authentication error", err)
+	}
+	if kind := ErrorKindOf(err); kind != ErrorAuth {
+		t.Errorf("error kind = %s, want %s", kind, ErrorAuth)
+	}
+	// A wrong password doesn't fix itself, so no catch-up sync is scheduled
+	if (TargetResult{Target: "pihole", Err: err}).NeedsRetry() {
+		t.Error("NeedsRetry() = true for rejected credentials")
+	}
+}
+
+func TestPiHoleClientLifecycle(t *testing.T) {
+	fake, server := newFakePiHole(t)
+	p := newTestPiHole(t, server)
+	ctx := context.Background()
+	mac := "aa:bb:cc:dd:ee:01"
+
+	if err := p.AddClient(ctx, StoreClient{Name: "laptop", MAC: "AA:BB:CC:DD:EE:01", IDs: []string{"192.168.1.10"}}); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	clients, err := p.ListClients(ctx)
+	if err != nil {
+		t.Fatalf("ListClients: %v", err)
+	}
+	if len(clients) != 1 || clients[0].MAC != mac || clients[0].Name != "laptop" {
+		t.Fatalf("clients after add = %+v, want laptop (%s)", clients, mac)
+	}
+	if got := fake.clientGroups(mac); len(got) != 1 || got[0] != "Default" {
+		t.Errorf("groups of a new client = %v, want [Default]", got)
+	}
+
+	// The name is only set on creation, so a client renamed in Pi-hole or
+	// by a changed name format keeps its comment
+	current := clients[0]
+	desired := StoreClient{Name: "laptop-2", MAC: mac}
+	if differs, reason := p.Differs(ctx, current, desired); differs {
+		t.Errorf("Differs = true (%s) for a different name", reason)
+	}
+	if err := p.UpdateClient(ctx, current, desired); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	if clients, _ = p.ListClients(ctx); len(clients) != 1 || clients[0].Name != "laptop" {
+		t.Fatalf("clients after update = %+v, want laptop", clients)
+	}
+
+	if err := p.RemoveClient(ctx, clients[0]); err != nil {
+		t.Fatalf("RemoveClient: %v", err)
+	}
+	if clients, _ = p.ListClients(ctx); len(clients) != 0 {
+		t.Fatalf("clients after remove = %+v, want none", clients)
+	}
+
+	// Updating a client removed in the meantime reports it as missing
+	err = p.UpdateClient(ctx, current, desired)
+	if err == nil || !strings.Contains(err.Error(), ErrClientNotFound.Error()) {
+		t.Errorf("UpdateClient of a removed client = %v, want %v", err, ErrClientNotFound)
+	}
+}
+
+func TestPiHoleGroupMapping(t *testing.T) {
+	fake, server := newFakePiHole(t)
+	p := newTestPiHole(t, server,
+		groupRule(t, "lan", "192.168.1.0/24"),
+		groupRule(t, "iot", "192.168.2.0/24"))
+	ctx := context.Background()
+
+	// A group assigned by hand in Pi-hole is kept across updates
+	fake.groups = append(fake.groups, piholeGroup{ID: 1, Name: "kids", Enabled: true})
+
+	macs := []string{"aa:bb:cc:dd:ee:01", "aa:bb:cc:dd:ee:02", "aa:bb:cc:dd:ee:03"}
+	if _, err := p.ListClients(ctx); err != nil {
+		t.Fatalf("ListClients: %v", err)
+	}
+	for i, mac := range macs {
+		client := StoreClient{Name: fmt.Sprintf("host%d", i), MAC: mac, IDs: []string{fmt.Sprintf("192.168.1.%d", 10+i)}}
+		if err := p.AddClient(ctx, client); err != nil {
+			t.Fatalf("AddClient: %v", err)
+		}
+	}
+
+	// The missing group is created once and the groups are read once more
+	// after that, not for every client
+	if got := fake.count("POST /api/groups"); got != 1 {
+		t.Errorf("groups created = %d, want 1", got)
+	}
+	if got := fake.count("GET /api/groups"); got != 2 {
+		t.Errorf("group listings while adding = %d, want 2", got)
+	}
+	for _, mac := range macs {
+		if got := fake.clientGroups(mac); strings.Join(got, ",") != "Default,lan" {
+			t.Errorf("groups of %s = %v, want [Default lan]", mac, got)
+		}
+	}
+
+	fake.mu.Lock()
+	client := fake.clients[macs[0]]
+	client.Groups = append(client.Groups, 1)
+	fake.clients[macs[0]] = client
+	fake.mu.Unlock()
+
+	// The next sync reads the groups again, once for every diff
+	clients, err := p.ListClients(ctx)
+	if err != nil {
+		t.Fatalf("ListClients: %v", err)
+	}
+	before := fake.count("GET /api/groups")
+	var moved StoreClient
+	for _, current := range clients {
+		desired := StoreClient{Name: current.Name, MAC: current.MAC, IDs: []string{"192.168.1.50"}}
+		if current.MAC == macs[0] {
+			desired.IDs = []string{"192.168.2.50"}
+			moved = current
+		}
+		differs, _ := p.Differs(ctx, current, desired)
+		if differs != (current.MAC == macs[0]) {
+			t.Errorf("Differs(%s) = %v", current.MAC, differs)
+		}
+	}
+	if got := fake.count("GET /api/groups") - before; got != 1 {
+		t.Errorf("group listings while diffing = %d, want 1", got)
+	}
+
+	// Moving to another subnet swaps the managed group and keeps the
+	// manual one
+	if err := p.UpdateClient(ctx, moved, StoreClient{Name: moved.Name, MAC: moved.MAC, IDs: []string{"192.168.2.50"}}); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	if got := fake.clientGroups(macs[0]); strings.Join(got, ",") != "Default,iot,kids" {
+		t.Errorf("groups after moving = %v, want [Default iot kids]", got)
+	}
+}
+
+func TestPiHoleRecords(t *testing.T) {
+	fake, server := newFakePiHole(t)
+	p := newTestPiHole(t, server)
+	ctx := context.Background()
+
+	fake.hosts = []string{"192.168.1.1 router router.lan"}
+	if err := p.AddRecord(ctx, "laptop.lan", "192.168.1.10"); err != nil {
+		t.Fatalf("AddRecord: %v", err)
+	}
+
+	records, err := p.ListRecords(ctx)
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	want := []DNSRecord{
+		{Domain: "router", Answer: "192.168.1.1"},
+		{Domain: "router.lan", Answer: "192.168.1.1"},
+		{Domain: "laptop.lan", Answer: "192.168.1.10"},
+	}
+	if fmt.Sprint(records) != fmt.Sprint(want) {
+		t.Errorf("records = %v, want %v", records, want)
+	}
+
+	if err := p.RemoveRecord(ctx, "laptop.lan", "192.168.1.10"); err != nil {
+		t.Fatalf("RemoveRecord: %v", err)
+	}
+	if records, _ = p.ListRecords(ctx); len(records) != 2 {
+		t.Errorf("records after remove = %v, want the router only", records)
+	}
+}
@@ -0,0 +1,411 @@
+// pkg/profiles.go
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gmichels/adguard-client-go"
+)
+
+// LeaseMatcher selects leases by subnet, interface or MAC address pattern.
+// A lease matches when it satisfies any of the configured rules.
+type LeaseMatcher struct {
+	CIDRs      []*net.IPNet
+	Interfaces []string
+	MACs       []string // Glob patterns, e.g. "00:11:22:*"
+}
+
+// Empty reports whether the matcher has no rules configured
+func (m LeaseMatcher) Empty() bool {
+	return len(m.CIDRs) == 0 && len(m.Interfaces) == 0 && len(m.MACs) == 0
+}
+
+// Matches reports whether the lease satisfies any of the matcher's rules.
+// Interface rules are looked up in the interfaces snapshot.
+func (m LeaseMatcher) Matches(lease ISCDHCPLease, interfaces Interfaces) bool {
+	ip := net.ParseIP(lease.IP)
+
+	if ip != nil {
+		for _, cidr := range m.CIDRs {
+			if cidr.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	mac := strings.ToLower(lease.MAC)
+	for _, pattern := range m.MACs {
+		if ok, _ := path.Match(strings.ToLower(pattern), mac); ok {
+			return true
+		}
+	}
+
+	if ip != nil && len(m.Interfaces) > 0 {
+		iface := interfaces.Name(ip)
+		for _, name := range m.Interfaces {
+			if name == iface {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Interfaces is a snapshot of the networks configured on the local
+// interfaces. A sync takes one for all of its leases, so interface rules
+// don't query the system for every lease and rule.
+type Interfaces struct {
+	nets []interfaceNet
+}
+
+// interfaceNet is a network configured on a local interface
+type interfaceNet struct {
+	name    string
+	network *net.IPNet
+}
+
+// LocalInterfaces takes a snapshot of the local interfaces' networks.
+// Interfaces whose addresses can't be read are left out.
+func LocalInterfaces() Interfaces {
+	var snapshot Interfaces
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return snapshot
+	}
+
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				snapshot.nets = append(snapshot.nets, interfaceNet{name: iface.Name, network: ipNet})
+			}
+		}
+	}
+	return snapshot
+}
+
+// Name returns the name of the interface whose network contains ip, or an
+// empty string if there is none
+func (i Interfaces) Name(ip net.IP) string {
+	for _, n := range i.nets {
+		if n.network.Contains(ip) {
+			return n.name
+		}
+	}
+	return ""
+}
+
+type interfacesKey struct{}
+
+// withInterfaces returns a context carrying the interfaces snapshot of a
+// sync, for stores that match interface rules themselves
+func withInterfaces(ctx context.Context, interfaces Interfaces) context.Context {
+	return context.WithValue(ctx, interfacesKey{}, interfaces)
+}
+
+// interfacesFrom returns the interfaces snapshot carried by the context,
+// or takes a new one outside of a sync
+func interfacesFrom(ctx context.Context) Interfaces {
+	if interfaces, ok := ctx.Value(interfacesKey{}).(Interfaces); ok {
+		return interfaces
+	}
+	return LocalInterfaces()
+}
+
+// ClientProfile describes the AdGuard client settings applied to leases
+// matching its rules. Unset settings fall back to the AddClient defaults.
+type ClientProfile struct {
+	Name  string
+	Match LeaseMatcher
+	Tag   string
+
+	FilteringEnabled    *bool
+	ParentalEnabled     *bool
+	SafebrowsingEnabled *bool
+	SafeSearchEnabled   *bool
+	IgnoreQuerylog      *bool
+	IgnoreStatistics    *bool
+	BlockedServices     []string
+	Upstreams           []string
+}
+
+// overridesFiltering reports whether the profile sets any of the settings
+// that AdGuard only honours when use_global_settings is disabled
+func (p *ClientProfile) overridesFiltering() bool {
+	return p.FilteringEnabled != nil || p.ParentalEnabled != nil ||
+		p.SafebrowsingEnabled != nil || p.SafeSearchEnabled != nil
+}
+
+// Apply writes the profile's settings and tag onto an AdGuard client
+func (p *ClientProfile) Apply(client *adguard.Client) {
+	if p.overridesFiltering() {
+		// Switching away from global settings must not silently disable filtering
+		if client.UseGlobalSettings && p.FilteringEnabled == nil {
+			client.FilteringEnabled = true
+		}
+		client.UseGlobalSettings = false
+		if p.FilteringEnabled != nil {
+			client.FilteringEnabled = *p.FilteringEnabled
+		}
+		if p.ParentalEnabled != nil {
+			client.ParentalEnabled = *p.ParentalEnabled
+		}
+		if p.SafebrowsingEnabled != nil {
+			client.SafebrowsingEnabled = *p.SafebrowsingEnabled
+		}
+		if p.SafeSearchEnabled != nil {
+			client.SafeSearch = adguard.SafeSearchConfig{
+				Enabled:    *p.SafeSearchEnabled,
+				Bing:       *p.SafeSearchEnabled,
+				Duckduckgo: *p.SafeSearchEnabled,
+				Ecosia:     *p.SafeSearchEnabled,
+				Google:     *p.SafeSearchEnabled,
+				Pixabay:    *p.SafeSearchEnabled,
+				Yandex:     *p.SafeSearchEnabled,
+				Youtube:    *p.SafeSearchEnabled,
+			}
+		}
+	}
+
+	if p.IgnoreQuerylog != nil {
+		client.IgnoreQuerylog = *p.IgnoreQuerylog
+	}
+	if p.IgnoreStatistics != nil {
+		client.IgnoreStatistics = *p.IgnoreStatistics
+	}
+
+	if len(p.BlockedServices) > 0 {
+		client.UseGlobalBlockedServices = false
+		client.BlockedServices = append([]string(nil), p.BlockedServices...)
+	}
+
+	if len(p.Upstreams) > 0 {
+		client.Upstreams = append([]string(nil), p.Upstreams...)
+	}
+
+	if p.Tag != "" && !containsString(client.Tags, p.Tag) {
+		client.Tags = append(client.Tags, p.Tag)
+	}
+}
+
+// Differs reports whether applying the profile would change the client
+func (p *ClientProfile) Differs(client adguard.Client) bool {
+	updated := client
+	updated.Tags = append([]string(nil), client.Tags...)
+	updated.BlockedServices = append([]string(nil), client.BlockedServices...)
+	updated.Upstreams = append([]string(nil), client.Upstreams...)
+	p.Apply(&updated)
+
+	return updated.UseGlobalSettings != client.UseGlobalSettings ||
+		updated.FilteringEnabled != client.FilteringEnabled ||
+		updated.ParentalEnabled != client.ParentalEnabled ||
+		updated.SafebrowsingEnabled != client.SafebrowsingEnabled ||
+		updated.SafeSearch != client.SafeSearch ||
+		updated.IgnoreQuerylog != client.IgnoreQuerylog ||
+		updated.IgnoreStatistics != client.IgnoreStatistics ||
+		updated.UseGlobalBlockedServices != client.UseGlobalBlockedServices ||
+		!sameStrings(updated.BlockedServices, client.BlockedServices) ||
+		!sameStrings(updated.Upstreams, client.Upstreams) ||
+		!sameStrings(updated.Tags, client.Tags)
+}
+
+// ProfileSet is an ordered list of profiles; the first matching profile wins
+type ProfileSet []ClientProfile
+
+// Match returns the first profile whose rules match the lease, or nil
+func (ps ProfileSet) Match(lease ISCDHCPLease, interfaces Interfaces) *ClientProfile {
+	for i := range ps {
+		if ps[i].Match.Matches(lease, interfaces) {
+			return &ps[i]
+		}
+	}
+	return nil
+}
+
+// ParseProfile parses a profile definition of the form
+//
+//	name:key=value,key=value
+//
+// Match keys are cidr, interface and mac. Setting keys are tag, filtering,
+// parental, safebrowsing, safesearch, ignore_querylog, ignore_statistics,
+// blocked_services and upstreams. Keys may be repeated and list values may
+// be separated with "|", e.g.
+//
+//	kids:cidr=192.168.20.0/24,parental=true,safesearch=true,tag=user_child
+func ParseProfile(spec string) (ClientProfile, error) {
+	def, err := ParseDefinition("profile", spec)
+	if err != nil {
+		return ClientProfile{}, err
+	}
+	return ProfileFromDefinition(def)
+}
+
+// ProfileFromDefinition builds a profile from its settings, using the keys
+// described at ParseProfile
+func ProfileFromDefinition(def Definition) (ClientProfile, error) {
+	var profile ClientProfile
+	name := def.Name
+	profile.Name = name
+
+	for _, setting := range def.Settings {
+		if err := profile.set(setting.Key, setting.Value); err != nil {
+			return profile, fmt.Errorf("profile %s: %w", name, err)
+		}
+	}
+
+	if profile.Match.Empty() {
+		return profile, fmt.Errorf("profile %s: at least one cidr, interface or mac rule is required", name)
+	}
+
+	return profile, nil
+}
+
+// ParseProfiles parses a list of profile definitions
+func ParseProfiles(specs []string) (ProfileSet, error) {
+	defs, err := ParseDefinitions("profile", specs)
+	if err != nil {
+		return nil, err
+	}
+	return ProfilesFromDefinitions(defs)
+}
+
+// ProfilesFromDefinitions builds the profiles in order of precedence
+func ProfilesFromDefinitions(defs []Definition) (ProfileSet, error) {
+	profiles := make(ProfileSet, 0, len(defs))
+	for _, def := range defs {
+		profile, err := ProfileFromDefinition(def)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// set applies a single key=value pair to the profile
+func (p *ClientProfile) set(key, value string) error {
+	switch key {
+	case "cidr", "interface", "mac":
+		return p.Match.add(key, value)
+	case "tag":
+		if !IsValidClientTag(value) {
+			return fmt.Errorf("unknown AdGuard client tag %q", value)
+		}
+		p.Tag = value
+	case "filtering":
+		return parseBoolSetting(key, value, &p.FilteringEnabled)
+	case "parental":
+		return parseBoolSetting(key, value, &p.ParentalEnabled)
+	case "safebrowsing":
+		return parseBoolSetting(key, value, &p.SafebrowsingEnabled)
+	case "safesearch":
+		return parseBoolSetting(key, value, &p.SafeSearchEnabled)
+	case "ignore_querylog":
+		return parseBoolSetting(key, value, &p.IgnoreQuerylog)
+	case "ignore_statistics":
+		return parseBoolSetting(key, value, &p.IgnoreStatistics)
+	case "blocked_services":
+		p.BlockedServices = append(p.BlockedServices, splitList(value)...)
+	case "upstreams":
+		p.Upstreams = append(p.Upstreams, splitList(value)...)
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return nil
+}
+
+// add appends a match rule of the given kind
+func (m *LeaseMatcher) add(kind, value string) error {
+	for _, item := range splitList(value) {
+		switch kind {
+		case "cidr":
+			_, ipNet, err := net.ParseCIDR(item)
+			if err != nil {
+				return fmt.Errorf("invalid cidr %q: %w", item, err)
+			}
+			m.CIDRs = append(m.CIDRs, ipNet)
+		case "interface":
+			m.Interfaces = append(m.Interfaces, item)
+		case "mac":
+			if _, err := path.Match(item, ""); err != nil {
+				return fmt.Errorf("invalid mac pattern %q: %w", item, err)
+			}
+			m.MACs = append(m.MACs, item)
+		default:
+			return fmt.Errorf("unknown match rule %q", kind)
+		}
+	}
+	return nil
+}
+
+// parseBoolSetting parses value into a newly allocated bool stored in dst
+func parseBoolSetting(key, value string, dst **bool) error {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %q", key, value)
+	}
+	*dst = &b
+	return nil
+}
+
+// splitList splits a "|" separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sameStrings reports whether a and b contain the same strings, ignoring order
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, s := range a {
+		counts[s]++
+	}
+	for _, s := range b {
+		counts[s]--
+		if counts[s] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validClientTags lists the client tags accepted by AdGuard Home
+var validClientTags = []string{
+	"device_audio", "device_camera", "device_car", "device_game", "device_laptop",
+	"device_nas", "device_other", "device_pc", "device_phone", "device_printer",
+	"device_securityalarm", "device_tablet", "device_tv",
+	"os_android", "os_ios", "os_linux", "os_macos", "os_other", "os_windows",
+	"user_admin", "user_child", "user_regular",
+}
+
+// IsValidClientTag reports whether tag is one of AdGuard Home's client tags
+func IsValidClientTag(tag string) bool {
+	return containsString(validClientTags, tag)
+}
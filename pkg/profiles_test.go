@@ -0,0 +1,37 @@
+// pkg/profiles_test.go
+package pkg
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLeaseMatcherInterfaces(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	_, guest, _ := net.ParseCIDR("10.0.20.0/24")
+	interfaces := Interfaces{nets: []interfaceNet{
+		{name: "igb1", network: lan},
+		{name: "igb1_vlan20", network: guest},
+	}}
+
+	matcher := LeaseMatcher{Interfaces: []string{"igb1_vlan20"}}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.0.20.15", true},
+		{"192.168.1.15", false},
+		{"172.16.0.1", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := matcher.Matches(ISCDHCPLease{IP: tt.ip}, interfaces); got != tt.want {
+			t.Errorf("Matches(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	// Without a snapshot no lease is on any interface
+	if matcher.Matches(ISCDHCPLease{IP: "10.0.20.15"}, Interfaces{}) {
+		t.Error("Matches with an empty snapshot = true, want false")
+	}
+}
@@ -0,0 +1,210 @@
+// pkg/reload.go
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// restartFields are the settings a running service cannot change
+var restartFields = map[string]bool{
+	"Debug":             true,
+	"NDPUpdateInterval": true,
+	"ShutdownTimeout":   true,
+}
+
+// LevelSetter is implemented by loggers whose level can change at runtime
+type LevelSetter interface {
+	SetLevel(level LogLevel)
+}
+
+// Reload applies cfg to the running service. The new targets, lease readers
+// and outputs are built first; if that fails the running configuration is
+// kept and the error returned. Otherwise they replace the current ones once
+// a sync in progress has finished, and a full sync is triggered.
+func (s *SyncService) Reload(cfg Config) error {
+	select {
+	case <-s.stopping:
+		return fmt.Errorf("service is stopping")
+	default:
+	}
+
+	// Build the new components aside, reusing the loaded ownership state
+	s.mu.RLock()
+	current := s.cfg
+	next := &SyncService{logger: s.logger, debug: s.debug, state: s.state}
+	s.mu.RUnlock()
+
+	if err := next.configure(cfg); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if _, _, err := next.resolveWatches(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	// A more verbose level applies before the changes are logged, so they
+	// show up in either direction
+	setter, _ := s.logger.(LevelSetter)
+	if setter != nil && cfg.LogConfig.Level > current.LogConfig.Level {
+		setter.SetLevel(cfg.LogConfig.Level)
+	}
+
+	changes, restart := configDiff(current, cfg)
+	if len(changes) == 0 && len(restart) == 0 {
+		s.logger.Info("Configuration reloaded, nothing changed")
+		s.closeTargets(next.targets)
+		return nil
+	}
+	for _, change := range changes {
+		s.logger.Info("Configuration changed", "change", change)
+	}
+	for _, change := range restart {
+		s.logger.Warn("Configuration changed, takes effect after a restart", "change", change)
+	}
+
+	// Swap the components once a running sync has finished
+	s.mu.Lock()
+	previous := s.targets
+	s.cfg = cfg
+	s.targets = next.targets
+	s.leases = next.leases
+	s.dryRun = next.dryRun
+	s.preserveDeletedHosts = next.preserveDeletedHosts
+	s.profiles = next.profiles
+	s.reapplyProfiles = next.reapplyProfiles
+	s.tags = next.tags
+	s.workers = next.workers
+	s.state = next.state
+	s.staticLeases = next.staticLeases
+	s.dnsOutputs = next.dnsOutputs
+	s.mu.Unlock()
+
+	// The replaced stores are no longer used; end their sessions
+	s.closeTargets(previous)
+
+	// Drop the series of lease sources and targets that may be gone; the
+	// next sync fills them in again
+	metrics.leases.reset()
+	metrics.managedClients.reset()
+
+	if setter != nil {
+		setter.SetLevel(cfg.LogConfig.Level)
+	}
+	s.scheduler.SetInterval(cfg.ReconcileInterval)
+
+	if err := s.updateWatches(); err != nil {
+		s.logger.Error("Error updating watched files", "error", err)
+	}
+
+	s.reloadMu.Lock()
+	select {
+	case <-s.stopping:
+	default:
+		if s.pollCancel != nil {
+			s.startPollers()
+		}
+	}
+	s.reloadMu.Unlock()
+
+	// Only a logging change leaves the synced state untouched
+	if len(changes) == 1 && changes[0] == logLevelChange(current, cfg) {
+		return nil
+	}
+	s.scheduler.Trigger(ReasonReload)
+	return nil
+}
+
+// configDiff describes the settings that differ between old and cfg,
+// separating those that need a restart. Passwords are never included.
+func configDiff(old, cfg Config) (changes, restart []string) {
+	oldValue := reflect.ValueOf(old)
+	newValue := reflect.ValueOf(cfg)
+	configType := oldValue.Type()
+
+	for i := 0; i < configType.NumField(); i++ {
+		name := configType.Field(i).Name
+		a := oldValue.Field(i).Interface()
+		b := newValue.Field(i).Interface()
+
+		switch name {
+		case "Logger":
+			continue
+		case "LogConfig":
+			if change := logLevelChange(old, cfg); change != "" {
+				changes = append(changes, change)
+			}
+			oldLog, newLog := old.LogConfig, cfg.LogConfig
+			oldLog.Level, newLog.Level = 0, 0
+			if oldLog != newLog {
+				restart = append(restart, "log file settings")
+			}
+			continue
+		case "Targets":
+			changes = append(changes, targetChanges(old.Targets, cfg.Targets)...)
+			continue
+		}
+
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+		change := describeChange(name, a, b)
+		if restartFields[name] {
+			restart = append(restart, change)
+		} else {
+			changes = append(changes, change)
+		}
+	}
+	return changes, restart
+}
+
+// logLevelChange describes a change of the log level, or returns ""
+func logLevelChange(old, cfg Config) string {
+	if old.LogConfig.Level == cfg.LogConfig.Level {
+		return ""
+	}
+	return fmt.Sprintf("log level %s -> %s", old.LogConfig.Level, cfg.LogConfig.Level)
+}
+
+// describeChange formats a changed setting, showing simple values only
+func describeChange(name string, a, b interface{}) string {
+	if name == "Password" {
+		return name + " changed"
+	}
+	switch a.(type) {
+	case string, LeaseFormat:
+		return fmt.Sprintf("%s %q -> %q", name, a, b)
+	case bool, int, float64, time.Duration:
+		return fmt.Sprintf("%s %v -> %v", name, a, b)
+	}
+	return name + " changed"
+}
+
+// targetChanges describes the sync targets added, removed or changed
+func targetChanges(old, updated []TargetConfig) []string {
+	previous := make(map[string]TargetConfig, len(old))
+	for _, target := range old {
+		previous[target.Name] = target
+	}
+
+	var changes []string
+	seen := make(map[string]bool, len(updated))
+	for _, target := range updated {
+		seen[target.Name] = true
+		before, exists := previous[target.Name]
+		switch {
+		case !exists:
+			changes = append(changes, fmt.Sprintf("target %s added", target.Name))
+		case !reflect.DeepEqual(before, target):
+			changes = append(changes, fmt.Sprintf("target %s changed", target.Name))
+		}
+	}
+	for name := range previous {
+		if !seen[name] {
+			changes = append(changes, fmt.Sprintf("target %s removed", name))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
@@ -0,0 +1,237 @@
+// pkg/rewrites.go
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// rewriteSection is the ownership store section for DNS rewrites
+const rewriteSection = "rewrites"
+
+// DNSRecords maps fully qualified host names to their addresses
+type DNSRecords map[string][]string
+
+// Names returns the record names in sorted order
+func (r DNSRecords) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dnsLabel converts a DHCP hostname into a valid lower-case DNS label
+func dnsLabel(hostname string) string {
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	// Drop any domain the client sent along with its name
+	hostname, _, _ = strings.Cut(hostname, ".")
+
+	label := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, hostname)
+	return strings.Trim(label, "-")
+}
+
+// isRoutableIP reports whether ip is worth publishing in DNS, i.e. it
+// parses and is not a link-local address
+func isRoutableIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && !parsed.IsLinkLocalUnicast() && !parsed.IsLinkLocalMulticast()
+}
+
+// buildDNSRecords creates hostname.domain records from the active leases
+// and their NDP IPv6 addresses
+func (s *SyncService) buildDNSRecords(leases map[string]ISCDHCPLease, domain string) DNSRecords {
+	records := make(DNSRecords)
+	domain = strings.Trim(strings.ToLower(domain), ".")
+
+	for mac, lease := range leases {
+		if !lease.IsActive {
+			continue
+		}
+
+		label := dnsLabel(lease.Hostname)
+		if label == "" {
+			continue
+		}
+
+		name := label
+		if domain != "" {
+			name = label + "." + domain
+		}
+
+		addresses := records[name]
+		if isRoutableIP(lease.IP) && !containsString(addresses, lease.IP) {
+			addresses = append(addresses, lease.IP)
+		}
+
+		ipv6, _ := s.ndpWatcher.GetIP6forMAC(mac)
+		for _, ip := range ipv6 {
+			if isRoutableIP(ip) && !containsString(addresses, ip) {
+				addresses = append(addresses, ip)
+			}
+		}
+
+		if len(addresses) > 0 {
+			sort.Strings(addresses)
+			records[name] = addresses
+		}
+	}
+
+	return records
+}
+
+// hasDNSRecords reports whether any of the lower-case MACs has an active
+// lease with a hostname, whose addresses buildDNSRecords publishes
+func hasDNSRecords(leases map[string]ISCDHCPLease, macs map[string]bool) bool {
+	for mac, lease := range leases {
+		if macs[strings.ToLower(mac)] && lease.IsActive && dnsLabel(lease.Hostname) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// DNSRecord is a single name to address entry in a RecordBackend
+type DNSRecord struct {
+	Domain string
+	Answer string
+}
+
+// RecordBackend is a DNS server holding local name to address records,
+// such as AdGuard Home rewrites or Pi-hole local DNS hosts
+type RecordBackend interface {
+	Name() string
+	ListRecords(ctx context.Context) ([]DNSRecord, error)
+	AddRecord(ctx context.Context, domain, answer string) error
+	RemoveRecord(ctx context.Context, domain, answer string) error
+}
+
+// DNSRewriter manages the local DNS records of a RecordBackend for lease
+// hostnames. Only entries recorded in the ownership store under its
+// section are ever updated or removed.
+type DNSRewriter struct {
+	backend              RecordBackend
+	section              string
+	domain               string
+	owned                *OwnershipStore
+	logger               Logger
+	dryRun               bool
+	debug                bool
+	preserveDeletedHosts bool
+}
+
+// newDNSRewriter creates a DNSRewriter publishing records under domain,
+// tracking its entries in the given ownership section
+func (s *SyncService) newDNSRewriter(backend RecordBackend, section, domain string) *DNSRewriter {
+	return &DNSRewriter{
+		backend:              backend,
+		section:              section,
+		domain:               domain,
+		owned:                s.state,
+		logger:               s.logger,
+		dryRun:               s.dryRun,
+		debug:                s.debug,
+		preserveDeletedHosts: s.preserveDeletedHosts,
+	}
+}
+
+// rewriteKey identifies a single rewrite entry in the ownership store
+func rewriteKey(domain, answer string) string {
+	return domain + " " + answer
+}
+
+// Sync reconciles the owned record entries in the backend with records
+func (r *DNSRewriter) Sync(ctx context.Context, records DNSRecords) error {
+	current, err := r.backend.ListRecords(ctx)
+	if err != nil {
+		return fmt.Errorf("getting %s DNS records: %w", r.backend.Name(), err)
+	}
+
+	// Index existing entries, separating ours from manually created ones
+	existing := make(map[string]bool)
+	manualDomains := make(map[string]bool)
+	for _, entry := range current {
+		key := rewriteKey(entry.Domain, entry.Answer)
+		existing[key] = true
+		if !r.owned.Owns(r.section, key) {
+			manualDomains[entry.Domain] = true
+		}
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range records.Names() {
+		if manualDomains[name] {
+			if r.debug {
+				r.logger.Info("Skipping DNS record: name has manually created records", "target", r.backend.Name(), "hostname", name)
+			}
+			continue
+		}
+
+		for _, answer := range records[name] {
+			key := rewriteKey(name, answer)
+			wanted[key] = true
+			if existing[key] {
+				continue
+			}
+
+			logger := r.logger.With("target", r.backend.Name(), "hostname", name, "answer", answer, "action", "add")
+			if r.dryRun {
+				logger.Info("DRY-RUN: Adding DNS record")
+				continue
+			}
+
+			logger.Info("Adding DNS record")
+			if err := r.backend.AddRecord(ctx, name, answer); err != nil {
+				logger.Error("Error adding DNS record", "error", err)
+				continue
+			}
+			r.owned.Add(r.section, key)
+		}
+	}
+
+	for _, key := range r.owned.Keys(r.section) {
+		if wanted[key] {
+			continue
+		}
+
+		domain, answer, _ := strings.Cut(key, " ")
+		if !existing[key] {
+			// Removed by hand; stop tracking it
+			r.owned.Remove(r.section, key)
+			continue
+		}
+		if r.preserveDeletedHosts && len(records[domain]) == 0 {
+			if r.debug {
+				r.logger.Info("Keeping DNS record, preserveDeletedHosts is enabled", "target", r.backend.Name(), "hostname", domain, "answer", answer)
+			}
+			continue
+		}
+
+		logger := r.logger.With("target", r.backend.Name(), "hostname", domain, "answer", answer, "action", "remove")
+		if r.dryRun {
+			logger.Info("DRY-RUN: Removing stale DNS record")
+			continue
+		}
+
+		logger.Info("Removing stale DNS record")
+		if err := r.backend.RemoveRecord(ctx, domain, answer); err != nil {
+			logger.Error("Error removing DNS record", "error", err)
+			continue
+		}
+		r.owned.Remove(r.section, key)
+	}
+
+	if r.dryRun {
+		return nil
+	}
+	return r.owned.Save()
+}
@@ -0,0 +1,425 @@
+// pkg/scheduler.go
+package pkg
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncReason describes what triggered a sync
+type SyncReason string
+
+const (
+	ReasonStartup      SyncReason = "startup"
+	ReasonLeaseFile    SyncReason = "lease file"
+	ReasonLeaseSource  SyncReason = "lease source"
+	ReasonStaticLeases SyncReason = "static mappings"
+	ReasonNDP          SyncReason = "NDP table"
+	ReasonCatchUp      SyncReason = "catch-up"
+	ReasonPeriodic     SyncReason = "periodic"
+	ReasonReload       SyncReason = "configuration reload"
+	ReasonManual       SyncReason = "manual request"
+)
+
+const (
+	// DefaultReconcileInterval is how often a full sync runs without any trigger
+	DefaultReconcileInterval = 10 * time.Minute
+
+	// syncDebounce is how long triggers are collected before a sync starts
+	syncDebounce = 2 * time.Second
+
+	// reconcileJitter spreads the periodic sync by up to this share of the interval
+	reconcileJitter = 0.1
+)
+
+// fullSyncReasons are the triggers that sync every lease rather than only
+// the changed ones
+var fullSyncReasons = map[SyncReason]bool{
+	ReasonStartup:      true,
+	ReasonStaticLeases: true,
+	ReasonCatchUp:      true,
+	ReasonPeriodic:     true,
+	ReasonReload:       true,
+	ReasonManual:       true,
+}
+
+// syncScheduler owns all sync execution. Triggers from the watchers are
+// debounced together and coalesced, so at most one sync runs at a time and
+// at most one more is pending while it does. The change sets of coalesced
+// triggers are merged; a nil change set asks for a full sync.
+type syncScheduler struct {
+	sync     func(ctx context.Context, changes *ChangeSet) error
+	logger   Logger
+	debug    bool
+	debounce time.Duration
+
+	mu              sync.Mutex
+	interval        time.Duration // Zero disables the periodic sync
+	intervalChanged bool          // The periodic timer needs re-arming
+	pending         map[SyncReason]bool
+	changes         ChangeSet
+	catchUpDelay    time.Duration // Requested catch-up delay, zero when none
+	history         syncHistory
+
+	wake    chan struct{}
+	jobs    chan schedulerJob
+	stopped chan struct{} // Closed when Run returns
+}
+
+// schedulerJob is work run by the scheduler loop between syncs
+type schedulerJob struct {
+	fn   func(ctx context.Context) error
+	sync bool // fn runs a sync, so the timers restart after it
+	done chan struct{}
+}
+
+// syncHistory records the syncs the scheduler has run
+type syncHistory struct {
+	running     *SyncRun // Sync in progress, nil when idle
+	last        *SyncRun
+	lastSuccess time.Time
+	lastError   string
+	lastErrorAt time.Time
+	runs        int
+	failures    int
+	lastID      uint64 // ID of the latest sync, tagging its log messages
+}
+
+// newSyncScheduler creates a scheduler running sync
+func newSyncScheduler(sync func(ctx context.Context, changes *ChangeSet) error, interval time.Duration, logger Logger, debug bool) *syncScheduler {
+	return &syncScheduler{
+		sync:     sync,
+		logger:   logger,
+		debug:    debug,
+		debounce: syncDebounce,
+		interval: interval,
+		pending:  make(map[SyncReason]bool),
+		wake:     make(chan struct{}, 1),
+		jobs:     make(chan schedulerJob),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Trigger requests a sync for the reason
+func (sc *syncScheduler) Trigger(reason SyncReason) {
+	sc.TriggerChanges(reason, ChangeSet{})
+}
+
+// TriggerChanges requests a sync for the reason, covering at least the MACs
+// in changes
+func (sc *syncScheduler) TriggerChanges(reason SyncReason, changes ChangeSet) {
+	sc.mu.Lock()
+	sc.pending[reason] = true
+	sc.changes = sc.changes.Merge(changes)
+	sc.mu.Unlock()
+
+	if sc.debug {
+		sc.logger.Info("Sync requested", append([]any{"reason", reason}, changes.attrs()...)...)
+	}
+	sc.signal()
+}
+
+// CatchUp requests a sync after delay, replacing an earlier request
+func (sc *syncScheduler) CatchUp(delay time.Duration) {
+	if delay <= 0 {
+		delay = time.Millisecond
+	}
+	sc.mu.Lock()
+	sc.catchUpDelay = delay
+	sc.mu.Unlock()
+	sc.signal()
+}
+
+// SetInterval changes the periodic sync interval, re-arming its timer
+func (sc *syncScheduler) SetInterval(interval time.Duration) {
+	sc.mu.Lock()
+	if sc.interval == interval {
+		sc.mu.Unlock()
+		return
+	}
+	sc.interval = interval
+	sc.intervalChanged = true
+	sc.mu.Unlock()
+	sc.signal()
+}
+
+// SyncNow runs a full sync for the reason as soon as a running sync has
+// finished, together with any pending triggers, and returns its outcome. The
+// error reports a sync that could not be started; a failed sync is recorded
+// in the outcome.
+func (sc *syncScheduler) SyncNow(ctx context.Context, reason SyncReason) (SyncRun, error) {
+	var run SyncRun
+	err := sc.do(ctx, true, func(ctx context.Context) error {
+		sc.mu.Lock()
+		sc.pending[reason] = true
+		sc.mu.Unlock()
+
+		err := sc.runPending(ctx)
+
+		sc.mu.Lock()
+		run = *sc.history.last
+		sc.mu.Unlock()
+		return err
+	})
+	return run, err
+}
+
+// Exclusive runs fn between syncs, so it never overlaps one
+func (sc *syncScheduler) Exclusive(ctx context.Context, fn func(ctx context.Context) error) error {
+	var fnErr error
+	err := sc.do(ctx, false, func(ctx context.Context) error {
+		fnErr = fn(ctx)
+		return fnErr
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// do hands a job to the scheduler loop and waits until it has run. It fails
+// if ctx is cancelled before the loop picks the job up, or the scheduler
+// isn't running.
+func (sc *syncScheduler) do(ctx context.Context, sync bool, fn func(ctx context.Context) error) error {
+	job := schedulerJob{fn: fn, sync: sync, done: make(chan struct{})}
+	select {
+	case sc.jobs <- job:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-sc.stopped:
+		return ErrSchedulerStopped
+	}
+	<-job.done
+	return nil
+}
+
+// ErrSchedulerStopped is returned for work requested after the service
+// has shut down
+var ErrSchedulerStopped = errors.New("service is shutting down")
+
+// signal wakes the scheduler loop without blocking
+func (sc *syncScheduler) signal() {
+	select {
+	case sc.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run executes syncs until stop is closed, letting a sync in progress
+// finish, or ctx is cancelled, which also aborts it
+func (sc *syncScheduler) Run(ctx context.Context, stop <-chan struct{}) {
+	defer close(sc.stopped)
+
+	debounce := time.NewTimer(sc.debounce)
+	debounce.Stop()
+	catchUp := time.NewTimer(time.Hour)
+	catchUp.Stop()
+	periodic := time.NewTimer(time.Hour)
+	sc.resetPeriodic(periodic)
+
+	// after resets the timers once a sync has run; a successful sync makes
+	// a scheduled catch-up unnecessary
+	after := func(err error) {
+		if err == nil {
+			catchUp.Stop()
+		}
+		sc.resetPeriodic(periodic)
+	}
+
+	// Run the startup sync without waiting for the debounce
+	if sc.hasPending() {
+		after(sc.runPending(ctx))
+	}
+
+	for {
+		select {
+		case <-stop:
+			debounce.Stop()
+			catchUp.Stop()
+			periodic.Stop()
+			return
+
+		case <-ctx.Done():
+			debounce.Stop()
+			catchUp.Stop()
+			periodic.Stop()
+			return
+
+		case <-sc.wake:
+			sc.mu.Lock()
+			delay := sc.catchUpDelay
+			sc.catchUpDelay = 0
+			pending := len(sc.pending) > 0
+			rearm := sc.intervalChanged
+			sc.intervalChanged = false
+			sc.mu.Unlock()
+
+			if rearm {
+				sc.resetPeriodic(periodic)
+			}
+			if delay > 0 {
+				sc.logger.Info("Scheduling catch-up sync", "delay", delay.Round(time.Second))
+				resetTimer(catchUp, delay)
+			}
+			if pending {
+				resetTimer(debounce, sc.debounce)
+			}
+
+		case <-debounce.C:
+			// A requested sync may have taken the pending triggers already
+			if sc.hasPending() {
+				after(sc.runPending(ctx))
+			}
+
+		case job := <-sc.jobs:
+			err := job.fn(ctx)
+			if job.sync {
+				after(err)
+			}
+			close(job.done)
+
+		case <-catchUp.C:
+			sc.Trigger(ReasonCatchUp)
+
+		case <-periodic.C:
+			sc.mu.Lock()
+			sc.pending[ReasonPeriodic] = true
+			sc.mu.Unlock()
+			after(sc.runPending(ctx))
+		}
+	}
+}
+
+// hasPending reports whether a sync has been requested
+func (sc *syncScheduler) hasPending() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return len(sc.pending) > 0
+}
+
+// runPending runs one sync for all requests collected so far. Triggers
+// arriving while it runs are kept for the next one.
+func (sc *syncScheduler) runPending(ctx context.Context) error {
+	sc.mu.Lock()
+	reasons := make([]string, 0, len(sc.pending))
+	full := false
+	for reason := range sc.pending {
+		reasons = append(reasons, string(reason))
+		full = full || fullSyncReasons[reason]
+	}
+	changes := sc.changes
+	sc.pending = make(map[SyncReason]bool)
+	sc.changes = ChangeSet{}
+	sc.mu.Unlock()
+
+	if len(reasons) == 0 {
+		return nil
+	}
+	sort.Strings(reasons)
+
+	sc.mu.Lock()
+	sc.history.lastID++
+	run := &SyncRun{ID: sc.history.lastID, StartedAt: time.Now(), Reasons: reasons, Full: full}
+	sc.history.running = run
+	sc.mu.Unlock()
+
+	logger := sc.logger.With("sync_id", run.ID)
+	logger.Info("Sync triggered", "reasons", strings.Join(reasons, ","), "full", full)
+	ctx = withSyncID(ctx, run.ID)
+
+	var err error
+	if full {
+		err = sc.sync(ctx, nil)
+	} else {
+		err = sc.sync(ctx, &changes)
+	}
+	sc.finish(run, err)
+
+	switch {
+	case ctx.Err() != nil:
+		logger.Info("Sync cancelled", "duration", time.Since(run.StartedAt))
+	case err != nil:
+		logger.Error("Sync failed", "error", err, "duration", time.Since(run.StartedAt))
+	}
+	return err
+}
+
+// finish records the outcome of a sync
+func (sc *syncScheduler) finish(run *SyncRun, err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	finished := *run
+	finished.Duration = time.Since(run.StartedAt)
+	metrics.recordSync(finished.Duration, err)
+	h := &sc.history
+	h.running = nil
+	h.last = &finished
+	h.runs++
+	if err != nil {
+		finished.Error = err.Error()
+		h.failures++
+		h.lastError = finished.Error
+		h.lastErrorAt = time.Now()
+		return
+	}
+	h.lastSuccess = time.Now()
+}
+
+// status fills in the pending work and sync history
+func (sc *syncScheduler) status(st *ServiceStatus) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for reason := range sc.pending {
+		st.Pending.Reasons = append(st.Pending.Reasons, string(reason))
+	}
+	sort.Strings(st.Pending.Reasons)
+	st.Pending.MACs = len(sc.changes.MACs())
+
+	h := sc.history
+	if h.running != nil {
+		running := *h.running
+		running.Duration = time.Since(running.StartedAt)
+		st.Running = &running
+	}
+	if h.last != nil {
+		last := *h.last
+		st.LastSync = &last
+	}
+	st.LastSuccess = optionalTime(h.lastSuccess)
+	st.LastError = h.lastError
+	st.LastErrorAt = optionalTime(h.lastErrorAt)
+	st.Syncs = h.runs
+	st.FailedSyncs = h.failures
+}
+
+// resetPeriodic arms the periodic timer for the next reconciliation
+func (sc *syncScheduler) resetPeriodic(timer *time.Timer) {
+	sc.mu.Lock()
+	interval := sc.interval
+	sc.mu.Unlock()
+
+	if interval <= 0 {
+		timer.Stop()
+		return
+	}
+	jitter := time.Duration((rand.Float64()*2 - 1) * reconcileJitter * float64(interval))
+	resetTimer(timer, interval+jitter)
+}
+
+// resetTimer stops the timer, drains a pending fire and restarts it
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
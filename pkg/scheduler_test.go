@@ -0,0 +1,215 @@
+// pkg/scheduler_test.go
+package pkg
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSync records the syncs a scheduler runs. Each sync blocks until
+// release is closed.
+type fakeSync struct {
+	mu      sync.Mutex
+	calls   []*ChangeSet // nil for a full sync
+	started chan struct{}
+	release chan struct{}
+}
+
+func newFakeSync() *fakeSync {
+	return &fakeSync{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (f *fakeSync) sync(ctx context.Context, changes *ChangeSet) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, changes)
+	f.mu.Unlock()
+
+	f.started <- struct{}{}
+	<-f.release
+	return nil
+}
+
+// syncs returns the change sets of the syncs run so far
+func (f *fakeSync) syncs() []*ChangeSet {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*ChangeSet(nil), f.calls...)
+}
+
+// waitStarted waits for the next sync to start
+func (f *fakeSync) waitStarted(t *testing.T) {
+	t.Helper()
+	select {
+	case <-f.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync did not start")
+	}
+}
+
+// noMoreSyncs fails if another sync starts within a few debounce periods
+func (f *fakeSync) noMoreSyncs(t *testing.T) {
+	t.Helper()
+	select {
+	case <-f.started:
+		t.Fatalf("unexpected sync, %d in total", len(f.syncs()))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// startScheduler runs a scheduler for the fake sync with a short debounce,
+// returning it with a function that stops it and waits for Run to return
+func startScheduler(t *testing.T, f *fakeSync) (*syncScheduler, func()) {
+	t.Helper()
+	sc := newSyncScheduler(f.sync, 0, newTestLogger(), false)
+	sc.debounce = 10 * time.Millisecond
+
+	stop := make(chan struct{})
+	go sc.Run(context.Background(), stop)
+
+	var once sync.Once
+	stopFn := func() {
+		once.Do(func() {
+			close(stop)
+			<-sc.stopped
+		})
+	}
+	t.Cleanup(func() {
+		select {
+		case <-f.release:
+		default:
+			close(f.release)
+		}
+		stopFn()
+	})
+	return sc, stopFn
+}
+
+func TestSchedulerCoalescesTriggersDuringSync(t *testing.T) {
+	f := newFakeSync()
+	sc, _ := startScheduler(t, f)
+
+	sc.TriggerChanges(ReasonLeaseFile, ChangeSet{Added: []string{"aa:bb:cc:dd:ee:01"}})
+	f.waitStarted(t)
+
+	// Everything requested while the sync runs ends up in one follow-up
+	sc.TriggerChanges(ReasonLeaseFile, ChangeSet{Changed: []string{"aa:bb:cc:dd:ee:02"}})
+	sc.TriggerChanges(ReasonNDP, ChangeSet{Changed: []string{"aa:bb:cc:dd:ee:03"}})
+	sc.TriggerChanges(ReasonLeaseFile, ChangeSet{Removed: []string{"aa:bb:cc:dd:ee:04"}})
+	close(f.release)
+
+	f.waitStarted(t)
+	f.noMoreSyncs(t)
+
+	syncs := f.syncs()
+	if len(syncs) != 2 {
+		t.Fatalf("syncs = %d, want 2", len(syncs))
+	}
+	if syncs[1] == nil {
+		t.Fatal("follow-up sync is full, want incremental")
+	}
+	var macs []string
+	for mac := range syncs[1].MACs() {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	want := []string{"aa:bb:cc:dd:ee:02", "aa:bb:cc:dd:ee:03", "aa:bb:cc:dd:ee:04"}
+	if !reflect.DeepEqual(macs, want) {
+		t.Errorf("follow-up MACs = %v, want %v", macs, want)
+	}
+}
+
+func TestSchedulerFullReasonWinsOverChanges(t *testing.T) {
+	f := newFakeSync()
+	sc, _ := startScheduler(t, f)
+
+	sc.TriggerChanges(ReasonLeaseFile, ChangeSet{Added: []string{"aa:bb:cc:dd:ee:01"}})
+	f.waitStarted(t)
+
+	sc.TriggerChanges(ReasonLeaseFile, ChangeSet{Changed: []string{"aa:bb:cc:dd:ee:02"}})
+	sc.Trigger(ReasonReload)
+	close(f.release)
+
+	f.waitStarted(t)
+	f.noMoreSyncs(t)
+
+	syncs := f.syncs()
+	if len(syncs) != 2 || syncs[1] != nil {
+		t.Fatalf("syncs = %v, want an incremental sync followed by a full one", syncs)
+	}
+
+	var st ServiceStatus
+	sc.status(&st)
+	if st.LastSync == nil || !st.LastSync.Full {
+		t.Fatalf("last sync = %+v, want a full sync", st.LastSync)
+	}
+	want := []string{string(ReasonReload), string(ReasonLeaseFile)}
+	if !reflect.DeepEqual(st.LastSync.Reasons, want) {
+		t.Errorf("reasons = %v, want %v", st.LastSync.Reasons, want)
+	}
+}
+
+func TestSchedulerSyncNowWaitsForRunningSync(t *testing.T) {
+	f := newFakeSync()
+	sc, _ := startScheduler(t, f)
+
+	sc.TriggerChanges(ReasonLeaseFile, ChangeSet{Added: []string{"aa:bb:cc:dd:ee:01"}})
+	f.waitStarted(t)
+
+	type outcome struct {
+		run SyncRun
+		err error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		run, err := sc.SyncNow(context.Background(), ReasonManual)
+		done <- outcome{run, err}
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("SyncNow returned while a sync was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got := len(f.syncs()); got != 1 {
+		t.Fatalf("syncs while the first runs = %d, want 1", got)
+	}
+
+	close(f.release)
+	var result outcome
+	select {
+	case result = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SyncNow did not return")
+	}
+	if result.err != nil {
+		t.Fatalf("SyncNow: %v", result.err)
+	}
+	if result.run.ID != 2 || !result.run.Full || !reflect.DeepEqual(result.run.Reasons, []string{string(ReasonManual)}) {
+		t.Errorf("SyncNow run = %+v, want the second sync, full, for %q", result.run, ReasonManual)
+	}
+	if syncs := f.syncs(); len(syncs) != 2 || syncs[1] != nil {
+		t.Errorf("syncs = %v, want an incremental sync followed by a full one", syncs)
+	}
+}
+
+func TestSchedulerStopped(t *testing.T) {
+	f := newFakeSync()
+	sc, stop := startScheduler(t, f)
+	stop()
+
+	if _, err := sc.SyncNow(context.Background(), ReasonManual); !errors.Is(err, ErrSchedulerStopped) {
+		t.Errorf("SyncNow after stop = %v, want %v", err, ErrSchedulerStopped)
+	}
+	err := sc.Exclusive(context.Background(), func(context.Context) error { return nil })
+	if !errors.Is(err, ErrSchedulerStopped) {
+		t.Errorf("Exclusive after stop = %v, want %v", err, ErrSchedulerStopped)
+	}
+	if got := len(f.syncs()); got != 0 {
+		t.Errorf("syncs = %d, want 0", got)
+	}
+}
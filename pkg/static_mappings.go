@@ -0,0 +1,99 @@
+// pkg/static_mappings.go
+package pkg
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// staticMapEntry holds the fields of the static mapping elements used by
+// the ISC (<staticmap>), DNSMasq (<hosts>) and Kea (<reservation>) DHCP
+// servers in the OPNsense config.xml
+type staticMapEntry struct {
+	// ISC DHCP
+	MAC      string `xml:"mac"`
+	IPAddr   string `xml:"ipaddr"`
+	Hostname string `xml:"hostname"`
+
+	// DNSMasq
+	HWAddr string `xml:"hwaddr"`
+	IP     string `xml:"ip"`
+	Host   string `xml:"host"`
+
+	// Kea
+	HWAddress string `xml:"hw_address"`
+	IPAddress string `xml:"ip_address"`
+}
+
+// lease converts the entry to a static lease, reporting false if the entry
+// lacks a MAC or IPv4 address (e.g. DHCPv6 mappings or plain host overrides)
+func (e staticMapEntry) lease() (StaticDHCPLease, bool) {
+	lease := StaticDHCPLease{
+		MAC:      firstNonEmpty(e.MAC, e.HWAddr, e.HWAddress),
+		IP:       firstNonEmpty(e.IPAddr, e.IP, e.IPAddress),
+		Hostname: firstNonEmpty(e.Hostname, e.Host),
+	}
+
+	// DNSMasq allows several comma separated hardware addresses
+	lease.MAC, _, _ = strings.Cut(lease.MAC, ",")
+	lease.MAC = strings.ToLower(strings.TrimSpace(lease.MAC))
+
+	if !IsValidMAC(lease.MAC) || !strings.Contains(lease.IP, ".") {
+		return lease, false
+	}
+	return lease, true
+}
+
+// ReadStaticMappings extracts the DHCPv4 static mappings from an OPNsense
+// config.xml, keyed by MAC address
+func ReadStaticMappings(path string) (map[string]StaticDHCPLease, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening OPNsense config: %w", err)
+	}
+	defer file.Close()
+
+	mappings := make(map[string]StaticDHCPLease)
+	decoder := xml.NewDecoder(file)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing OPNsense config: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "staticmap", "hosts", "reservation":
+			var entry staticMapEntry
+			if err := decoder.DecodeElement(&entry, &start); err != nil {
+				return nil, fmt.Errorf("parsing %s entry: %w", start.Name.Local, err)
+			}
+			if lease, ok := entry.lease(); ok {
+				mappings[lease.MAC] = lease
+			}
+		}
+	}
+
+	return mappings, nil
+}
+
+// firstNonEmpty returns the first non-empty, trimmed value
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
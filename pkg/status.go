@@ -0,0 +1,227 @@
+// pkg/status.go
+package pkg
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// ServiceStatus is a snapshot of what the running service is doing
+type ServiceStatus struct {
+	PID           int       `json:"pid"`
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	DryRun        bool      `json:"dry_run"`
+
+	Syncs       int        `json:"syncs"`
+	FailedSyncs int        `json:"failed_syncs"`
+	Running     *SyncRun   `json:"running,omitempty"` // Sync in progress
+	LastSync    *SyncRun   `json:"last_sync,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+
+	Pending  PendingWork        `json:"pending"`
+	Leases   LeaseCounts        `json:"leases"`
+	Targets  []TargetSyncStatus `json:"targets"`
+	Watchers []WatcherHealth    `json:"watchers"`
+}
+
+// SyncRun describes one sync run by the scheduler
+type SyncRun struct {
+	ID        uint64        `json:"id"` // Tags the log messages of the sync as sync_id
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration_ns"`
+	Reasons   []string      `json:"reasons"`
+	Full      bool          `json:"full"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// PendingWork describes the sync requests waiting for the next run
+type PendingWork struct {
+	Reasons []string `json:"reasons"`
+	MACs    int      `json:"macs"` // Changed MACs of an incremental sync
+}
+
+// LeaseCounts counts the leases read by the last sync
+type LeaseCounts struct {
+	Total  int `json:"total"`
+	Active int `json:"active"`
+}
+
+// TargetSyncStatus is the outcome of the last sync to a target
+type TargetSyncStatus struct {
+	Name     string        `json:"name"`
+	SyncedAt time.Time     `json:"synced_at"`
+	Clients  int           `json:"clients"` // Clients with a MAC address, all managed by the service
+	Added    int           `json:"added"`
+	Updated  int           `json:"updated"`
+	Removed  int           `json:"removed"`
+	Failed   int           `json:"failed"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// WatcherHealth describes a background watcher that triggers syncs
+type WatcherHealth struct {
+	Name        string     `json:"name"`
+	Running     bool       `json:"running"`
+	Entries     int        `json:"entries"` // Files watched, NDP table entries or leases polled
+	LastEvent   *time.Time `json:"last_event,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// record updates the health with the outcome of an event
+func (h *WatcherHealth) record(err error) {
+	now := time.Now()
+	if err != nil {
+		h.LastError = err.Error()
+		h.LastErrorAt = &now
+		return
+	}
+	h.LastEvent = &now
+}
+
+// optionalTime returns nil for the zero time, so it's left out of the JSON
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// sourceHealth is the health of the poller of a remote lease source
+type sourceHealth struct {
+	reader RemoteLeaseReader
+	health WatcherHealth
+}
+
+// statusRecorder collects the service state reported by Status that the
+// scheduler and the NDP watcher don't track themselves
+type statusRecorder struct {
+	mu        sync.Mutex
+	startedAt time.Time
+	dryRun    bool
+	leases    LeaseCounts
+	targets   map[string]TargetSyncStatus
+	order     []string // Target names in configuration order
+	files     WatcherHealth
+	sources   []*sourceHealth
+}
+
+// recordLeases records the leases read by a sync
+func (r *statusRecorder) recordLeases(leases map[string]ISCDHCPLease, dryRun bool) {
+	counts := LeaseCounts{Total: len(leases)}
+	for _, lease := range leases {
+		if lease.IsActive {
+			counts.Active++
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.leases = counts
+	r.dryRun = dryRun
+}
+
+// recordTargets records the results of a sync, forgetting targets that are
+// no longer configured
+func (r *statusRecorder) recordTargets(targets []*syncTarget, results []TargetResult) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous := r.targets
+	r.targets = make(map[string]TargetSyncStatus, len(results))
+	r.order = r.order[:0]
+	for _, t := range targets {
+		name := t.store.Name()
+		r.order = append(r.order, name)
+		if status, ok := previous[name]; ok {
+			r.targets[name] = status
+		}
+	}
+	for _, result := range results {
+		status := TargetSyncStatus{
+			Name:     result.Target,
+			SyncedAt: now,
+			Clients:  result.Clients,
+			Added:    result.Added,
+			Updated:  result.Updated,
+			Removed:  result.Removed,
+			Failed:   result.Failed,
+			Duration: result.Duration,
+		}
+		if result.Err != nil {
+			status.Error = result.Err.Error()
+		}
+		r.targets[result.Target] = status
+	}
+}
+
+// fileWatcher runs fn on the health of the lease file watcher
+func (r *statusRecorder) fileWatcher(fn func(h *WatcherHealth)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(&r.files)
+}
+
+// resetSources replaces the lease source pollers
+func (r *statusRecorder) resetSources(readers []RemoteLeaseReader) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources = r.sources[:0]
+	for _, reader := range readers {
+		r.sources = append(r.sources, &sourceHealth{
+			reader: reader,
+			health: WatcherHealth{Name: "lease source " + reader.Path()},
+		})
+	}
+}
+
+// source runs fn on the health of the poller of reader. Pollers replaced
+// by a reload are ignored.
+func (r *statusRecorder) source(reader RemoteLeaseReader, fn func(h *WatcherHealth)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, source := range r.sources {
+		if source.reader == reader {
+			fn(&source.health)
+			return
+		}
+	}
+}
+
+// status fills in the recorded state
+func (r *statusRecorder) status(st *ServiceStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	st.StartedAt = r.startedAt
+	if !r.startedAt.IsZero() {
+		st.UptimeSeconds = int64(time.Since(r.startedAt).Seconds())
+	}
+	st.DryRun = r.dryRun
+	st.Leases = r.leases
+	st.Targets = make([]TargetSyncStatus, 0, len(r.order))
+	for _, name := range r.order {
+		if status, ok := r.targets[name]; ok {
+			st.Targets = append(st.Targets, status)
+		}
+	}
+	st.Watchers = append(st.Watchers, r.files)
+	for _, source := range r.sources {
+		st.Watchers = append(st.Watchers, source.health)
+	}
+}
+
+// Status returns a snapshot of the service state
+func (s *SyncService) Status() ServiceStatus {
+	st := ServiceStatus{PID: os.Getpid(), Pending: PendingWork{Reasons: []string{}}}
+	s.status.status(&st)
+	s.scheduler.status(&st)
+	st.Watchers = append(st.Watchers, s.ndpWatcher.Health())
+	return st
+}
@@ -1,15 +1,16 @@
 package pkg
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/gmichels/adguard-client-go"
 )
 
 // SyncService represents the DHCP to AdGuard sync service
@@ -21,11 +22,6 @@ func NewSyncService(cfg Config) (*SyncService, error) {
 		return nil, fmt.Errorf("creating file dhcpLeaseWatcher: %w", err)
 	}
 
-	adguardClient, err := NewAdGuard(cfg)
-	if err != nil {
-		return nil, fmt.Errorf("creating AdGuard client: %w", err)
-	}
-
 	// Create NDP watcher
 	ndpWatcher, err := NewNDPTableWatcher(NDPTableWatcherConfig{
 		UpdateInterval: cfg.NDPUpdateInterval,
@@ -37,108 +33,256 @@ func NewSyncService(cfg Config) (*SyncService, error) {
 
 	}
 
-	// Create the appropriate lease reader based on the configured format
-	var leaseReader LeaseReader
+	service := &SyncService{
+		logger:           cfg.Logger,
+		dhcpLeaseWatcher: dhcpLeaseWatcher,
+		ndpWatcher:       ndpWatcher,
+		debug:            cfg.Debug,
+	}
+	if err := service.configure(cfg); err != nil {
+		return nil, err
+	}
+	service.ctx, service.cancel = context.WithCancel(context.Background())
+	service.stopping = make(chan struct{})
+	service.stopped = make(chan struct{})
+	service.shutdownTimeout = cfg.ShutdownTimeout
+	if service.shutdownTimeout <= 0 {
+		service.shutdownTimeout = DefaultShutdownTimeout
+	}
+	service.scheduler = newSyncScheduler(service.sync, cfg.ReconcileInterval, cfg.Logger, cfg.Debug)
+
+	// Register callback for NDP table updates
+	ndpWatcher.AddCallback(service.handleNDPUpdate)
+
+	if service.debug {
+		targets := make([]string, 0, len(service.targets))
+		for _, target := range service.targets {
+			targets = append(targets, target.store.Name())
+		}
+		service.logger.Info("Created new SyncService",
+			"lease_path", cfg.LeasePath,
+			"targets", strings.Join(targets, ","),
+			"dry_run", cfg.DryRun,
+			"preserve_deleted_hosts", cfg.PreserveDeletedHosts,
+			"ndp_update_interval", cfg.NDPUpdateInterval,
+			"tag_rules", cfg.Tags.Enabled(),
+			"rewrite_domain", cfg.RewriteDomain,
+			"adguard_dhcp_leases", cfg.AdGuardDHCPLeases,
+			"sync_static_leases", cfg.SyncStaticLeases,
+			"reconcile_interval", cfg.ReconcileInterval,
+			"profiles", len(cfg.Profiles),
+			"reapply_profiles", cfg.ReapplyProfiles)
+		for _, output := range cfg.DNSOutputs {
+			service.logger.Info("DNS output configured", "path", output.Path, "format", output.Format)
+		}
+	}
+
+	return service, nil
+}
+
+// configure builds the targets, lease readers and outputs described by cfg.
+// An ownership store already set on s is kept when cfg uses the same file.
+func (s *SyncService) configure(cfg Config) error {
+	s.cfg = cfg
+	s.dryRun = cfg.DryRun
+	s.preserveDeletedHosts = cfg.PreserveDeletedHosts
+	s.profiles = cfg.Profiles
+	s.reapplyProfiles = cfg.ReapplyProfiles
+	s.tags = cfg.Tags
+	s.workers = cfg.Workers
+
+	// Create a client store for every sync target
+	var targets []*syncTarget
+	var adguardTarget *syncTarget
+	var adguardClient *AdGuard
+	for _, targetCfg := range cfg.SyncTargets() {
+		store, err := newClientStore(targetCfg)
+		if err != nil {
+			return fmt.Errorf("creating target %s: %w", targetCfg.Name, err)
+		}
+		target := &syncTarget{
+			store:  store,
+			naming: targetCfg.Naming,
+			filter: targetCfg.Filter,
+		}
+		targets = append(targets, target)
+
+		if a, ok := store.(*AdGuard); ok {
+			name := targetCfg.Name
+			a.onRecover = func() {
+				cfg.Logger.Info("AdGuard Home is reachable again", "target", name)
+			}
+		}
+
+		// The first AdGuard target also handles the DHCP integration
+		if a, ok := store.(*AdGuard); ok && adguardClient == nil {
+			adguardTarget = target
+			adguardClient = a
+		}
+	}
+	s.targets = targets
+
+	if adguardClient == nil && (cfg.RewriteDomain != "" || cfg.AdGuardDHCPLeases || cfg.SyncStaticLeases) {
+		return fmt.Errorf("DNS rewrites and AdGuard DHCP integration require an AdGuard target")
+	}
+
+	// Create a lease reader for every lease file
+	sources := cfg.LeaseSources
+	if len(sources) == 0 {
+		format := cfg.LeaseFormat
+		if format != DNSMasqFormat {
+			format = ISCDHCPFormat
+		}
+		sources = []LeaseSource{{Format: format, Path: cfg.LeasePath}}
+	}
 
-	switch cfg.LeaseFormat {
-	case DNSMasqFormat:
-		leaseReader = NewDNSMasq(cfg.LeasePath)
+	var readers []LeaseReader
+	for _, source := range sources {
+		readers = append(readers, newLeaseFileReader(source.Format, source.Path))
 		if cfg.Debug {
-			cfg.Logger.Info("Using DNSMasq lease format")
+			cfg.Logger.Info("Using lease file", "format", source.Format, "path", source.Path)
 		}
-	case ISCDHCPFormat:
-		fallthrough
-	default:
-		leaseReader = NewDHCP(cfg.LeasePath)
+	}
+
+	// Optionally merge in the leases handed out by AdGuard's own DHCP server
+	if cfg.AdGuardDHCPLeases {
+		readers = append(readers, NewAdGuardDHCPReader(adguardClient, cfg.LeasePollInterval))
 		if cfg.Debug {
-			cfg.Logger.Info("Using ISC DHCP lease format")
+			cfg.Logger.Info("Including AdGuard DHCP leases")
 		}
 	}
 
-	service := &SyncService{
-		adguard:              adguardClient,
-		leases:               leaseReader,
-		logger:               cfg.Logger,
-		dhcpLeaseWatcher:     dhcpLeaseWatcher,
-		ndpWatcher:           ndpWatcher,
-		done:                 make(chan bool),
-		dryRun:               cfg.DryRun,
-		preserveDeletedHosts: cfg.PreserveDeletedHosts,
-		debug:                cfg.Debug,
+	if len(readers) == 1 {
+		s.leases = readers[0]
+	} else {
+		s.leases = NewMultiLeaseReader(readers, cfg.Logger, cfg.Debug)
 	}
-	// Register callback for NDP table updates
-	ndpWatcher.AddCallback(service.handleNDPUpdate)
 
-	if service.debug {
-		service.logger.Info("Created new SyncService with config:")
-		service.logger.Info("- Lease path: " + cfg.LeasePath)
-		service.logger.Info("- Dry run: " + fmt.Sprintf("%v", cfg.DryRun))
-		service.logger.Info("- Preserve deleted hosts: " + fmt.Sprintf("%v", cfg.PreserveDeletedHosts))
-		service.logger.Info("- Debug mode: enabled")
-		service.logger.Info("- NDP update interval: " + fmt.Sprintf("%v", cfg.NDPUpdateInterval))
+	needsState := cfg.RewriteDomain != "" || cfg.SyncStaticLeases || cfg.Tags.Enabled()
+	for _, targetCfg := range cfg.SyncTargets() {
+		needsState = needsState || targetCfg.DNSDomain != ""
+	}
+	if !needsState {
+		s.state = nil
+	} else if s.state == nil || s.state.path != cfg.StateFile {
+		state, err := NewOwnershipStore(cfg.StateFile)
+		if err != nil {
+			return fmt.Errorf("loading ownership state: %w", err)
+		}
+		s.state = state
+	}
 
+	for i, targetCfg := range cfg.SyncTargets() {
+		switch store := targets[i].store.(type) {
+		case *AdGuard:
+			// Every AdGuard instance gets the rewrites; the first one keeps the
+			// original state section so existing entries stay owned
+			if cfg.RewriteDomain == "" {
+				continue
+			}
+			section := rewriteSection
+			if targets[i] != adguardTarget {
+				section = rewriteSection + ":" + targetCfg.Name
+			}
+			targets[i].records = s.newDNSRewriter(store, section, cfg.RewriteDomain)
+		case RecordBackend:
+			if targetCfg.DNSDomain != "" {
+				targets[i].records = s.newDNSRewriter(store, "dns_hosts:"+targetCfg.Name, targetCfg.DNSDomain)
+			}
+		}
 	}
 
-	return service, nil
+	s.dnsOutputs = nil
+	for _, output := range cfg.DNSOutputs {
+		s.dnsOutputs = append(s.dnsOutputs, &DNSFileWriter{
+			config: output,
+			logger: cfg.Logger,
+			dryRun: cfg.DryRun,
+			debug:  s.debug,
+		})
+	}
+
+	s.staticLeases = nil
+	if cfg.SyncStaticLeases {
+		s.staticLeases = &StaticLeaseWriter{
+			adguard:    adguardClient,
+			configPath: cfg.OPNsenseConfigPath,
+			owned:      s.state,
+			logger:     cfg.Logger,
+			dryRun:     cfg.DryRun,
+			debug:      s.debug,
+		}
+	}
+
+	return nil
 }
 
 // handleNDPUpdate is called when the NDP table changes
-func (s *SyncService) handleNDPUpdate(ndpTable map[string][]string) {
+func (s *SyncService) handleNDPUpdate(ndpTable map[string][]string, changes ChangeSet) {
 	if s.debug {
-		s.logger.Info("NDP table update detected")
+		s.logger.Info("NDP table update detected", changes.attrs()...)
 	}
 
-	// Trigger a sync when NDP table changes
-	if err := s.Sync(); err != nil {
-		s.logger.Error(fmt.Sprintf("Sync after NDP update failed: %v", err))
-	}
+	// Trigger a sync of the MACs whose addresses changed
+	s.scheduler.TriggerChanges(ReasonNDP, changes)
 }
-func (s *SyncService) addClientWithRetry(action *AdguardUpdateAction) error {
+
+func (s *SyncService) addClientWithRetry(ctx context.Context, t *syncTarget, action *AdguardUpdateAction) error {
+	logger := s.log(ctx).With("target", t.store.Name(), "mac", action.MAC, "action", "add")
 	if s.debug {
-		s.logger.Info(fmt.Sprintf("Attempting to add client - hostname: %s, MAC: %s, IDs: %v",
-			action.Hostname, action.MAC, action.IDs))
+		logger.Info("Attempting to add client", "hostname", action.Hostname, "ids", action.IDs)
 	}
 
 	maxRetries := 10
 	hostname := action.Hostname
 
+	client := StoreClient{
+		Name:    hostname,
+		MAC:     action.MAC,
+		IDs:     action.IDs,
+		Tags:    action.Tags,
+		Profile: action.Profile,
+	}
+
 	// First try without suffix
-	err := s.adguard.AddClient(hostname, action.MAC, action.IDs)
+	err := t.store.AddClient(ctx, client)
 	if err == nil {
+		s.recordTags(t, action.MAC, nil, action.Tags)
 		if s.debug {
-			s.logger.Info(fmt.Sprintf("Successfully added client %s on first attempt", hostname))
+			logger.Info("Successfully added client on first attempt", "hostname", hostname)
 		}
 		return nil
 	}
 
 	if s.debug {
-		s.logger.Info(fmt.Sprintf("Initial add attempt failed: %v", err))
+		logger.Info("Initial add attempt failed", "hostname", hostname, "error", err)
 	}
 
 	// If error is not name conflict, return immediately
-	if !strings.Contains(strings.ToLower(err.Error()), "uses the same name") {
+	if !errors.Is(err, ErrNameConflict) {
 		return err
 	}
 
 	// Try with incremental suffixes
 	for i := 1; i <= maxRetries; i++ {
-		newName := fmt.Sprintf("%s-%d", hostname, i)
+		client.Name = fmt.Sprintf("%s-%d", hostname, i)
 		if s.debug {
-			s.logger.Info(fmt.Sprintf("Attempting retry %d with name: %s", i, newName))
+			logger.Info("Retrying with another name", "attempt", i, "hostname", client.Name)
 		}
 
-		err = s.adguard.AddClient(newName, action.MAC, action.IDs)
+		err = t.store.AddClient(ctx, client)
 		if err == nil {
-			s.logger.Info(fmt.Sprintf("Successfully added client with modified name: %s", newName))
+			s.recordTags(t, action.MAC, nil, action.Tags)
+			logger.Info("Successfully added client with modified name", "hostname", client.Name)
 			return nil
 		}
 
 		if s.debug {
-			s.logger.Info(fmt.Sprintf("Retry %d failed: %v", i, err))
+			logger.Info("Retry failed", "attempt", i, "hostname", client.Name, "error", err)
 		}
 
 		// Only continue retrying if it's a name conflict
-		if !strings.Contains(strings.ToLower(err.Error()), "uses the same name") {
+		if !errors.Is(err, ErrNameConflict) {
 			return err
 		}
 	}
@@ -146,180 +290,52 @@ func (s *SyncService) addClientWithRetry(action *AdguardUpdateAction) error {
 	return fmt.Errorf("failed to add client after %d retries: %v", maxRetries, err)
 }
 
-//ips := []string{ip}
-//
-//// Try to get IPv6 addresses
-//ip6Addresses, err := s.ndpWatcher.GetIP6forMAC(mac)
-//if err == nil && len(ip6Addresses) > 0 {
-//	// Append all IPv6 addresses to our IPs array
-//	ips = append(ips, ip6Addresses...)
-//}
-//
-//if s.debug {
-//	s.logger.Info(fmt.Sprintf("Attempting to add client - hostname: %s, MAC: %s, IPs: %s", hostname, mac, ips))
-//}
-//
-//maxRetries := 10
-//
-//// First try without suffix
-//err = s.adguard.AddClient(hostname, mac, ips)
-//if err == nil {
-//	if s.debug {
-//		s.logger.Info(fmt.Sprintf("Successfully added client %s on first attempt", hostname))
-//	}
-//	return nil
-//}
-//
-//if s.debug {
-//	s.logger.Info(fmt.Sprintf("Initial add attempt failed: %v", err))
-//}
-//
-//// If error is not name conflict, return immediately
-//if !strings.Contains(strings.ToLower(err.Error()), "uses the same name") {
-//	return err
-//}
-//
-//// Try with incremental suffixes
-//for i := 1; i <= maxRetries; i++ {
-//	newName := fmt.Sprintf("%s-%d", hostname, i)
-//	if s.debug {
-//		s.logger.Info(fmt.Sprintf("Attempting retry %d with name: %s", i, newName))
-//	}
-//
-//	err = s.adguard.AddClient(newName, mac, ips)
-//	if err == nil {
-//		s.logger.Info(fmt.Sprintf("Successfully added client with modified name: %s", newName))
-//		return nil
-//	}
-//
-//	if s.debug {
-//		s.logger.Info(fmt.Sprintf("Retry %d failed: %v", i, err))
-//	}
-//
-//	if !strings.Contains(strings.ToLower(err.Error()), "uses the same name") {
-//		return err
-//	}
-//}
-//
-//return fmt.Errorf("failed to add client after %d retries: %v", maxRetries, err)
-
-func (s *SyncService) updateClient(existingClient *adguard.Client, action *AdguardUpdateAction) error {
+func (s *SyncService) updateClient(ctx context.Context, t *syncTarget, existingClient *StoreClient, action *AdguardUpdateAction) error {
+	logger := s.log(ctx).With("target", t.store.Name(), "mac", action.MAC, "action", "update")
 	if s.debug {
-		s.logger.Info(fmt.Sprintf("[%s] Attempting to update client, Current name: %s, Hostname: %s",
-			action.MAC, existingClient.Name, action.Hostname))
+		logger.Info("Attempting to update client", "name", existingClient.Name, "hostname", action.Hostname)
 	}
 
-	hostname := action.Hostname
-
-	// First try with original name
-	updatedClient := *existingClient
-	updatedClient.Name = hostname
-	updatedClient.Ids = action.IDs
-
-	clientUpdate := adguard.ClientUpdate{
-		Name: existingClient.Name,
-		Data: updatedClient,
+	desired := StoreClient{
+		Name:         existingClient.Name,
+		MAC:          action.MAC,
+		IDs:          action.IDs,
+		Tags:         s.tags.Merge(existingClient.Tags, s.appliedTags(t, action.MAC), action.Tags),
+		Profile:      action.Profile,
+		ApplyProfile: s.reapplyProfiles,
 	}
-	clientUpdate.Data.Name = existingClient.Name
-	clientUpdate.Name = existingClient.Name
 
 	if s.debug {
-		s.logger.Info(fmt.Sprintf("[%s] Updating: %s (%s)", action.MAC, hostname, existingClient.Name))
+		logger.Info("Updating client", "name", existingClient.Name, "hostname", action.Hostname, "reason", action.Reason)
 	}
 
-	_, err := s.adguard.client.UpdateClient(clientUpdate)
-	if err == nil {
-		if s.debug {
-			s.logger.Info(fmt.Sprintf("[%s] Successfully updated client", action.MAC))
-		}
-		return nil
+	if err := t.store.UpdateClient(ctx, *existingClient, desired); err != nil {
+		return fmt.Errorf("[%s] failed to update client: %w", action.MAC, err)
 	}
+	s.recordTags(t, action.MAC, existingClient.Tags, action.Tags)
 
-	// Fail case decode what we sent maybe
-	rb, err := json.Marshal(clientUpdate)
-	if err != nil {
-		return fmt.Errorf("[%s] failed to update client: %v", action.MAC, err)
+	if s.debug {
+		logger.Info("Successfully updated client", "hostname", action.Hostname)
 	}
-	s.logger.Error(fmt.Sprintf("Request body: %s", string(rb)))
-
-	return fmt.Errorf("[%s] failed to update client: %v", action.MAC, err)
+	return nil
 }
 
-//func (s *SyncService) handleLeaseUpdate(existingClient *adguard.Client, lease ISCDHCPLease, mac string) error {
-//
-//	// Build an update action
-//	action := &AdguardUpdateAction{
-//		Type:     Update,
-//		Hostname: lease.Hostname,
-//		MAC:      mac,
-//	}
-//
-//	// Get IPv6 IDs
-//	ipv6IDs, err := s.ndpWatcher.GetIP6forMAC(mac)
-//	if err != nil {
-//		ipv6IDs = []string{}
-//	}
-//	action.IDs = ipv6IDs
-//	action.IDs = append(action.IDs, lease.IP)
-//	//
-//	//
-//	//
-//	//// Try RDNS if hostname is empty
-//	//if hostname == "" {
-//	//	names, err := net.LookupAddr(lease.IP)
-//	//	if err == nil && len(names) > 0 {
-//	//		hostname = strings.TrimSuffix(names[0], ".")
-//	//		if s.debug {
-//	//			s.logger.Info(fmt.Sprintf("Using RDNS hostname %s for update of MAC %s", hostname, mac))
-//	//		}
-//	//	} else {
-//	//		s.logger.Info(fmt.Sprintf("No hostname and no RDNS hostname available for MAC %s", mac))
-//	//		return fmt.Errorf("no hostname available for update")
-//	//	}
-//	//}
-//	//
-//	//// Create a modified lease with the RDNS hostname if needed
-//	//updatedLease := lease
-//	//updatedLease.Hostname = hostname
-//
-//	//ip6Addresses, _ := s.ndpWatcher.GetIP6forMAC(mac)
-//	//
-//	//action := fmt.Sprintf("Updating client [%s] (%s) with IP4 [%s] IP6 %s", hostname, mac, lease.IP, ip6Addresses)
-//	//
-//	//if s.dryRun {
-//	//	s.logger.Info("DRY-RUN: " + action)
-//	//	return nil
-//	//}
-//	//
-//	//s.logger.Info(action)
-//	if err := s.updateClient(existingClient, action); err != nil {
-//		s.logger.Error(fmt.Sprintf("Failed to update client: %v", err))
-//		return err
-//	}
-//	return nil
-//}
-
 // determineUpdateAction checks if and what kind of update is needed for a given lease
-func (s *SyncService) determineUpdateAction(lease ISCDHCPLease, mac string, existing *adguard.Client) (*AdguardUpdateAction, error) {
+func (s *SyncService) determineUpdateAction(ctx context.Context, t *syncTarget, planned *plannedLease, mac string, existing *StoreClient) (*AdguardUpdateAction, error) {
+	lease := planned.lease
 	action := &AdguardUpdateAction{
 		Type:     NoUpdate,
-		Hostname: lease.Hostname,
+		Hostname: t.naming.Name(lease, planned.profile),
 		MAC:      mac,
-	}
-
-	// Get IPv6 IDs
-	ipv6IDs, err := s.ndpWatcher.GetIP6forMAC(mac)
-	if err != nil {
-		ipv6IDs = []string{}
+		Profile:  planned.profile,
+		Tags:     planned.tags,
 	}
 
 	// Calculate RDNS
 	//rdnsNames, err := net.LookupAddr(lease.IP)
 
 	// Build wanted IDs list
-	action.IDs = ipv6IDs
-	//action.IDs = append(action.IDs, mac) // MAC is needed for an update - but seems to automatically be added to an Add
-	action.IDs = append(action.IDs, lease.IP)
+	action.IDs = append([]string(nil), planned.ids...)
 	// Skip RDNS
 	//if err == nil && len(rdnsNames) > 0 {
 	//	action.IDs = append(action.IDs, strings.Split(strings.TrimSuffix(rdnsNames[0], "."), ".")[0])
@@ -336,9 +352,25 @@ func (s *SyncService) determineUpdateAction(lease ISCDHCPLease, mac string, exis
 		return action, nil
 	}
 
+	// An update keeps the current name if the lease has no hostname
+	if action.Hostname == "" {
+		action.Hostname = existing.Name
+	}
+
+	// Stores without IP IDs compare clients themselves
+	if comparer, ok := t.store.(ClientComparer); ok {
+		desired := StoreClient{Name: action.Hostname, MAC: mac, IDs: action.IDs}
+		if differs, reason := comparer.Differs(ctx, *existing, desired); differs {
+			action.Type = Update
+			action.NeedsUpdate = true
+			action.Reason = reason
+		}
+		return action, nil
+	}
+
 	// Compare existing vs wanted IDs
 	existingIDsMap := make(map[string]bool)
-	for _, id := range existing.Ids {
+	for _, id := range existing.IDs {
 		if id != mac { // exclude mac address (I think)
 			existingIDsMap[id] = true
 		}
@@ -354,7 +386,7 @@ func (s *SyncService) determineUpdateAction(lease ISCDHCPLease, mac string, exis
 	for id := range wantedIDsMap {
 		if !existingIDsMap[id] {
 			if s.debug {
-				s.logger.Info(fmt.Sprintf("Missing ID found: %s for %s", id, mac))
+				s.log(ctx).Info("Missing ID found", "id", id, "mac", mac)
 			}
 			action.NeedsUpdate = true
 			action.Reason = fmt.Sprintf("missing ID: %s", id)
@@ -367,7 +399,7 @@ func (s *SyncService) determineUpdateAction(lease ISCDHCPLease, mac string, exis
 		for id := range existingIDsMap {
 			if !wantedIDsMap[id] {
 				if s.debug {
-					s.logger.Info(fmt.Sprintf("Extra ID found: %s", id))
+					s.log(ctx).Info("Extra ID found", "id", id, "mac", mac)
 				}
 				action.NeedsUpdate = true
 				action.Reason = fmt.Sprintf("extra ID: %s", id)
@@ -376,6 +408,20 @@ func (s *SyncService) determineUpdateAction(lease ISCDHCPLease, mac string, exis
 		}
 	}
 
+	// Check whether the derived tags have changed
+	if !action.NeedsUpdate && s.tags.Enabled() && !sameStrings(s.tags.Merge(existing.Tags, s.appliedTags(t, mac), action.Tags), existing.Tags) {
+		action.NeedsUpdate = true
+		action.Reason = fmt.Sprintf("tags changed: %v", action.Tags)
+	}
+
+	// Check whether the profile settings have drifted
+	if !action.NeedsUpdate && s.reapplyProfiles && action.Profile != nil {
+		if ps, ok := t.store.(ProfileStore); ok && ps.ProfileDiffers(*existing, action.Profile) {
+			action.NeedsUpdate = true
+			action.Reason = fmt.Sprintf("profile %s settings changed", action.Profile.Name)
+		}
+	}
+
 	// Check if IP is found
 	for _, id := range action.IDs {
 		if id == lease.IP {
@@ -386,184 +432,367 @@ func (s *SyncService) determineUpdateAction(lease ISCDHCPLease, mac string, exis
 
 	if action.NeedsUpdate || !action.IPFound {
 		action.Type = Update
-		// Update actions require the MAC address to be added
-		action.IDs = append(action.IDs, mac)
 	}
 
 	return action, nil
 }
 
-// buildClientMap creates a map of MAC addresses to AdGuard clients for efficient lookup
-func (s *SyncService) buildClientMap(clients []adguard.Client) map[string]*adguard.Client {
+// buildClientMap creates a map of MAC addresses to store clients for efficient lookup
+func (s *SyncService) buildClientMap(ctx context.Context, clients []StoreClient) map[string]*StoreClient {
 	if s.debug {
-		s.logger.Info("Building client MAC address map")
+		s.log(ctx).Info("Building client MAC address map")
 	}
 
-	currentClientsMap := make(map[string]*adguard.Client)
+	currentClientsMap := make(map[string]*StoreClient)
 	for _, client := range clients {
-		for _, id := range client.Ids {
-			if IsValidMAC(id) {
-				clientCopy := client
-				currentClientsMap[id] = &clientCopy
-				if s.debug {
-					s.logger.Info(fmt.Sprintf("Mapped client %s to MAC %s", client.Name, id))
-				}
-				break
-			}
+		clientCopy := client
+		currentClientsMap[client.MAC] = &clientCopy
+		if s.debug {
+			s.log(ctx).Info("Mapped client to MAC", "hostname", client.Name, "mac", client.MAC)
 		}
 	}
 
 	if s.debug {
-		s.logger.Info(fmt.Sprintf("Built map with %d clients", len(currentClientsMap)))
+		s.log(ctx).Info("Built client map", "clients", len(currentClientsMap))
 	}
 
 	return currentClientsMap
 }
 
-func (s *SyncService) Sync() error {
-	s.logger.Info("Starting sync")
+// Sync applies all leases to every target
+func (s *SyncService) Sync(ctx context.Context) error {
+	return s.sync(ctx, nil)
+}
 
-	// Get current clients from AdGuard
-	currentClients, err := s.adguard.GetClients()
-	if err != nil {
-		return fmt.Errorf("getting AdGuard clients: %w", err)
-	}
+// sync applies the leases to every target. With a change set only the MACs
+// it lists, plus those whose leases changed since the previous sync, are
+// processed; nil runs a full sync.
+func (s *SyncService) sync(ctx context.Context, changes *ChangeSet) error {
+	start := time.Now()
+	logger := s.log(ctx)
 
-	// Create MAC address lookup map
-	currentClientsMap := s.buildClientMap(currentClients)
+	// Keep the components in place until the sync has finished
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	// Get current DHCP leases
-	iscLeases, err := s.leases.GetLeases()
+	iscLeases, err := s.leases.GetLeases(ctx)
 	if err != nil {
 		return fmt.Errorf("getting DHCP leases: %w", err)
 	}
+	s.status.recordLeases(iscLeases, s.dryRun)
+	if _, multi := s.leases.(*MultiLeaseReader); !multi {
+		metrics.recordLeases(s.leases.Path(), iscLeases)
+	}
+
+	// Without a previous sync there is nothing to compare against
+	if s.lastLeases == nil {
+		changes = nil
+	}
+
+	var changed map[string]bool
+	var pending ChangeSet
+	recordsChanged := true
+	if changes != nil {
+		// A failed sync left its changes to this one
+		pending = changes.Merge(s.unsynced)
+		leaseChanges := diffLeases(s.lastLeases, iscLeases)
+		// DNS records carry the NDP addresses of their leases too
+		recordsChanged = !leaseChanges.Empty() || hasDNSRecords(iscLeases, pending.MACs())
+		merged := pending.Merge(leaseChanges)
+
+		if merged.Empty() {
+			logger.Info("No lease or NDP changes, skipping sync")
+			return nil
+		}
+		logger.Info("Starting incremental sync", append(merged.attrs(), "macs", len(merged.MACs()))...)
+		changed = merged.MACs()
+	} else {
+		logger.Info("Starting full sync", "leases", len(iscLeases))
+	}
+
+	// Only a sync that reached every target and output moves the baseline
+	// the next one is diffed against; otherwise its changes are retried
+	synced := false
+	defer func() {
+		switch {
+		case synced:
+			s.lastLeases = iscLeases
+			s.unsynced = ChangeSet{}
+		case changes != nil:
+			s.unsynced = pending
+		}
+	}()
+
+	// Compute the desired state once and apply it to every target
+	// concurrently, so one slow or broken target doesn't hold up the others
+	plan := s.buildPlan(iscLeases, changed)
+	plan.recordsChanged = recordsChanged
+	results := make([]TargetResult, len(s.targets))
+	var wg sync.WaitGroup
+	for i, t := range s.targets {
+		wg.Add(1)
+		go func(i int, t *syncTarget) {
+			defer wg.Done()
+			results[i] = s.syncTarget(ctx, t, plan)
+		}(i, t)
+	}
+	wg.Wait()
+	s.status.recordTargets(s.targets, results)
+	for _, result := range results {
+		if result.Err == nil {
+			metrics.managedClients.set(float64(result.Clients), result.Target)
+		}
+	}
+
+	var failed []string
+	catchUp := false
+	synced = true
+	for _, result := range results {
+		if result.NeedsRetry() {
+			catchUp = true
+		}
+		if result.Err != nil || result.Failed > 0 {
+			synced = false
+		}
+		if result.Err != nil {
+			logger.Error("Sync to target failed", result.attrs()...)
+			if ErrorKindOf(result.Err) == ErrorAuth {
+				logger.Error("Target rejected the credentials; check the username and password", "target", result.Target)
+			}
+			failed = append(failed, result.Target)
+			continue
+		}
+		logger.Info("Sync to target finished", result.attrs()...)
+	}
+
+	// Don't start anything new once the service is shutting down
+	if err := ctx.Err(); err != nil {
+		synced = false
+		return fmt.Errorf("sync cancelled: %w", err)
+	}
+
+	// Make sure targets that were down get the changes once they're back
+	if catchUp {
+		s.scheduleCatchUp()
+	}
+
+	// Write lease hostnames to local DNS files
+	for _, output := range s.dnsOutputs {
+		if !plan.recordsChanged {
+			break
+		}
+		records := s.buildDNSRecords(output.config.Filter.allowedLeases(iscLeases, plan.interfaces), output.config.Domain)
+		if err := output.Sync(ctx, records); err != nil {
+			logger.Error("Error writing DNS output", "output", output.config.Name, "error", err)
+			synced = false
+		}
+	}
+
+	// Push OPNsense static mappings into AdGuard's DHCP server. They only
+	// change with config.xml, whose changes trigger a full sync.
+	if s.staticLeases != nil && changes == nil {
+		if err := s.staticLeases.Sync(ctx); err != nil {
+			logger.Error("Error syncing static leases", "error", err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("sync failed for targets: %s", strings.Join(failed, ", "))
+	}
+
+	logger.Info("Sync completed", "duration", time.Since(start))
+	return nil
+}
+
+// syncTarget applies the plan to a single target, including its local DNS
+// records
+func (s *SyncService) syncTarget(ctx context.Context, t *syncTarget, plan *syncPlan) TargetResult {
+	start := time.Now()
+	result := TargetResult{Target: t.store.Name()}
+	ctx = withInterfaces(ctx, plan.interfaces)
+
+	// Don't hammer a target the circuit breaker considers down
+	if health, ok := t.store.(interface{ Available() (bool, time.Duration) }); ok {
+		if available, retryAfter := health.Available(); !available {
+			result.Err = &TargetError{Kind: ErrorTransient, Err: fmt.Errorf("%w, retrying in %s", ErrCircuitOpen, retryAfter.Round(time.Second))}
+			return result
+		}
+	}
+
+	s.indexAppliedTags(t)
+	changes, currentClients, err := s.targetChanges(ctx, t, plan)
+	if err != nil {
+		result.Err = err
+		result.Duration = time.Since(start)
+		return result
+	}
+
+	if s.dryRun {
+		for _, change := range changes {
+			s.log(ctx).Info("DRY-RUN: "+change.describe(), change.attrs(t.store.Name())...)
+		}
+		changes = nil
+	}
+	s.applyChanges(ctx, t, changes, &result)
+	result.Clients = len(currentClients) + result.Added - result.Removed
+	if len(changes) > 0 && s.state != nil && s.tags.Enabled() {
+		if err := s.state.Save(); err != nil {
+			s.log(ctx).Error("Error saving applied tags", "target", t.store.Name(), "error", err)
+		}
+	}
+
+	// Manage local DNS records for lease hostnames
+	if t.records != nil && plan.recordsChanged {
+		records := s.buildDNSRecords(t.filter.allowedLeases(plan.leases, plan.interfaces), t.records.domain)
+		if err := t.records.Sync(ctx, records); err != nil {
+			s.log(ctx).Error("Error syncing DNS records", "target", t.store.Name(), "error", err)
+			result.Failed++
+		}
+	}
+
+	if cached, ok := t.store.(interface{ CacheStats() CacheStats }); ok && s.debug {
+		s.log(ctx).Info("Client cache statistics", "target", t.store.Name(), "cache", cached.CacheStats())
+	}
+
+	result.Duration = time.Since(start)
+	return result
+}
+
+// targetChanges works out the client changes that bring a target in line
+// with the plan, returning them with the target's clients by MAC
+func (s *SyncService) targetChanges(ctx context.Context, t *syncTarget, plan *syncPlan) ([]clientChange, map[string]*StoreClient, error) {
+	// Get current clients from the target
+	currentClients, err := t.store.ListClients(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting %s clients: %w", t.store.Name(), err)
+	}
+
+	// Create MAC address lookup map
+	currentClientsMap := s.buildClientMap(ctx, currentClients)
 
 	// Track processed MACs
 	processedMACs := make(map[string]bool)
 
+	var changes []clientChange
+
 	// Process active leases
-	for mac, lease := range iscLeases {
-		if !lease.IsActive {
+	for mac, planned := range plan.active {
+		processedMACs[mac] = true
+
+		if !plan.affects(mac) {
+			continue
+		}
+
+		// Leases filtered out for this target are left alone
+		if !t.filter.Allows(planned.lease, plan.interfaces) {
 			if s.debug {
-				s.logger.Info(fmt.Sprintf("Skipping inactive lease for MAC %s", mac))
+				s.log(ctx).Info("Lease is filtered out for target", "mac", mac, "ip", planned.lease.IP, "target", t.store.Name())
 			}
 			continue
 		}
 
-		processedMACs[mac] = true
 		existing := currentClientsMap[mac]
 
 		// Retrive the update action
-		action, err := s.determineUpdateAction(lease, mac, existing)
+		action, err := s.determineUpdateAction(ctx, t, planned, mac, existing)
 		if err != nil {
-			s.logger.Error(fmt.Sprintf("Error determining update action for %s: %v", mac, err))
+			s.log(ctx).Error("Error determining update action", "mac", mac, "ip", planned.lease.IP, "target", t.store.Name(), "error", err)
 			continue
 		}
 
 		switch action.Type {
 		case Update:
-
-			if err := s.updateClient(existing, action); err != nil {
-				s.logger.Error(fmt.Sprintf("Error updating lease %s: %v", mac, err))
-			}
+			changes = append(changes, clientChange{action: action, existing: existing})
 		case Add:
-			if err := s.addClientWithRetry(action); err != nil {
-				s.logger.Error(fmt.Sprintf("Error adding lease %s: %v", mac, err))
-			}
+			changes = append(changes, clientChange{action: action})
 		}
 	}
 
 	// Handle stale clients
-	if err := s.handleStaleClients(currentClientsMap, processedMACs); err != nil {
-		s.logger.Error(fmt.Sprintf("Error handling stale clients: %v", err))
-	}
+	changes = append(changes, s.handleStaleClients(ctx, plan, currentClientsMap, processedMACs)...)
 
-	s.logger.Info("Sync completed")
-	return nil
+	return changes, currentClientsMap, nil
 }
 
-func (s *SyncService) handleStaleClients(currentClients map[string]*adguard.Client, processedMACs map[string]bool) error {
+// handleStaleClients returns the removals for clients without an active lease
+func (s *SyncService) handleStaleClients(ctx context.Context, plan *syncPlan, currentClients map[string]*StoreClient, processedMACs map[string]bool) []clientChange {
 	if s.preserveDeletedHosts {
 		if s.debug {
-			s.logger.Info("Skipping stale client removal (preserveDeletedHosts is enabled)")
+			s.log(ctx).Info("Skipping stale client removal (preserveDeletedHosts is enabled)")
 		}
 		return nil
 	}
 
 	if s.debug {
-		s.logger.Info("Checking for stale clients")
+		s.log(ctx).Info("Checking for stale clients")
 	}
 
+	var changes []clientChange
 	for mac, client := range currentClients {
-		if !processedMACs[mac] {
-			action := fmt.Sprintf("Removing stale client %s (%s)", client.Name, mac)
-			if s.dryRun {
-				s.logger.Info("DRY-RUN: " + action)
-				continue
-			}
-
-			if s.debug {
-				s.logger.Info(fmt.Sprintf("Found stale client - MAC: %s, Name: %s", mac, client.Name))
-			}
+		if processedMACs[mac] || !plan.affects(mac) {
+			continue
+		}
 
-			s.logger.Info(action)
-			if err := s.adguard.RemoveClient(client.Name); err != nil {
-				return fmt.Errorf("removing stale client %s: %w", mac, err)
-			}
+		if s.debug {
+			s.log(ctx).Info("Found stale client", "mac", mac, "hostname", client.Name)
 		}
+
+		changes = append(changes, clientChange{
+			action:   &AdguardUpdateAction{Type: Remove, Hostname: client.Name, MAC: mac},
+			existing: client,
+		})
 	}
 
-	return nil
+	return changes
 }
 
-func (s *SyncService) Run() error {
+// Run watches for lease and NDP changes and syncs them until ctx is
+// cancelled or Stop is called. It returns once the service has shut down.
+func (s *SyncService) Run(ctx context.Context) error {
 	s.logger.Info("Starting DHCP to AdGuard Home sync service")
 
-	// Start the NDP Watcher
-	s.ndpWatcher.Start()
-
-	// Get the lease file path
-	path := s.leases.Path()
-
-	// Convert to absolute path
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		return fmt.Errorf("getting absolute path: %w", err)
-	}
-
-	if s.debug {
-		s.logger.Info("Lease file absolute path: " + absPath)
-	}
-
-	// Verify the lease file exists
-	if _, err := os.Stat(absPath); err != nil {
-		return fmt.Errorf("accessing lease file: %w", err)
+	// Watch the lease files and the static mappings
+	if err := s.updateWatches(); err != nil {
+		return err
 	}
 
-	// Get the directory from the absolute path
-	leaseDir := filepath.Dir(absPath)
 	if s.debug {
-		s.logger.Info("Setting up watcher for directory: " + leaseDir)
+		s.logger.Info("File dhcpLeaseWatcher setup complete")
 	}
 
-	// Add directory to watcher
-	if err := s.dhcpLeaseWatcher.Add(leaseDir); err != nil {
-		return fmt.Errorf("watching lease directory: %w", err)
-	}
+	// Start the NDP Watcher
+	s.ndpWatcher.Start(s.ctx)
 
-	if s.debug {
-		s.logger.Info("File dhcpLeaseWatcher setup complete")
-	}
+	// Poll lease sources that cannot be watched
+	s.reloadMu.Lock()
+	s.startPollers()
+	s.reloadMu.Unlock()
 
-	// Perform initial sync
-	if err := s.Sync(); err != nil {
-		s.logger.Error(fmt.Sprintf("Initial sync failed: %v", err))
-	}
+	// Perform initial sync, then sync on triggers and periodically
+	s.scheduler.Trigger(ReasonStartup)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.scheduler.Run(s.ctx, s.stopping)
+	}()
 
-	var debounceTimer *time.Timer
-	const debounceDelay = 2 * time.Second
+	s.watchMu.Lock()
+	watchedFiles := len(s.watched)
+	s.watchMu.Unlock()
+	s.status.mu.Lock()
+	s.status.startedAt = time.Now()
+	s.status.mu.Unlock()
+	s.status.fileWatcher(func(h *WatcherHealth) {
+		h.Name = "lease files"
+		h.Running = true
+		h.Entries = watchedFiles
+	})
 
+	s.wg.Add(1)
 	go func() {
+		defer s.wg.Done()
+		defer s.status.fileWatcher(func(h *WatcherHealth) { h.Running = false })
 		for {
 			select {
 			case event, ok := <-s.dhcpLeaseWatcher.Events:
@@ -573,56 +802,41 @@ func (s *SyncService) Run() error {
 				}
 
 				if s.debug {
-					s.logger.Info(fmt.Sprintf("Received file event: %s on %s", event.Op, event.Name))
+					s.logger.Info("Received file event", "op", event.Op, "path", event.Name)
 				}
 
 				// Get absolute path for comparison
 				eventPath, err := filepath.Abs(event.Name)
 				if err != nil {
-					s.logger.Error(fmt.Sprintf("Failed to get absolute path for event: %v", err))
+					s.logger.Error("Failed to get absolute path for event", "path", event.Name, "error", err)
 					continue
 				}
 
-				// Get absolute path of our lease file
-				leaseAbsPath, _ := filepath.Abs(s.leases.Path())
-
-				// Check if this is the lease file we're monitoring
-				if eventPath != leaseAbsPath {
+				// Check if this is one of the files we're monitoring
+				watched, static := s.watchedEvent(eventPath)
+				if !watched {
 					if s.debug {
-						s.logger.Info(fmt.Sprintf("Ignoring event for non-target file - Event: %s, Target: %s",
-							eventPath, leaseAbsPath))
+						s.logger.Info("Ignoring event for non-target file", "path", eventPath)
 					}
 					continue
 				}
 
-				if debounceTimer != nil {
-					if s.debug {
-						s.logger.Info("Stopping previous debounce timer")
-					}
-					debounceTimer.Stop()
-				}
-
-				if s.debug {
-					s.logger.Info(fmt.Sprintf("Starting debounce timer (%s)", debounceDelay))
+				s.status.fileWatcher(func(h *WatcherHealth) { h.record(nil) })
+				if static {
+					s.scheduler.Trigger(ReasonStaticLeases)
+					continue
 				}
-
-				debounceTimer = time.AfterFunc(debounceDelay, func() {
-					if s.debug {
-						s.logger.Info("Debounce timer expired, starting sync")
-					}
-					if err := s.Sync(); err != nil {
-						s.logger.Error(fmt.Sprintf("Sync after file change failed: %v", err))
-					}
-				})
+				s.scheduler.Trigger(ReasonLeaseFile)
 
 			case err, ok := <-s.dhcpLeaseWatcher.Errors:
 				if !ok {
 					s.logger.Info("Watcher errors channel closed")
 					return
 				}
-				s.logger.Error(fmt.Sprintf("Watcher error: %v", err))
+				s.logger.Error("Watcher error", "error", err)
+				s.status.fileWatcher(func(h *WatcherHealth) { h.record(err) })
 
-			case <-s.done:
+			case <-s.stopping:
 				if s.debug {
 					s.logger.Info("Received shutdown signal")
 				}
@@ -631,10 +845,213 @@ func (s *SyncService) Run() error {
 		}
 	}()
 
+	// Block until shutdown; a cancelled ctx shuts down like Stop
+	select {
+	case <-ctx.Done():
+		return s.Stop()
+	case <-s.stopped:
+		return s.stopErr
+	}
+}
+
+// leaseReaders returns the configured lease readers, expanding a MultiLeaseReader
+func (s *SyncService) leaseReaders() []LeaseReader {
+	if multi, ok := s.leases.(*MultiLeaseReader); ok {
+		return multi.Readers()
+	}
+	return []LeaseReader{s.leases}
+}
+
+// watchedFiles returns the local files whose changes trigger a sync
+func (s *SyncService) watchedFiles() []string {
+	var files []string
+	for _, reader := range s.leaseReaders() {
+		if _, remote := reader.(RemoteLeaseReader); !remote {
+			files = append(files, reader.Path())
+		}
+	}
+	if s.staticLeases != nil {
+		files = append(files, s.staticLeases.Path())
+	}
+	return files
+}
+
+// resolveWatches returns the absolute paths of the files whose changes
+// trigger a sync and of the static mappings file, verifying they exist
+func (s *SyncService) resolveWatches() (map[string]bool, string, error) {
+	watched := make(map[string]bool)
+	for _, path := range s.watchedFiles() {
+		// Convert to absolute path
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("getting absolute path: %w", err)
+		}
+
+		// Verify the file exists
+		if _, err := os.Stat(absPath); err != nil {
+			return nil, "", fmt.Errorf("accessing watched file: %w", err)
+		}
+		watched[absPath] = true
+	}
+
+	// Changes to the static mappings are told apart from lease changes
+	var staticPath string
+	if s.staticLeases != nil {
+		path, err := filepath.Abs(s.staticLeases.Path())
+		if err != nil {
+			return nil, "", fmt.Errorf("getting absolute path: %w", err)
+		}
+		staticPath = path
+	}
+
+	return watched, staticPath, nil
+}
+
+// updateWatches points dhcpLeaseWatcher at the directories of the files
+// currently watched, dropping directories no longer needed
+func (s *SyncService) updateWatches() error {
+	watched, staticPath, err := s.resolveWatches()
+	if err != nil {
+		return err
+	}
+
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	dirs := make(map[string]bool)
+	for path := range watched {
+		if s.debug {
+			s.logger.Info("Watching file", "path", path)
+		}
+
+		// Get the directory from the absolute path
+		dir := filepath.Dir(path)
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		if s.watchDirs[dir] {
+			continue
+		}
+
+		if s.debug {
+			s.logger.Info("Setting up watcher for directory", "dir", dir)
+		}
+
+		// Add directory to watcher
+		if err := s.dhcpLeaseWatcher.Add(dir); err != nil {
+			return fmt.Errorf("watching directory %s: %w", dir, err)
+		}
+	}
+	for dir := range s.watchDirs {
+		if !dirs[dir] {
+			if err := s.dhcpLeaseWatcher.Remove(dir); err != nil {
+				s.logger.Warn("Failed to stop watching directory", "dir", dir, "error", err)
+			}
+		}
+	}
+
+	s.watched = watched
+	s.watchDirs = dirs
+	s.staticPath = staticPath
 	return nil
 }
 
+// watchedEvent reports whether path is a watched file and whether it is the
+// static mappings file
+func (s *SyncService) watchedEvent(path string) (watched, static bool) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	return s.watched[path], s.staticPath != "" && path == s.staticPath
+}
+
+// startPollers stops the running lease source pollers and starts one for
+// every remote lease reader. The caller holds reloadMu.
+func (s *SyncService) startPollers() {
+	if s.pollCancel != nil {
+		s.pollCancel()
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.pollCancel = cancel
+
+	var remotes []RemoteLeaseReader
+	for _, reader := range s.leaseReaders() {
+		if remote, ok := reader.(RemoteLeaseReader); ok {
+			remotes = append(remotes, remote)
+		}
+	}
+	s.status.resetSources(remotes)
+
+	for _, remote := range remotes {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.pollLeaseSource(ctx, remote)
+		}()
+	}
+}
+
+// pollLeaseSource periodically reads a remote lease source and triggers a
+// sync when its leases change
+func (s *SyncService) pollLeaseSource(ctx context.Context, reader RemoteLeaseReader) {
+	ticker := time.NewTicker(reader.PollInterval())
+	defer ticker.Stop()
+
+	record := func(leases map[string]ISCDHCPLease, err error) {
+		s.status.source(reader, func(h *WatcherHealth) {
+			h.Running = true
+			h.record(err)
+			if err == nil {
+				h.Entries = len(leases)
+			}
+		})
+	}
+	defer s.status.source(reader, func(h *WatcherHealth) { h.Running = false })
+
+	previous, err := reader.GetLeases(ctx)
+	record(previous, err)
+
+	for {
+		select {
+		case <-ticker.C:
+			leases, err := reader.GetLeases(ctx)
+			record(leases, err)
+			if err != nil {
+				s.logger.Error("Polling lease source failed", "source", reader.Path(), "error", err)
+				continue
+			}
+			if reflect.DeepEqual(leases, previous) {
+				continue
+			}
+			previous = leases
+
+			if s.debug {
+				s.logger.Info("Lease source changed", "source", reader.Path())
+			}
+			s.scheduler.Trigger(ReasonLeaseSource)
+		case <-s.stopping:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop shuts the service down. Pending syncs are dropped; a sync in
+// progress may finish within the shutdown timeout before its requests are
+// cancelled. No further requests are made once Stop returns. It is safe to
+// call Stop more than once.
 func (s *SyncService) Stop() error {
+	s.stopOnce.Do(func() {
+		s.stopErr = s.shutdown()
+		close(s.stopped)
+	})
+	<-s.stopped
+	return s.stopErr
+}
+
+// shutdown stops the background work and waits for it to finish
+func (s *SyncService) shutdown() error {
 	if s.debug {
 		s.logger.Info("Stop requested - shutting down sync service")
 	}
@@ -643,8 +1060,29 @@ func (s *SyncService) Stop() error {
 	// Stop NDP watcher first
 	s.ndpWatcher.Stop()
 
-	// Signal the main loop to stop
-	close(s.done)
+	// Stop accepting triggers, then give a running sync time to finish
+	s.reloadMu.Lock()
+	close(s.stopping)
+	s.reloadMu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(s.shutdownTimeout):
+		s.logger.Warn("Sync still running at the shutdown timeout, cancelling it", "timeout", s.shutdownTimeout)
+		s.cancel()
+		<-finished
+	}
+	s.cancel()
+
+	s.mu.RLock()
+	s.closeTargets(s.targets)
+	s.mu.RUnlock()
 
 	// Close the DHCP lease watcher
 	if err := s.dhcpLeaseWatcher.Close(); err != nil {
@@ -653,3 +1091,39 @@ func (s *SyncService) Stop() error {
 
 	return nil
 }
+
+// closeTargets ends the sessions of the targets' client stores
+func (s *SyncService) closeTargets(targets []*syncTarget) {
+	for _, t := range targets {
+		store, ok := t.store.(SessionStore)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := store.Close(ctx); err != nil {
+			s.logger.Warn("Error closing session", "target", t.store.Name(), "error", err)
+		}
+		cancel()
+	}
+}
+
+// DefaultShutdownTimeout is how long Stop waits for a running sync
+const DefaultShutdownTimeout = 30 * time.Second
+
+// defaultCatchUpDelay is the wait before retrying targets that failed
+// without a circuit breaker telling us when to try again
+const defaultCatchUpDelay = 30 * time.Second
+
+// scheduleCatchUp asks the scheduler for a sync once the failed targets are
+// expected to be reachable again
+func (s *SyncService) scheduleCatchUp() {
+	delay := defaultCatchUpDelay
+	for _, t := range s.targets {
+		if health, ok := t.store.(interface{ Available() (bool, time.Duration) }); ok {
+			if available, retryAfter := health.Available(); !available && retryAfter < delay {
+				delay = retryAfter
+			}
+		}
+	}
+	s.scheduler.CatchUp(delay)
+}
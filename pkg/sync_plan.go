@@ -0,0 +1,115 @@
+// pkg/sync_plan.go
+package pkg
+
+import (
+	"strings"
+	"time"
+)
+
+// plannedLease is the desired state for an active lease, computed once per
+// sync and shared by all targets
+type plannedLease struct {
+	lease   ISCDHCPLease
+	ids     []string // NDP IPv6 addresses followed by the lease IP
+	profile *ClientProfile
+	tags    []string
+}
+
+// syncPlan is the lease snapshot a sync run applies to every target
+type syncPlan struct {
+	leases map[string]ISCDHCPLease  // all leases, as read
+	active map[string]*plannedLease // active leases by MAC
+
+	// interfaces is the snapshot interface rules are matched against
+	interfaces Interfaces
+
+	// changed limits an incremental sync to these lower-case MACs; nil
+	// processes every MAC. active only holds the changed leases then.
+	changed map[string]bool
+	// recordsChanged is false when neither the leases nor the IPv6
+	// addresses of a MAC with DNS records changed, so DNS records and
+	// files are already up to date
+	recordsChanged bool
+}
+
+// affects reports whether the sync covers the MAC
+func (p *syncPlan) affects(mac string) bool {
+	return p.changed == nil || p.changed[strings.ToLower(mac)]
+}
+
+// buildPlan resolves IPv6 addresses, profiles and tags for the active leases,
+// limited to the changed MACs unless changed is nil
+func (s *SyncService) buildPlan(leases map[string]ISCDHCPLease, changed map[string]bool) *syncPlan {
+	plan := &syncPlan{
+		leases:         leases,
+		active:         make(map[string]*plannedLease),
+		changed:        changed,
+		recordsChanged: true,
+		interfaces:     LocalInterfaces(),
+	}
+
+	for mac, lease := range leases {
+		if !plan.affects(mac) {
+			continue
+		}
+		if !lease.IsActive {
+			if s.debug {
+				s.logger.Info("Skipping inactive lease", "mac", mac, "ip", lease.IP)
+			}
+			continue
+		}
+
+		ipv6IDs, err := s.ndpWatcher.GetIP6forMAC(mac)
+		if err != nil {
+			ipv6IDs = []string{}
+		}
+
+		planned := &plannedLease{
+			lease:   lease,
+			ids:     append(ipv6IDs, lease.IP),
+			profile: s.profiles.Match(lease, plan.interfaces),
+			tags:    s.tags.Tags(lease, plan.interfaces),
+		}
+		if s.debug && planned.profile != nil {
+			s.logger.Info("Lease matches profile", "mac", mac, "ip", lease.IP, "profile", planned.profile.Name)
+		}
+		plan.active[mac] = planned
+	}
+
+	return plan
+}
+
+// TargetResult summarizes a sync run against a single target
+type TargetResult struct {
+	Target   string
+	Added    int
+	Updated  int
+	Removed  int
+	Failed   int
+	Clients  int   // Clients with a MAC address after the sync
+	Err      error // Set when the target could not be synced at all
+	Duration time.Duration
+
+	// Transient counts failed changes that are worth retrying
+	Transient int
+}
+
+// NeedsRetry reports whether the target should be synced again soon,
+// because it was unreachable or some changes failed transiently. Errors
+// that weren't classified as transient, such as rejected credentials,
+// won't go away by themselves and wait for the next regular sync.
+func (r TargetResult) NeedsRetry() bool {
+	if r.Err != nil {
+		return ErrorKindOf(r.Err) == ErrorTransient
+	}
+	return r.Transient > 0
+}
+
+// attrs returns the result as log attributes
+func (r TargetResult) attrs() []any {
+	attrs := []any{"target", r.Target, "duration", r.Duration.Round(time.Millisecond)}
+	if r.Err != nil {
+		return append(attrs, "error", r.Err)
+	}
+	return append(attrs, "added", r.Added, "updated", r.Updated, "removed", r.Removed, "failed", r.Failed)
+}
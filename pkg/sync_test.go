@@ -0,0 +1,144 @@
+// pkg/sync_test.go
+package pkg
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestLogger returns a logger that discards everything
+func newTestLogger() Logger {
+	return &DualLogger{logger: slog.New(slog.NewTextHandler(io.Discard, nil)), level: new(slog.LevelVar)}
+}
+
+// stubNDP puts an ndp command listing no neighbors first in PATH, as the
+// NDP watcher runs ndp, which only exists on FreeBSD
+func stubNDP(t *testing.T) {
+	t.Helper()
+	bin := t.TempDir()
+	ndp := "#!/bin/sh\necho 'Neighbor Linklayer Address Netif Expire S Flags'\n"
+	if err := os.WriteFile(filepath.Join(bin, "ndp"), []byte(ndp), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// writeLeases writes dnsmasq leases, one "mac ip hostname" line each
+func writeLeases(t *testing.T, path string, leases ...string) {
+	t.Helper()
+	var content string
+	for _, lease := range leases {
+		content += "4102444800 " + lease + " *\n"
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestService creates a sync service reading leasePath and syncing to
+// the fake AdGuard server
+func newTestService(t *testing.T, server *httptest.Server, leasePath string) *SyncService {
+	t.Helper()
+	stubNDP(t)
+	service, err := NewSyncService(Config{
+		LeasePath:         leasePath,
+		LeaseFormat:       DNSMasqFormat,
+		Logger:            newTestLogger(),
+		NDPUpdateInterval: time.Hour,
+		ShutdownTimeout:   100 * time.Millisecond,
+		Targets: []TargetConfig{
+			{Name: "adguard", URL: server.URL, Username: "admin", Password: "secret", CacheTTL: -1, RequestsPerSecond: -1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSyncService: %v", err)
+	}
+	return service
+}
+
+func TestFailedSyncKeepsChanges(t *testing.T) {
+	fake, server := newFakeAdGuard(t)
+	leasePath := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	writeLeases(t, leasePath, "aa:bb:cc:dd:ee:01 192.168.1.10 laptop")
+	service := newTestService(t, server, leasePath)
+	ctx := context.Background()
+
+	if err := service.Sync(ctx); err != nil {
+		t.Fatalf("full sync: %v", err)
+	}
+
+	// The new lease reaches a target that rejects the credentials
+	writeLeases(t, leasePath, "aa:bb:cc:dd:ee:01 192.168.1.10 laptop", "aa:bb:cc:dd:ee:02 192.168.1.11 phone")
+	fake.mu.Lock()
+	fake.status = http.StatusUnauthorized
+	fake.mu.Unlock()
+	if err := service.sync(ctx, &ChangeSet{}); err == nil {
+		t.Fatal("incremental sync against a failing target succeeded")
+	}
+
+	// Once the target is fixed, the next incremental sync still adds it
+	fake.mu.Lock()
+	fake.status = 0
+	fake.mu.Unlock()
+	if err := service.sync(ctx, &ChangeSet{}); err != nil {
+		t.Fatalf("incremental sync: %v", err)
+	}
+	if got := fake.names(); len(got) != 2 {
+		t.Errorf("clients = %v, want laptop and phone", got)
+	}
+}
+
+func TestStopEndsAdGuardCalls(t *testing.T) {
+	fake, server := newFakeAdGuard(t)
+	release := make(chan struct{})
+	fake.listGate = release
+	defer close(release)
+
+	leasePath := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	writeLeases(t, leasePath, "aa:bb:cc:dd:ee:01 192.168.1.10 laptop")
+	service := newTestService(t, server, leasePath)
+	service.scheduler.debounce = 200 * time.Millisecond
+
+	done := make(chan error, 1)
+	go func() { done <- service.Run(context.Background()) }()
+
+	// The startup sync hangs on the client list
+	deadline := time.Now().Add(5 * time.Second)
+	for fake.count("/control/clients") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("startup sync didn't request the clients")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Leave a lease change waiting for the debounce
+	writeLeases(t, leasePath, "aa:bb:cc:dd:ee:01 192.168.1.10 laptop", "aa:bb:cc:dd:ee:02 192.168.1.11 phone")
+	service.scheduler.Trigger(ReasonLeaseFile)
+
+	if err := service.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	requests := fake.requestTotal()
+
+	// Neither the cancelled sync nor the pending one may reach AdGuard now
+	time.Sleep(3 * service.scheduler.debounce)
+	if got := fake.requestTotal(); got != requests {
+		t.Errorf("%d AdGuard requests after Stop returned", got-requests)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Run didn't return after Stop")
+	}
+}
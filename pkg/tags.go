@@ -0,0 +1,250 @@
+// pkg/tags.go
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TagRule assigns an AdGuard client tag to leases matching all of its
+// configured conditions
+type TagRule struct {
+	Tag         string
+	Hostname    *regexp.Regexp
+	Vendor      *regexp.Regexp // Matched against the OUI vendor name
+	VendorClass *regexp.Regexp // Matched against the DHCP vendor class identifier
+	Match       LeaseMatcher
+}
+
+// matches reports whether the lease satisfies every condition of the rule
+func (r *TagRule) matches(lease ISCDHCPLease, vendor string, interfaces Interfaces) bool {
+	if r.Hostname != nil && !r.Hostname.MatchString(lease.Hostname) {
+		return false
+	}
+	if r.Vendor != nil && (vendor == "" || !r.Vendor.MatchString(vendor)) {
+		return false
+	}
+	if r.VendorClass != nil && (lease.VendorClass == "" || !r.VendorClass.MatchString(lease.VendorClass)) {
+		return false
+	}
+	if !r.Match.Empty() && !r.Match.Matches(lease, interfaces) {
+		return false
+	}
+	return true
+}
+
+// ParseTagRule parses a tag rule of the form
+//
+//	tag:key=value,key=value
+//
+// Keys are hostname, vendor and vendor_class (case-insensitive regular
+// expressions) and cidr, interface and mac (as in profiles). All given
+// conditions must match, e.g.
+//
+//	device_phone:vendor=^Apple,hostname=iphone
+func ParseTagRule(spec string) (TagRule, error) {
+	def, err := ParseDefinition("tag rule", spec)
+	if err != nil {
+		return TagRule{}, err
+	}
+	return TagRuleFromDefinition(def)
+}
+
+// TagRuleFromDefinition builds a tag rule for the tag named by the
+// definition, using the keys described at ParseTagRule
+func TagRuleFromDefinition(def Definition) (TagRule, error) {
+	var rule TagRule
+
+	tag := def.Name
+	if !IsValidClientTag(tag) {
+		return rule, fmt.Errorf("tag rule %s: unknown AdGuard client tag", tag)
+	}
+	rule.Tag = tag
+
+	conditions := 0
+	for _, setting := range def.Settings {
+		key, value := setting.Key, setting.Value
+
+		switch key {
+		case "hostname", "vendor", "vendor_class":
+			re, err := regexp.Compile("(?i)" + value)
+			if err != nil {
+				return rule, fmt.Errorf("tag rule %s: invalid %s pattern: %w", tag, key, err)
+			}
+			switch key {
+			case "hostname":
+				rule.Hostname = re
+			case "vendor":
+				rule.Vendor = re
+			case "vendor_class":
+				rule.VendorClass = re
+			}
+		case "cidr", "interface", "mac":
+			if err := rule.Match.add(key, value); err != nil {
+				return rule, fmt.Errorf("tag rule %s: %w", tag, err)
+			}
+		default:
+			return rule, fmt.Errorf("tag rule %s: unknown key %q", tag, key)
+		}
+		conditions++
+	}
+
+	if conditions == 0 {
+		return rule, fmt.Errorf("tag rule %s: at least one condition is required", tag)
+	}
+
+	return rule, nil
+}
+
+// TagMapper derives AdGuard client tags from lease metadata
+type TagMapper struct {
+	rules []TagRule
+	oui   *OUIDatabase
+}
+
+// NewTagMapper creates a tag mapper from rule definitions. The OUI
+// database is optional and only needed for vendor rules.
+func NewTagMapper(specs []string, oui *OUIDatabase) (*TagMapper, error) {
+	defs, err := ParseDefinitions("tag rule", specs)
+	if err != nil {
+		return nil, err
+	}
+	return NewTagMapperFromDefinitions(defs, oui)
+}
+
+// NewTagMapperFromDefinitions creates a tag mapper from parsed rule
+// definitions
+func NewTagMapperFromDefinitions(defs []Definition, oui *OUIDatabase) (*TagMapper, error) {
+	mapper := &TagMapper{oui: oui}
+	for _, def := range defs {
+		rule, err := TagRuleFromDefinition(def)
+		if err != nil {
+			return nil, err
+		}
+		mapper.rules = append(mapper.rules, rule)
+	}
+	return mapper, nil
+}
+
+// Enabled reports whether any tag rules are configured
+func (t *TagMapper) Enabled() bool {
+	return t != nil && len(t.rules) > 0
+}
+
+// Tags returns the sorted, de-duplicated tags derived for a lease
+func (t *TagMapper) Tags(lease ISCDHCPLease, interfaces Interfaces) []string {
+	if !t.Enabled() {
+		return nil
+	}
+
+	vendor := t.oui.Vendor(lease.MAC)
+	var tags []string
+	for i := range t.rules {
+		if t.rules[i].matches(lease, vendor, interfaces) && !containsString(tags, t.rules[i].Tag) {
+			tags = append(tags, t.rules[i].Tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Merge combines a client's existing tags with the derived ones. The tags
+// the service applied earlier are replaced by the derived set; any other
+// tag was added by the user and is preserved.
+func (t *TagMapper) Merge(existing, applied, derived []string) []string {
+	if !t.Enabled() {
+		return existing
+	}
+
+	merged := make([]string, 0, len(existing)+len(derived))
+	for _, tag := range existing {
+		if !containsString(applied, tag) && !containsString(merged, tag) {
+			merged = append(merged, tag)
+		}
+	}
+	for _, tag := range derived {
+		if !containsString(merged, tag) {
+			merged = append(merged, tag)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+// tagSection is the ownership store section for the tags applied to the
+// clients of a target, keyed by "mac tag"
+func tagSection(target string) string {
+	return "tags:" + target
+}
+
+// appliedTagIndex holds the tags the service applied to the clients of a
+// target by MAC, so looking them up doesn't go through the whole section
+type appliedTagIndex struct {
+	mu   sync.Mutex
+	tags map[string][]string
+}
+
+// indexAppliedTags reads the tags applied to the target's clients from the
+// ownership store. It runs once per sync, before the target's clients are
+// compared; recordTags keeps the index up to date from then on.
+func (s *SyncService) indexAppliedTags(t *syncTarget) {
+	if s.state == nil || !s.tags.Enabled() {
+		t.applied = nil
+		return
+	}
+	index := &appliedTagIndex{tags: make(map[string][]string)}
+	for _, key := range s.state.Keys(tagSection(t.store.Name())) {
+		if mac, tag, ok := strings.Cut(key, " "); ok {
+			index.tags[mac] = append(index.tags[mac], tag)
+		}
+	}
+	t.applied = index
+}
+
+// appliedTags returns the tags the service applied to the client with mac
+func (s *SyncService) appliedTags(t *syncTarget, mac string) []string {
+	if t.applied == nil {
+		return nil
+	}
+	t.applied.mu.Lock()
+	defer t.applied.mu.Unlock()
+	return append([]string(nil), t.applied.tags[mac]...)
+}
+
+// recordTags records the derived tags set on the client with mac, except
+// those the user had already set on it, as applied by the service
+func (s *SyncService) recordTags(t *syncTarget, mac string, existing, derived []string) {
+	if s.state == nil || t.applied == nil {
+		return
+	}
+	t.applied.mu.Lock()
+	defer t.applied.mu.Unlock()
+
+	applied := t.applied.tags[mac]
+	section := tagSection(t.store.Name())
+	for _, tag := range applied {
+		s.state.Remove(section, mac+" "+tag)
+	}
+	var recorded []string
+	for _, tag := range derived {
+		if containsString(existing, tag) && !containsString(applied, tag) {
+			continue
+		}
+		s.state.Add(section, mac+" "+tag)
+		recorded = append(recorded, tag)
+	}
+
+	if len(recorded) == 0 {
+		delete(t.applied.tags, mac)
+		return
+	}
+	t.applied.tags[mac] = recorded
+}
+
+// forgetTags drops the applied tags of a removed client
+func (s *SyncService) forgetTags(t *syncTarget, mac string) {
+	s.recordTags(t, mac, nil, nil)
+}
@@ -0,0 +1,76 @@
+// pkg/tags_test.go
+package pkg
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAppliedTags(t *testing.T) {
+	state, err := NewOwnershipStore(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("NewOwnershipStore: %v", err)
+	}
+	mapper, err := NewTagMapper([]string{"device_phone:hostname=phone"}, nil)
+	if err != nil {
+		t.Fatalf("NewTagMapper: %v", err)
+	}
+	s := &SyncService{state: state, tags: mapper}
+	target := &syncTarget{store: &recordingStore{}}
+
+	section := tagSection(target.store.Name())
+	for _, key := range []string{"aa:bb:cc:dd:ee:01 device_phone", "aa:bb:cc:dd:ee:01 user_kids", "aa:bb:cc:dd:ee:02 device_phone"} {
+		state.Add(section, key)
+	}
+	state.Add(tagSection("other"), "aa:bb:cc:dd:ee:01 device_tv")
+
+	s.indexAppliedTags(target)
+	if got, want := s.appliedTags(target, "aa:bb:cc:dd:ee:01"), []string{"device_phone", "user_kids"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("applied tags = %v, want %v", got, want)
+	}
+
+	// Tags the user had set themselves aren't taken over
+	s.recordTags(target, "aa:bb:cc:dd:ee:01", []string{"device_tv", "user_kids"}, []string{"device_phone", "device_tv", "user_kids"})
+	s.forgetTags(target, "aa:bb:cc:dd:ee:02")
+	s.recordTags(target, "aa:bb:cc:dd:ee:03", nil, []string{"device_phone"})
+
+	want := map[string][]string{
+		"aa:bb:cc:dd:ee:01": {"device_phone", "user_kids"},
+		"aa:bb:cc:dd:ee:03": {"device_phone"},
+	}
+	for _, mac := range []string{"aa:bb:cc:dd:ee:01", "aa:bb:cc:dd:ee:02", "aa:bb:cc:dd:ee:03"} {
+		if got := s.appliedTags(target, mac); !reflect.DeepEqual(got, want[mac]) {
+			t.Errorf("applied tags of %s = %v, want %v", mac, got, want[mac])
+		}
+	}
+
+	// The index and the ownership store agree
+	wantKeys := []string{"aa:bb:cc:dd:ee:01 device_phone", "aa:bb:cc:dd:ee:01 user_kids", "aa:bb:cc:dd:ee:03 device_phone"}
+	if got := state.Keys(section); !reflect.DeepEqual(got, wantKeys) {
+		t.Errorf("state keys = %v, want %v", got, wantKeys)
+	}
+	if got := state.Keys(tagSection("other")); len(got) != 1 {
+		t.Errorf("keys of another target = %v, want them kept", got)
+	}
+	indexed := target.applied.tags
+	s.indexAppliedTags(target)
+	if !reflect.DeepEqual(target.applied.tags, indexed) {
+		t.Errorf("re-indexed tags = %v, want %v", target.applied.tags, indexed)
+	}
+}
+
+func TestAppliedTagsDisabled(t *testing.T) {
+	state, err := NewOwnershipStore(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("NewOwnershipStore: %v", err)
+	}
+	s := &SyncService{state: state}
+	target := &syncTarget{store: &recordingStore{}}
+
+	s.indexAppliedTags(target)
+	s.recordTags(target, "aa:bb:cc:dd:ee:01", nil, []string{"device_phone"})
+	if keys := state.Keys(tagSection(target.store.Name())); len(keys) != 0 {
+		t.Errorf("state keys without tag rules = %v, want none", keys)
+	}
+}
@@ -0,0 +1,239 @@
+// pkg/targets.go
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TargetType selects the ClientStore implementation for a target
+type TargetType string
+
+const (
+	AdGuardTarget TargetType = "adguard"
+	PiHoleTarget  TargetType = "pihole"
+)
+
+// TargetConfig describes a client store the leases are synced to
+type TargetConfig struct {
+	Name     string
+	Type     TargetType
+	URL      string
+	Username string
+	Password string
+	Scheme   string
+	Timeout  int
+
+	// CacheTTL is how long an AdGuard client list is reused; zero disables
+	// caching and a negative value inherits Config.ClientCacheTTL
+	CacheTTL time.Duration
+
+	// RequestsPerSecond limits the API requests sent to the target; zero is
+	// unlimited and a negative value inherits Config.RequestsPerSecond
+	RequestsPerSecond float64
+
+	// Naming and filtering applied to this target only
+	Naming NameFormat
+	Filter TargetFilter
+
+	// DNSDomain publishes lease hostnames as local DNS records under this
+	// domain on Pi-hole targets
+	DNSDomain string
+
+	// PiHoleGroups assigns Pi-hole groups to clients by subnet
+	PiHoleGroups []PiHoleGroupRule
+}
+
+// DefaultTarget returns the AdGuard Home target described by the
+// top-level connection settings
+func (c Config) DefaultTarget() TargetConfig {
+	return TargetConfig{
+		Name:     "adguard",
+		Type:     AdGuardTarget,
+		URL:      c.AdGuardURL,
+		Username: c.Username,
+		Password: c.Password,
+		Scheme:   c.Scheme,
+		Timeout:  c.Timeout,
+		CacheTTL: c.ClientCacheTTL,
+
+		RequestsPerSecond: c.RequestsPerSecond,
+	}
+}
+
+// SyncTargets returns the configured targets, falling back to the default
+// AdGuard Home target when none are configured
+func (c Config) SyncTargets() []TargetConfig {
+	if len(c.Targets) == 0 {
+		return []TargetConfig{c.DefaultTarget()}
+	}
+
+	targets := make([]TargetConfig, len(c.Targets))
+	for i, target := range c.Targets {
+		if target.CacheTTL < 0 {
+			target.CacheTTL = c.ClientCacheTTL
+		}
+		if target.RequestsPerSecond < 0 {
+			target.RequestsPerSecond = c.RequestsPerSecond
+		}
+		targets[i] = target
+	}
+	return targets
+}
+
+// newClientStore creates the ClientStore for a target
+func newClientStore(t TargetConfig) (ClientStore, error) {
+	switch t.Type {
+	case AdGuardTarget, "":
+		return NewAdGuard(t)
+	case PiHoleTarget:
+		return NewPiHole(t)
+	default:
+		return nil, fmt.Errorf("unknown target type %q", t.Type)
+	}
+}
+
+// ParseTarget parses a target definition of the form
+//
+//	name:key=value,key=value
+//
+// Connection keys are type, url, username, password, scheme, timeout,
+// cache_ttl (a duration such as 30s; 0 disables the AdGuard client cache)
+// and rps (API requests per second; 0 is unlimited).
+// Naming keys are name_format (using {hostname}, {mac}, {ip} and {profile})
+// and lowercase. Filtering keys are include_cidr, include_interface,
+// include_mac, exclude_cidr, exclude_interface and exclude_mac, e.g.
+//
+//	branch:type=adguard,url=10.2.0.1:3000,username=admin,password=secret,name_format={hostname}-branch,include_cidr=10.2.0.0/16
+//
+// Pi-hole targets (type=pihole) only use the password and additionally
+// accept group=name@cidr to assign a group to clients in a subnet and
+// dns_domain to publish local DNS records, e.g.
+//
+//	pihole:type=pihole,url=10.0.0.53,password=secret,group=kids@192.168.20.0/24,dns_domain=lan
+func ParseTarget(spec string) (TargetConfig, error) {
+	def, err := ParseDefinition("target", spec)
+	if err != nil {
+		return TargetConfig{}, err
+	}
+	return TargetFromDefinition(def)
+}
+
+// TargetFromDefinition builds a target from its settings, using the keys
+// described at ParseTarget
+func TargetFromDefinition(def Definition) (TargetConfig, error) {
+	target := TargetConfig{Type: AdGuardTarget, Scheme: "http", Timeout: 10, CacheTTL: -1, RequestsPerSecond: -1}
+	name := def.Name
+	target.Name = name
+
+	for _, setting := range def.Settings {
+		if err := target.set(setting.Key, setting.Value); err != nil {
+			return target, fmt.Errorf("target %s: %w", name, err)
+		}
+	}
+
+	if target.URL == "" {
+		return target, fmt.Errorf("target %s: url is required", name)
+	}
+	if target.Type != AdGuardTarget && target.Type != PiHoleTarget {
+		return target, fmt.Errorf("target %s: unknown type %q", name, target.Type)
+	}
+	if target.Type != PiHoleTarget && (target.DNSDomain != "" || len(target.PiHoleGroups) > 0) {
+		return target, fmt.Errorf("target %s: group and dns_domain are only supported by pihole targets", name)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return target, fmt.Errorf("target %s: scheme must be either 'http' or 'https'", name)
+	}
+
+	return target, nil
+}
+
+// ParseTargets parses a list of target definitions, rejecting duplicate names
+func ParseTargets(specs []string) ([]TargetConfig, error) {
+	defs, err := ParseDefinitions("target", specs)
+	if err != nil {
+		return nil, err
+	}
+	return TargetsFromDefinitions(defs)
+}
+
+// TargetsFromDefinitions builds the targets, rejecting duplicate names
+func TargetsFromDefinitions(defs []Definition) ([]TargetConfig, error) {
+	var targets []TargetConfig
+	seen := make(map[string]bool)
+	for _, def := range defs {
+		target, err := TargetFromDefinition(def)
+		if err != nil {
+			return nil, err
+		}
+		if seen[target.Name] {
+			return nil, fmt.Errorf("target %s: defined more than once", target.Name)
+		}
+		seen[target.Name] = true
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// set applies a single key=value pair to the target
+func (t *TargetConfig) set(key, value string) error {
+	switch key {
+	case "type":
+		t.Type = TargetType(strings.ToLower(value))
+	case "url":
+		t.URL = value
+	case "username":
+		t.Username = value
+	case "password":
+		t.Password = value
+	case "scheme":
+		t.Scheme = strings.ToLower(value)
+	case "timeout":
+		timeout, err := strconv.Atoi(value)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", value)
+		}
+		t.Timeout = timeout
+	case "cache_ttl":
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			return fmt.Errorf("invalid cache_ttl %q", value)
+		}
+		t.CacheTTL = ttl
+	case "rps":
+		rps, err := strconv.ParseFloat(value, 64)
+		if err != nil || rps < 0 {
+			return fmt.Errorf("invalid rps %q", value)
+		}
+		t.RequestsPerSecond = rps
+	case "name_format":
+		t.Naming.Format = value
+	case "lowercase":
+		lowercase, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for lowercase: %q", value)
+		}
+		t.Naming.Lowercase = lowercase
+	case "dns_domain":
+		t.DNSDomain = strings.Trim(strings.ToLower(value), ".")
+	case "group":
+		group, cidr, found := strings.Cut(value, "@")
+		if !found || group == "" {
+			return fmt.Errorf("invalid group %q: expected name@cidr", value)
+		}
+		rule := PiHoleGroupRule{Group: group}
+		if err := rule.Match.add("cidr", cidr); err != nil {
+			return err
+		}
+		t.PiHoleGroups = append(t.PiHoleGroups, rule)
+	case "include_cidr", "include_interface", "include_mac":
+		return t.Filter.Include.add(strings.TrimPrefix(key, "include_"), value)
+	case "exclude_cidr", "exclude_interface", "exclude_mac":
+		return t.Filter.Exclude.add(strings.TrimPrefix(key, "exclude_"), value)
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// pkg/trigger.go
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PlannedChange is a client change a sync would make
+type PlannedChange struct {
+	Target   string `json:"target"`
+	Action   string `json:"action"`
+	MAC      string `json:"mac"`
+	Hostname string `json:"hostname"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// SyncReport is the outcome of a sync requested over the control socket
+type SyncReport struct {
+	DryRun  bool               `json:"dry_run"`
+	Run     SyncRun            `json:"run"`
+	Targets []TargetSyncStatus `json:"targets"`
+	Planned []PlannedChange    `json:"planned,omitempty"` // Dry run only
+}
+
+// TriggerSync runs a full sync now and reports its outcome. Like every sync
+// it goes through the scheduler, so it waits for a running sync to finish
+// first.
+func (s *SyncService) TriggerSync(ctx context.Context) (SyncReport, error) {
+	run, err := s.scheduler.SyncNow(ctx, ReasonManual)
+	if err != nil {
+		return SyncReport{}, err
+	}
+	return SyncReport{Run: run, Targets: s.Status().Targets}, nil
+}
+
+// PlanSync works out the client changes a full sync would make now,
+// without applying anything. It runs between syncs like TriggerSync.
+func (s *SyncService) PlanSync(ctx context.Context) (SyncReport, error) {
+	report := SyncReport{DryRun: true, Targets: []TargetSyncStatus{}}
+	err := s.scheduler.Exclusive(ctx, func(ctx context.Context) error {
+		report.Run = SyncRun{StartedAt: time.Now(), Reasons: []string{string(ReasonManual)}, Full: true}
+		if err := s.plan(ctx, &report); err != nil {
+			report.Run.Error = err.Error()
+		}
+		report.Run.Duration = time.Since(report.Run.StartedAt)
+		return nil
+	})
+	return report, err
+}
+
+// plan adds the client changes of a full sync to the report
+func (s *SyncService) plan(ctx context.Context, report *SyncReport) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	leases, err := s.leases.GetLeases(ctx)
+	if err != nil {
+		return fmt.Errorf("getting DHCP leases: %w", err)
+	}
+	plan := s.buildPlan(leases, nil)
+	ctx = withInterfaces(ctx, plan.interfaces)
+
+	var failed []string
+	for _, t := range s.targets {
+		start := time.Now()
+		status := TargetSyncStatus{Name: t.store.Name(), SyncedAt: start}
+
+		s.indexAppliedTags(t)
+		changes, current, err := s.targetChanges(ctx, t, plan)
+		status.Duration = time.Since(start)
+		if err != nil {
+			status.Error = err.Error()
+			failed = append(failed, t.store.Name())
+			report.Targets = append(report.Targets, status)
+			continue
+		}
+
+		status.Clients = len(current)
+		for _, change := range changes {
+			switch change.action.Type {
+			case Add:
+				status.Added++
+			case Update:
+				status.Updated++
+			case Remove:
+				status.Removed++
+			}
+			report.Planned = append(report.Planned, PlannedChange{
+				Target:   t.store.Name(),
+				Action:   changeAction(change.action.Type),
+				MAC:      change.action.MAC,
+				Hostname: change.action.Hostname,
+				Reason:   change.action.Reason,
+			})
+		}
+		report.Targets = append(report.Targets, status)
+	}
+
+	sort.Slice(report.Planned, func(i, j int) bool {
+		a, b := report.Planned[i], report.Planned[j]
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.Action != b.Action {
+			return a.Action < b.Action
+		}
+		return a.Hostname < b.Hostname
+	})
+
+	if len(failed) > 0 {
+		return fmt.Errorf("planning failed for targets: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
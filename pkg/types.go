@@ -1,6 +1,12 @@
 package pkg
 
-import "github.com/fsnotify/fsnotify"
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
 
 // DHCP represents the DHCP lease file reader
 type DHCP struct {
@@ -9,23 +15,52 @@ type DHCP struct {
 
 // SyncService represents the DHCP to AdGuard sync service
 type SyncService struct {
-	adguard              *AdGuard
+	targets              []*syncTarget
 	leases               LeaseReader
 	logger               Logger
 	dhcpLeaseWatcher     *fsnotify.Watcher
 	ndpWatcher           *NDPTableWatcher // New field for NDP watcher
-	done                 chan bool
+	ctx                  context.Context  // Service lifetime, cancelled by Stop
+	cancel               context.CancelFunc
+	stopping             chan struct{} // Closed when Stop is called
+	stopped              chan struct{} // Closed once the shutdown has finished
+	stopOnce             sync.Once
+	stopErr              error
+	shutdownTimeout      time.Duration
+	wg                   sync.WaitGroup // Background goroutines that may sync
 	dryRun               bool
 	preserveDeletedHosts bool
 	debug                bool
+	profiles             ProfileSet
+	reapplyProfiles      bool
+	tags                 *TagMapper
+	workers              int // Concurrent changes per target
+	state                *OwnershipStore
+	staticLeases         *StaticLeaseWriter // nil unless static lease sync is enabled
+	dnsOutputs           []*DNSFileWriter
+	scheduler            *syncScheduler
+	lastLeases           map[string]ISCDHCPLease // Leases of the last sync that reached everything
+	unsynced             ChangeSet               // Changes of failed incremental syncs, retried with the next
+	status               statusRecorder
+
+	// Reload support
+	cfg        Config          // Configuration in effect
+	mu         sync.RWMutex    // Read-held by a sync, write-held while Reload swaps components
+	reloadMu   sync.Mutex      // Serializes starting pollers against shutdown
+	pollCancel func()          // Stops the lease source pollers; nil until Run
+	watchMu    sync.Mutex      // Guards the watch sets below
+	watched    map[string]bool // Absolute paths of the watched files
+	watchDirs  map[string]bool // Directories added to dhcpLeaseWatcher
+	staticPath string          // Absolute path of the static mappings file, if any
 }
 
 // ISCDHCPLease represents a lease from ISC DHCP server's lease file
 type ISCDHCPLease struct {
-	IP       string
-	Hostname string
-	MAC      string
-	IsActive bool
+	IP          string
+	Hostname    string
+	MAC         string
+	IsActive    bool
+	VendorClass string // DHCP vendor class identifier, if recorded
 }
 
 // AdGuardDHCPLease represents a current DHCP lease from AdGuard
@@ -57,6 +92,32 @@ type StaticDHCPResponse struct {
 	Leases []StaticDHCPLease `json:"static_leases"`
 }
 
+// AdGuardStatus is the server state reported by AdGuard's status endpoint
+type AdGuardStatus struct {
+	Version           string `json:"version"`
+	Running           bool   `json:"running"`
+	ProtectionEnabled bool   `json:"protection_enabled"`
+	DHCPAvailable     bool   `json:"dhcp_available"`
+}
+
+// AdGuardDHCPStatus represents the response from AdGuard's DHCP status endpoint,
+// which carries both the dynamic and the static leases
+type AdGuardDHCPStatus struct {
+	Enabled bool             `json:"enabled"`
+	V4      AdGuardDHCPRange `json:"v4"`
+	AdGuardDHCPResponse
+	StaticDHCPResponse
+}
+
+// AdGuardDHCPRange is the IPv4 network AdGuard's DHCP server hands out
+// addresses in
+type AdGuardDHCPRange struct {
+	GatewayIP  string `json:"gateway_ip"`
+	SubnetMask string `json:"subnet_mask"`
+	RangeStart string `json:"range_start"`
+	RangeEnd   string `json:"range_end"`
+}
+
 // UpdateAction represents what kind of update is needed for a client
 type AdguardUpdateAction struct {
 	Type        AdguardUpdateType
@@ -66,6 +127,8 @@ type AdguardUpdateAction struct {
 	IPFound     bool
 	Hostname    string
 	MAC         string
+	Profile     *ClientProfile
+	Tags        []string
 }
 
 type AdguardUpdateType int
@@ -74,4 +137,5 @@ const (
 	NoUpdate AdguardUpdateType = iota
 	Update
 	Add
+	Remove
 )